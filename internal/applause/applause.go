@@ -5,19 +5,63 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"regexp"
+	"slices"
 	"time"
 
 	"github.com/OpenSlides/openslides-go/datastore/dsfetch"
+	"github.com/OpenSlides/openslides-go/datastore/dskey"
 	"github.com/OpenSlides/openslides-go/datastore/flow"
+	"github.com/OpenSlides/openslides-go/oslog"
 	"github.com/OpenSlides/openslides-go/perm"
+	"github.com/OpenSlides/openslides-icc-service/internal/dschange"
 	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+	"github.com/OpenSlides/openslides-icc-service/internal/icchttp"
+	"github.com/OpenSlides/openslides-icc-service/internal/metric"
+	"github.com/OpenSlides/openslides-icc-service/internal/ratelimit"
 	"github.com/ostcar/topic"
 )
 
 const (
-	applauseInterval = time.Second
-	countTime        = 5 * time.Second
-	pruneTime        = 10 * time.Minute
+	// defaultInterval is the time between two calculations of the applause
+	// levels.
+	defaultInterval = time.Second
+
+	// defaultWindow is the time an applause is counted, if the meeting does
+	// not define an applause timeout.
+	defaultWindow = 5 * time.Second
+
+	// defaultMaxWindow is the maximum time an applause is counted, even if
+	// the meeting defines a longer timeout.
+	defaultMaxWindow = time.Minute
+
+	// defaultPruneTime is the time after that messages are removed from the
+	// topic.
+	defaultPruneTime = 10 * time.Minute
+
+	// defaultHoldTimeout is the time a held applause is counted without being
+	// started again.
+	defaultHoldTimeout = 10 * time.Second
+)
+
+// DefaultParticles are the ids of the particles, that can be sent in
+// meetings with the particle applause type.
+var DefaultParticles = []string{"clap", "heart", "star", "confetti"}
+
+// validParticle is the format of a particle id.
+var validParticle = regexp.MustCompile(`^[a-z0-9-]{1,32}$`)
+
+var (
+	metricConsumerLag = metric.NewHistogram(
+		"icc_applause_consumer_lag_messages",
+		"Number of messages an applause receiver is behind, when it reads a message.",
+		[]float64{0, 1, 10, 100, 1000, 10000},
+	)
+
+	metricSlowConsumers = metric.NewCounter(
+		"icc_applause_slow_consumers_total",
+		"Number of times an applause receiver fell so far behind, that messages were skipped.",
+	)
 )
 
 // Backend stores the applause messages.
@@ -26,11 +70,79 @@ type Backend interface {
 	//
 	// The function can be called many times. The implementation of the
 	// interface has to make sure, that the applause is only counted once.
-	ApplausePublish(meetingID, userID int, time int64) error
+	//
+	// If particle is not empty, it has to be counted each time.
+	ApplausePublish(meetingID, userID int, time int64, particle string) error
+
+	// ApplauseParticles returns the number of each particle in a meeting,
+	// that were sent from `from` until `to`, excluding `to`.
+	ApplauseParticles(meetingID int, from, to int64) (map[string]int, error)
+
+	// ApplauseChanged is a blocking function that returns the id of a meeting,
+	// that got new applause.
+	//
+	// It does not have to return the meeting for each applause, but it has to
+	// return it again, if the meeting had no applause in the last window.
+	//
+	// It is expected, that only one goroutine is calling this function.
+	ApplauseChanged(ctx context.Context) (meetingID int, err error)
+
+	// ApplauseCount returns the number of users, that applaused in a meeting
+	// since `time`. Users, that hold the applause button, must not be
+	// counted, since they are returned by ApplauseHolders.
+	ApplauseCount(meetingID int, time int64) (int, error)
 
-	// ApplauseSince returns the number of applause for each meeting since
-	// `time`
-	ApplauseSince(time int64) (map[int]int, error)
+	// ApplauseStart marks, that a user holds the applause button in a
+	// meeting until `expire`.
+	//
+	// The function is called again, while the user holds the button. The
+	// start time of the first call has to be kept.
+	ApplauseStart(meetingID, userID int, time, expire int64) error
+
+	// ApplauseStop marks, that a user released the applause button.
+	ApplauseStop(meetingID, userID int) error
+
+	// ApplauseHolders returns the start times of the users, that hold the
+	// applause button in a meeting and did not expire at `time`.
+	ApplauseHolders(meetingID int, time int64) ([]int64, error)
+
+	// ApplauseRecord saves an entry of the applause history of a meeting, if
+	// the state is different to the state of the last saved entry.
+	//
+	// The function is called from many instances. An entry with the same time
+	// replaces the old entry. An entry, that is older than the last entry, is
+	// ignored.
+	ApplauseRecord(meetingID int, time int64, state string, entry []byte) error
+
+	// ApplauseHistory returns all entries of the applause history of a
+	// meeting by their time.
+	ApplauseHistory(meetingID int) (map[int64][]byte, error)
+
+	// ApplauseThresholds returns the encoded thresholds of a meeting. Returns
+	// nil, if the meeting has no thresholds.
+	ApplauseThresholds(meetingID int) ([]byte, error)
+
+	// ApplauseSetThresholds saves the encoded thresholds of a meeting.
+	//
+	// The change has to be reported by ApplauseThresholdsChanged on all
+	// instances.
+	ApplauseSetThresholds(meetingID int, thresholds []byte) error
+
+	// ApplauseThresholdsChanged is a blocking function that returns the id of
+	// a meeting, where the thresholds changed.
+	ApplauseThresholdsChanged(ctx context.Context) (meetingID int, err error)
+
+	// ApplauseEventFirst saves an event of the applause episode of a meeting.
+	// It returns the start of the current episode and true, if the event was
+	// not saved before.
+	//
+	// EventStarted creates an episode with the given start, if the meeting has
+	// none. The other events are only saved for the episode with the given
+	// start. EventEnded removes the episode.
+	//
+	// The function is called from many instances for the same event. Only
+	// one of them has to get true.
+	ApplauseEventFirst(meetingID int, event string, start int64) (int64, bool, error)
 }
 
 // Applause holds the state of the service.
@@ -38,35 +150,233 @@ type Applause struct {
 	backend   Backend
 	topic     *topic.Topic[string]
 	datastore flow.Getter
+	limiter   *ratelimit.Limiter
+	changes   *dschange.Watcher
+
+	interval    time.Duration
+	window      time.Duration
+	maxWindow   time.Duration
+	pruneTime   time.Duration
+	holdTimeout time.Duration
+	particles   []string
+
+	// applauseChanged gets the ids of meetings with new applause.
+	applauseChanged chan int
+
+	// cache contains the present users, configs and thresholds of the
+	// meetings. It is nil, if there is no watcher for datastore changes.
+	cache *meetingCache
+
+	// configChanged gets the ids of meetings with a changed applause config.
+	// It is nil, if there is no watcher for datastore changes.
+	configChanged chan []int
+
+	// events gets the applause events. It is nil, if the events are only
+	// sent to the applause stream.
+	events EventPublisher
+}
+
+// Option configures the applause service.
+type Option func(*Applause)
+
+// WithRateLimit sets a rate limiter that is used for each applause.
+func WithRateLimit(l *ratelimit.Limiter) Option {
+	return func(a *Applause) {
+		a.limiter = l
+	}
+}
+
+// WithChanges sets the watcher for datastore changes. It is used to check the
+// permissions of the receivers again, when the datastore changes.
+func WithChanges(w *dschange.Watcher) Option {
+	return func(a *Applause) {
+		a.changes = w
+	}
+}
+
+// WithInterval sets the time between two calculations of the applause
+// levels. The default is one second.
+func WithInterval(interval time.Duration) Option {
+	return func(a *Applause) {
+		a.interval = interval
+	}
+}
+
+// WithWindow sets the time, an applause is counted.
+//
+// The window is used for meetings without an applause timeout. The timeout of
+// a meeting can not be longer than maxWindow. The defaults are five seconds and
+// one minute.
+func WithWindow(window, maxWindow time.Duration) Option {
+	return func(a *Applause) {
+		a.window = window
+		a.maxWindow = maxWindow
+	}
+}
+
+// WithPruneTime sets the time after that old applause messages are removed.
+// Receivers, that are behind more than this time, skip the old messages. The
+// default is ten minutes.
+func WithPruneTime(pruneTime time.Duration) Option {
+	return func(a *Applause) {
+		a.pruneTime = pruneTime
+	}
+}
+
+// WithHoldTimeout sets the time a held applause is counted. Clients have to
+// start the applause again before the timeout, while the user holds the
+// button. The default is ten seconds.
+func WithHoldTimeout(timeout time.Duration) Option {
+	return func(a *Applause) {
+		a.holdTimeout = timeout
+	}
+}
+
+// WithParticles sets the ids of the particles, that can be sent in meetings
+// with the particle applause type. The default is DefaultParticles.
+func WithParticles(particles []string) Option {
+	return func(a *Applause) {
+		a.particles = particles
+	}
 }
 
 // New returns an initialized state of the notify service.
-func New(b Backend, db flow.Getter) (*Applause, func(context.Context, func(error))) {
+func New(b Backend, db flow.Getter, options ...Option) (*Applause, func(context.Context, func(error))) {
 	notify := Applause{
-		backend:   b,
-		topic:     topic.New[string](),
-		datastore: db,
+		backend:         b,
+		topic:           topic.New[string](),
+		datastore:       db,
+		interval:        defaultInterval,
+		window:          defaultWindow,
+		maxWindow:       defaultMaxWindow,
+		pruneTime:       defaultPruneTime,
+		holdTimeout:     defaultHoldTimeout,
+		particles:       DefaultParticles,
+		applauseChanged: make(chan int),
+	}
+
+	for _, o := range options {
+		o(&notify)
 	}
 
 	// Make sure the topic is not empty.
 	notify.topic.Publish("")
 
+	var changeID uint64
+	if notify.changes != nil {
+		changeID = notify.changes.LastID()
+		notify.cache = newMeetingCache()
+		notify.configChanged = make(chan []int)
+	}
+
 	background := func(ctx context.Context, errHandler func(error)) {
+		go notify.listen(ctx, errHandler)
 		go notify.loop(ctx, errHandler)
 		go notify.pruneOldData(ctx)
+
+		if notify.changes != nil {
+			go notify.watchMeetings(ctx, changeID)
+			go notify.watchThresholds(ctx, errHandler)
+		}
 	}
 
 	return &notify, background
 }
 
+// ValidateParticles returns an error, if the list of particle ids is invalid.
+func ValidateParticles(particles []string) error {
+	for _, p := range particles {
+		if !validParticle.MatchString(p) {
+			return fmt.Errorf("invalid particle id `%s`. Only lowercase letters, numbers and `-` are allowed", p)
+		}
+
+		if p == imageParticle {
+			return fmt.Errorf("the particle id `%s` is reserved for the particle image of the meeting", p)
+		}
+	}
+	return nil
+}
+
 // MSG contians the current applause level and number of present users.
+//
+// Config is set on the first message of a stream and each time the config of
+// the meeting changes. Particles are the number of each particle, that was sent
+// since the last message. Event is set, if the level crossed a threshold of
+// the meeting.
 type MSG struct {
-	Level        int `json:"level"`
-	PresentUsers int `json:"present_users"`
+	Level        int            `json:"level"`
+	LevelPercent int            `json:"level_percent"`
+	PresentUsers int            `json:"present_users"`
+	Config       *Config        `json:"config,omitempty"`
+	Particles    map[string]int `json:"particles,omitempty"`
+	Event        string         `json:"event,omitempty"`
+
+	icchttp.StreamHeartbeat
+}
+
+// sameLevel returns true, if both messages have the same level and present
+// users.
+func (m MSG) sameLevel(other MSG) bool {
+	return m.Level == other.Level && m.LevelPercent == other.LevelPercent && m.PresentUsers == other.PresentUsers
 }
 
 // Send registers, that a user applaused in a meeting.
-func (a *Applause) Send(ctx context.Context, meetingID, userID int) error {
+//
+// If particle is not empty, it has to be one of the particles of the meeting
+// config. It is sent to all receivers.
+func (a *Applause) Send(ctx context.Context, meetingID, userID int, particle string) error {
+	if err := a.canSend(ctx, meetingID, userID); err != nil {
+		return err
+	}
+
+	if particle != "" {
+		config, err := a.config(ctx, meetingID)
+		if err != nil {
+			return fmt.Errorf("getting config: %w", err)
+		}
+
+		if !slices.Contains(config.Particles, particle) {
+			return iccerror.NewMessageError(iccerror.ErrInvalid, "Particle `%s` is not allowed in meeting %d.", particle, meetingID)
+		}
+	}
+
+	if err := a.backend.ApplausePublish(meetingID, userID, time.Now().Unix(), particle); err != nil {
+		return fmt.Errorf("publish applause in backend: %w", err)
+	}
+	return nil
+}
+
+// Start registers, that a user holds the applause button in a meeting.
+//
+// The applause is counted until Stop is called or the hold timeout is over.
+// It has to be called again before the timeout, while the user holds the
+// button. The longer the button is held, the more it counts.
+func (a *Applause) Start(ctx context.Context, meetingID, userID int) error {
+	if err := a.canSend(ctx, meetingID, userID); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if err := a.backend.ApplauseStart(meetingID, userID, now.Unix(), now.Add(a.holdTimeout).Unix()); err != nil {
+		return fmt.Errorf("start applause in backend: %w", err)
+	}
+	return nil
+}
+
+// Stop registers, that a user released the applause button in a meeting.
+func (a *Applause) Stop(ctx context.Context, meetingID, userID int) error {
+	if userID == 0 {
+		return iccerror.NewMessageError(iccerror.ErrNotAllowed, "Anonymous is not allowed to applause. Please be quiet.")
+	}
+
+	if err := a.backend.ApplauseStop(meetingID, userID); err != nil {
+		return fmt.Errorf("stop applause in backend: %w", err)
+	}
+	return nil
+}
+
+// canSend returns an error, if the user is not allowed to applause.
+func (a *Applause) canSend(ctx context.Context, meetingID, userID int) error {
 	if userID == 0 {
 		return iccerror.NewMessageError(iccerror.ErrNotAllowed, "Anonymous is not allowed to applause. Please be quiet.")
 	}
@@ -91,57 +401,184 @@ func (a *Applause) Send(ctx context.Context, meetingID, userID int) error {
 		return iccerror.NewMessageError(iccerror.ErrNotAllowed, "You can not see the Livestream from meeting %d. Please be quiet.", meetingID)
 	}
 
-	if err := a.backend.ApplausePublish(meetingID, userID, time.Now().Unix()); err != nil {
-		return fmt.Errorf("publish applause in backend: %w", err)
+	if err := a.limiter.Allow("applause", userID, "", meetingID); err != nil {
+		return fmt.Errorf("rate limit: %w", err)
 	}
 	return nil
 }
 
 // CanReceive returns an error, if the user can not receive applause.
-func (a *Applause) CanReceive(ctx context.Context, meetingID, userID int) error {
-	fetcher := dsfetch.New(a.datastore)
+//
+// Anonymous users can receive applause, if the meeting allows anonymous users.
+// If projectorID is not 0, the user can also receive applause with the
+// permission to see the projectors of the meeting.
+//
+// The returned context is canceled with an error of the type
+// iccerror.ErrNotAllowed, when the user looses the permission. This only
+// works, if the service was created with the option WithChanges().
+func (a *Applause) CanReceive(ctx context.Context, meetingID, userID, projectorID int) (context.Context, error) {
+	if a.changes == nil {
+		if err := a.canReceive(ctx, a.datastore, meetingID, userID, projectorID); err != nil {
+			return nil, err
+		}
+		return ctx, nil
+	}
+
+	changeID := a.changes.LastID()
+	recorder := dschange.NewRecorder(a.datastore)
+	if err := a.canReceive(ctx, recorder, meetingID, userID, projectorID); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	go a.watchPermission(ctx, cancel, changeID, recorder.Keys(), meetingID, userID, projectorID)
+	return ctx, nil
+}
+
+// watchPermission checks the permission each time, one of the keys changes.
+func (a *Applause) watchPermission(ctx context.Context, cancel context.CancelCauseFunc, changeID uint64, keys []dskey.Key, meetingID, userID, projectorID int) {
+	for {
+		var err error
+		changeID, err = a.changes.Wait(ctx, changeID, keys)
+		if err != nil {
+			return
+		}
+
+		recorder := dschange.NewRecorder(a.datastore)
+		err = a.canReceive(ctx, recorder, meetingID, userID, projectorID)
+		if errors.Is(err, iccerror.ErrNotAllowed) {
+			cancel(err)
+			return
+		}
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			// Keep the stream open and wait for the next change of the
+			// old keys.
+			oslog.Error("Checking applause permission of user %d: %v", userID, err)
+			continue
+		}
+
+		keys = recorder.Keys()
+	}
+}
+
+func (a *Applause) canReceive(ctx context.Context, getter flow.Getter, meetingID, userID, projectorID int) error {
+	fetcher := dsfetch.New(getter)
+
+	if userID == 0 {
+		anonymousEnabled, err := fetcher.Meeting_EnableAnonymous(meetingID).Value(ctx)
+		if err != nil {
+			var errDoesNotExist dsfetch.DoesNotExistError
+			if !errors.As(err, &errDoesNotExist) {
+				return fmt.Errorf("fetching anonymous enabled: %w", err)
+			}
+		}
+
+		if !anonymousEnabled {
+			return iccerror.NewMessageError(iccerror.ErrNotAllowed, "Anonymous users are not allowed in meeting %d.", meetingID)
+		}
+	}
 
 	perms, err := perm.New(ctx, fetcher, userID, meetingID)
 	if err != nil {
 		return fmt.Errorf("getting permissions: %w", err)
 	}
 
-	fmt.Println(perms)
+	if perms.Has(perm.MeetingCanSeeLivestream) {
+		return nil
+	}
 
-	if !perms.Has(perm.MeetingCanSeeLivestream) {
+	if projectorID == 0 {
 		return iccerror.NewMessageError(iccerror.ErrNotAllowed, "You can not see the Livestream from meeting %d.", meetingID)
 	}
+
+	projectorMeetingID, err := fetcher.Projector_MeetingID(projectorID).Value(ctx)
+	if err != nil {
+		var errDoesNotExist dsfetch.DoesNotExistError
+		if !errors.As(err, &errDoesNotExist) {
+			return fmt.Errorf("fetching meeting of projector %d: %w", projectorID, err)
+		}
+	}
+
+	if projectorMeetingID != meetingID || !perms.Has(perm.ProjectorCanSee) {
+		return iccerror.NewMessageError(iccerror.ErrNotAllowed, "You can not see the projector %d from meeting %d.", projectorID, meetingID)
+	}
 	return nil
 }
 
 // Receive returns the applause for a given meeting.
 func (a *Applause) Receive(ctx context.Context, tid uint64, meetingID int) (newTID uint64, msg MSG, err error) {
 	if tid == 0 {
+		tid = a.topic.LastID()
+
 		present, err := a.presentUser(ctx, meetingID)
 		if err != nil {
 			return 0, MSG{}, fmt.Errorf("fetching present user: %w", err)
 		}
-		return a.topic.LastID(), MSG{0, present}, nil
+
+		config, err := a.config(ctx, meetingID)
+		if err != nil {
+			return 0, MSG{}, fmt.Errorf("fetching config: %w", err)
+		}
+
+		return tid, MSG{PresentUsers: present, Config: &config}, nil
 	}
 
 	for {
+		metricConsumerLag.Observe(float64(a.topic.LastID() - tid))
+
 		var messages []string
 		tid, messages, err = a.topic.ReceiveSince(ctx, tid)
 		if err != nil {
-			return 0, MSG{}, fmt.Errorf("receiving message from topic: %w", err)
+			var errUnknownID topic.UnknownIDError
+			if !errors.As(err, &errUnknownID) {
+				return 0, MSG{}, fmt.Errorf("receiving message from topic: %w", err)
+			}
+
+			// The receiver is so slow, that its messages were already
+			// pruned. Since only the newest applause level is relevant, the
+			// old messages can be skipped.
+			metricSlowConsumers.Inc()
+			tid = a.topic.LastID()
+			continue
 		}
 
 		// We are intressted in the last message that has a entry for our
-		// meeting. We go backwards throw the messages and return, if we find
-		// something.
+		// meeting. We go backwards throw the messages. If the last message has
+		// no event, the newest event of the older messages is kept, so the
+		// receiver does not miss it.
+		var found bool
 		for i := len(messages) - 1; i >= 0; i-- {
 			var message map[int]MSG
 			if err := json.Unmarshal([]byte(messages[i]), &message); err != nil {
 				return 0, MSG{}, fmt.Errorf("decoding message from topic: %w", err)
 			}
-			if meetingData, ok := message[meetingID]; ok {
-				return tid, meetingData, nil
+
+			meetingData, ok := message[meetingID]
+			if !ok {
+				continue
 			}
+
+			if !found {
+				msg = meetingData
+				found = true
+			}
+
+			if msg.Event == "" {
+				msg.Event = meetingData.Event
+			}
+
+			if msg.Event != "" {
+				break
+			}
+		}
+
+		if found {
+			return tid, msg, nil
 		}
 	}
 }
@@ -151,49 +588,132 @@ func (a *Applause) LastID() uint64 {
 	return a.topic.LastID()
 }
 
-// loop fetches the applause from the backend and saves it for the clients to
-// fetch.
+// listen waits for meetings with new applause and sends them to the loop.
+func (a *Applause) listen(ctx context.Context, errHandler func(error)) {
+	if errHandler == nil {
+		errHandler = func(error) {}
+	}
+
+	for {
+		meetingID, err := a.backend.ApplauseChanged(ctx)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+
+			errHandler(fmt.Errorf("receiving applause changes from backend: %w", err))
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		select {
+		case a.applauseChanged <- meetingID:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// loop calculates the applause levels of the meetings with applause and saves
+// them for the clients to fetch.
+//
+// Only meetings, that got applause in their window, are calculated. Meetings
+// without applause cost nothing.
 func (a *Applause) loop(ctx context.Context, errHandler func(error)) {
 	if errHandler == nil {
 		errHandler = func(error) {}
 	}
 
-	lastApplause := make(map[int]int)
+	// lastApplause contains the last message of each meeting with applause.
+	lastApplause := make(map[int]MSG)
+
+	// episodes contains the threshold state of each meeting with applause.
+	episodes := make(map[int]episode)
+
+	// lastTick is the second of the last calculation. The particles are
+	// fetched since this second.
+	var lastTick int64
+
+	tick := time.NewTicker(a.interval)
+	defer tick.Stop()
 
 	for {
-		if err := contextSleep(ctx, applauseInterval); err != nil {
+		select {
+		case <-ctx.Done():
 			return
+
+		case meetingID := <-a.applauseChanged:
+			if _, ok := lastApplause[meetingID]; !ok {
+				lastApplause[meetingID] = MSG{}
+			}
+			continue
+
+		case meetingIDs := <-a.configChanged:
+			if err := a.publishConfig(ctx, meetingIDs, lastApplause); err != nil {
+				errHandler(fmt.Errorf("publishing applause config: %w", err))
+			}
+			continue
+
+		case <-tick.C:
 		}
 
-		d := time.Now().Add(-countTime)
-		applause, err := a.backend.ApplauseSince(d.Unix())
-		if err != nil {
-			errHandler(fmt.Errorf("fetching applause: %w", err))
+		if len(lastApplause) == 0 {
 			continue
 		}
 
-		// Set values that are in lastApplause but not in applause to 0.
-		for k := range lastApplause {
-			if _, ok := applause[k]; !ok {
-				applause[k] = 0
-			}
+		now := time.Now()
+		if lastTick == 0 {
+			lastTick = now.Unix() - 1
 		}
 
 		message := make(map[int]MSG)
-		for meetingID, level := range applause {
-			if lastApplause[meetingID] == level {
+		for meetingID, last := range lastApplause {
+			msg, count, err := a.toMSG(ctx, meetingID, now)
+			if err != nil {
+				errHandler(fmt.Errorf("converting applause to MSG: %w", err))
 				continue
 			}
-			lastApplause[meetingID] = level
 
-			msg, err := a.toMSG(ctx, meetingID, level)
+			particles, err := a.backend.ApplauseParticles(meetingID, lastTick, now.Unix())
 			if err != nil {
-				errHandler(fmt.Errorf("converting level to MSG: %w", err))
+				errHandler(fmt.Errorf("fetching particles: %w", err))
+			}
+
+			if err := a.record(ctx, meetingID, HistoryEntry{Time: now.Unix(), Level: msg.Level}); err != nil {
+				errHandler(fmt.Errorf("recording applause history: %w", err))
+			}
+
+			event, err := a.evaluateEvent(meetingID, msg, episodes)
+			if err != nil {
+				errHandler(fmt.Errorf("evaluating applause thresholds: %w", err))
+			}
+
+			if event != "" {
+				if err := a.publishEvent(meetingID, event, msg, now.Unix(), episodes); err != nil {
+					errHandler(fmt.Errorf("publishing applause event: %w", err))
+				}
+			}
+
+			// Forget meetings without applause, so they are not calculated
+			// on each tick. The backend reports them again, when they get
+			// new applause.
+			lastApplause[meetingID] = msg
+			if count == 0 {
+				delete(lastApplause, meetingID)
+				delete(episodes, meetingID)
+			}
+
+			if last.sameLevel(msg) && len(particles) == 0 && event == "" {
 				continue
 			}
 
+			if len(particles) > 0 {
+				msg.Particles = particles
+			}
+			msg.Event = event
 			message[meetingID] = msg
 		}
+		lastTick = now.Unix()
 
 		if len(message) == 0 {
 			continue
@@ -208,17 +728,40 @@ func (a *Applause) loop(ctx context.Context, errHandler func(error)) {
 	}
 }
 
-// toMSG converts a int (applause level) to a MSG object.
-func (a *Applause) toMSG(ctx context.Context, meetingID, level int) (MSG, error) {
+// toMSG calculates the applause level of a meeting. It also returns the number
+// of users, that applaused in the window of the meeting or hold the applause
+// button.
+func (a *Applause) toMSG(ctx context.Context, meetingID int, now time.Time) (MSG, int, error) {
 	presentUser, err := a.presentUser(ctx, meetingID)
 	if err != nil {
-		return MSG{}, fmt.Errorf("getting present Users: %w", err)
+		return MSG{}, 0, fmt.Errorf("getting present Users: %w", err)
+	}
+
+	config, err := a.config(ctx, meetingID)
+	if err != nil {
+		return MSG{}, 0, fmt.Errorf("getting config: %w", err)
 	}
 
+	// Get the holders first, since it removes the expired holders, that are
+	// not counted as applause.
+	holds, err := a.backend.ApplauseHolders(meetingID, now.Unix())
+	if err != nil {
+		return MSG{}, 0, fmt.Errorf("getting applause holders: %w", err)
+	}
+
+	claps, err := a.backend.ApplauseCount(meetingID, now.Add(-a.meetingWindow(config)).Unix())
+	if err != nil {
+		return MSG{}, 0, fmt.Errorf("counting applause: %w", err)
+	}
+
+	count := claps + len(holds)
+	level, percent := scaleLevel(weightedCount(claps, holds, now), presentUser, config)
+
 	return MSG{
-		level,
-		presentUser,
-	}, nil
+		Level:        level,
+		LevelPercent: percent,
+		PresentUsers: presentUser,
+	}, count, nil
 }
 
 // pruneOldData removes applause data.
@@ -231,37 +774,7 @@ func (a *Applause) pruneOldData(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-tick.C:
-			a.topic.Prune(time.Now().Add(-pruneTime))
+			a.topic.Prune(time.Now().Add(-a.pruneTime))
 		}
 	}
 }
-
-// presentUser returns the number of users in this meeting.
-func (a *Applause) presentUser(ctx context.Context, meetingID int) (int, error) {
-	fetch := dsfetch.New(a.datastore)
-	ids, err := fetch.Meeting_PresentUserIDs(meetingID).Value(ctx)
-	if err != nil {
-		var errDoesNotExist dsfetch.DoesNotExistError
-		if !errors.As(err, &errDoesNotExist) {
-			return 0, fmt.Errorf("get present users for meeting %d: %w", meetingID, err)
-		}
-	}
-	return len(ids), nil
-}
-
-// contextSleep is like time.Sleep but also takes a context.
-//
-// It returns either when the time is up.
-//
-// Returns ctx.Err() if the context was canceled.
-func contextSleep(ctx context.Context, d time.Duration) error {
-	timer := time.NewTimer(d)
-	defer timer.Stop()
-
-	select {
-	case <-timer.C:
-		return nil
-	case <-ctx.Done():
-		return ctx.Err()
-	}
-}
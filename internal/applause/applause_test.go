@@ -3,10 +3,16 @@ package applause_test
 import (
 	"context"
 	"errors"
+	"fmt"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/OpenSlides/openslides-go/datastore/dskey"
 	"github.com/OpenSlides/openslides-go/datastore/dsmock"
 	"github.com/OpenSlides/openslides-icc-service/internal/applause"
+	"github.com/OpenSlides/openslides-icc-service/internal/dschange"
 	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
 )
 
@@ -20,7 +26,7 @@ func TestApplauseCanReceiveInMeeting(t *testing.T) {
 		`))
 		app, _ := applause.New(backend, ds)
 
-		err := app.CanReceive(ctx, 1, 5)
+		_, err := app.CanReceive(ctx, 1, 5, 0)
 
 		if !errors.Is(err, iccerror.ErrNotAllowed) {
 			t.Errorf("Got error `%v`, expected `%v`", err, iccerror.ErrNotAllowed)
@@ -40,7 +46,7 @@ func TestApplauseCanReceiveInMeeting(t *testing.T) {
 		`))
 		app, _ := applause.New(backend, ds)
 
-		err := app.CanReceive(ctx, 1, 5)
+		_, err := app.CanReceive(ctx, 1, 5, 0)
 
 		if err != nil {
 			t.Errorf("Got error `%v`, expected `nil`", err)
@@ -60,10 +66,290 @@ func TestApplauseCanReceiveInMeeting(t *testing.T) {
 		`))
 		app, _ := applause.New(backend, ds)
 
-		err := app.CanReceive(ctx, 1, 5)
+		_, err := app.CanReceive(ctx, 1, 5, 0)
 
 		if !errors.Is(err, iccerror.ErrNotAllowed) {
 			t.Errorf("Got error `%v`, expected `%v`", err, iccerror.ErrNotAllowed)
 		}
 	})
+
+	t.Run("Anonymous in public meeting", func(t *testing.T) {
+		ds := dsmock.Stub(dsmock.YAMLData(`---
+		meeting/1:
+			enable_anonymous: true
+			anonymous_group_id: 13
+			default_group_id: 13
+			admin_group_id: 1
+		group/13/permissions: [meeting.can_see_livestream]
+		`))
+		app, _ := applause.New(new(backendStub), ds)
+
+		_, err := app.CanReceive(ctx, 1, 0, 0)
+
+		if err != nil {
+			t.Errorf("Got error `%v`, expected `nil`", err)
+		}
+	})
+
+	t.Run("Anonymous in closed meeting", func(t *testing.T) {
+		ds := dsmock.Stub(dsmock.YAMLData(`---
+		meeting/1:
+			enable_anonymous: false
+			anonymous_group_id: 13
+			default_group_id: 13
+			admin_group_id: 1
+		group/13/permissions: [meeting.can_see_livestream]
+		`))
+		app, _ := applause.New(new(backendStub), ds)
+
+		_, err := app.CanReceive(ctx, 1, 0, 0)
+
+		if !errors.Is(err, iccerror.ErrNotAllowed) {
+			t.Errorf("Got error `%v`, expected `%v`", err, iccerror.ErrNotAllowed)
+		}
+	})
+
+	projectorData := `---
+	user/5/meeting_user_ids: [50]
+	meeting_user/50:
+		meeting_id: 1
+		user_id: 5
+		group_ids: [13]
+	group/13/permissions: [projector.can_see]
+	meeting/1/admin_group_id: 1
+	projector/3/meeting_id: 1
+	projector/4/meeting_id: 2
+	`
+
+	t.Run("Projector", func(t *testing.T) {
+		app, _ := applause.New(new(backendStub), dsmock.Stub(dsmock.YAMLData(projectorData)))
+
+		_, err := app.CanReceive(ctx, 1, 5, 3)
+
+		if err != nil {
+			t.Errorf("Got error `%v`, expected `nil`", err)
+		}
+	})
+
+	t.Run("Projector without projector id", func(t *testing.T) {
+		app, _ := applause.New(new(backendStub), dsmock.Stub(dsmock.YAMLData(projectorData)))
+
+		_, err := app.CanReceive(ctx, 1, 5, 0)
+
+		if !errors.Is(err, iccerror.ErrNotAllowed) {
+			t.Errorf("Got error `%v`, expected `%v`", err, iccerror.ErrNotAllowed)
+		}
+	})
+
+	t.Run("Projector of other meeting", func(t *testing.T) {
+		app, _ := applause.New(new(backendStub), dsmock.Stub(dsmock.YAMLData(projectorData)))
+
+		_, err := app.CanReceive(ctx, 1, 5, 4)
+
+		if !errors.Is(err, iccerror.ErrNotAllowed) {
+			t.Errorf("Got error `%v`, expected `%v`", err, iccerror.ErrNotAllowed)
+		}
+	})
+}
+
+// changingDatastore is a datastore where the data can be replaced.
+type changingDatastore struct {
+	mu   sync.Mutex
+	data dsmock.Stub
+}
+
+func (d *changingDatastore) Get(ctx context.Context, keys ...dskey.Key) (map[dskey.Key][]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.data.Get(ctx, keys...)
+}
+
+func (d *changingDatastore) set(data dsmock.Stub) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.data = data
+}
+
+func TestApplausePermissionRevoked(t *testing.T) {
+	data := `---
+	user/5/meeting_user_ids: [50]
+	meeting_user/50:
+		meeting_id: 1
+		user_id: 5
+		group_ids: [13]
+	group/13/permissions: [%s]
+	meeting/1/admin_group_id: 1
+	`
+	ds := &changingDatastore{data: dsmock.Stub(dsmock.YAMLData(fmt.Sprintf(data, "meeting.can_see_livestream")))}
+	changes, changesBG := dschange.New()
+	go changesBG(t.Context(), nil)
+	app, _ := applause.New(new(backendStub), ds, applause.WithChanges(changes))
+
+	ctx, err := app.CanReceive(t.Context(), 1, 5, 0)
+	if err != nil {
+		t.Fatalf("CanReceive: %v", err)
+	}
+
+	ds.set(dsmock.Stub(dsmock.YAMLData(fmt.Sprintf(data, ""))))
+	changes.Update(map[dskey.Key][]byte{dskey.MustKey("group/13/permissions"): []byte("[]")}, nil)
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("context was not canceled after the permission was revoked")
+	}
+
+	if err := context.Cause(ctx); !errors.Is(err, iccerror.ErrNotAllowed) {
+		t.Errorf("context was canceled with `%v`, expected `%v`", err, iccerror.ErrNotAllowed)
+	}
+}
+
+func TestApplauseConfig(t *testing.T) {
+	data := `---
+	meeting/1:
+		applause_enable: %t
+		applause_type: applause-type-bar
+		applause_show_level: true
+		applause_min_amount: 1
+		applause_max_amount: 10
+		applause_timeout: 5
+		present_user_ids: [5]
+	`
+	ds := &changingDatastore{data: dsmock.Stub(dsmock.YAMLData(fmt.Sprintf(data, true)))}
+	changes, changesBG := dschange.New()
+	go changesBG(t.Context(), nil)
+	app, bg := applause.New(new(backendStub), ds, applause.WithChanges(changes))
+	go bg(t.Context(), nil)
+
+	tid, msg, err := app.Receive(t.Context(), 0, 1)
+	if err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+
+	expect := applause.Config{
+		Enabled:   true,
+		Type:      "applause-type-bar",
+		ShowLevel: true,
+		MinAmount: 1,
+		MaxAmount: 10,
+		Timeout:   5,
+		Particles: []string{},
+	}
+	if msg.Config == nil || !reflect.DeepEqual(*msg.Config, expect) {
+		t.Errorf("first message has config %v, expected %v", msg.Config, expect)
+	}
+
+	ds.set(dsmock.Stub(dsmock.YAMLData(fmt.Sprintf(data, false))))
+	changes.Update(map[dskey.Key][]byte{dskey.MustKey("meeting/1/applause_enable"): []byte("false")}, nil)
+
+	ctx, cancel := context.WithTimeout(t.Context(), time.Second)
+	defer cancel()
+
+	_, msg, err = app.Receive(ctx, tid, 1)
+	if err != nil {
+		t.Fatalf("Receive after config change: %v", err)
+	}
+
+	expect.Enabled = false
+	if msg.Config == nil || !reflect.DeepEqual(*msg.Config, expect) {
+		t.Errorf("message after config change has config %v, expected %v", msg.Config, expect)
+	}
+
+	if msg.PresentUsers != 1 {
+		t.Errorf("message after config change has %d present users, expected 1", msg.PresentUsers)
+	}
+}
+
+func TestApplauseSendParticle(t *testing.T) {
+	data := `---
+	user/5/meeting_user_ids: [50]
+	meeting_user/50:
+		meeting_id: 1
+		user_id: 5
+		group_ids: [13]
+	group/13/permissions: [meeting.can_see_livestream]
+	meeting/1:
+		admin_group_id: 1
+		applause_enable: true
+		applause_type: %s
+		applause_particle_image_url: /logo.png
+	`
+
+	for _, tt := range []struct {
+		name         string
+		applauseType string
+		particle     string
+		expectErr    error
+	}{
+		{"without particle", "applause-type-bar", "", nil},
+		{"particle in bar meeting", "applause-type-bar", "heart", iccerror.ErrInvalid},
+		{"particle", "applause-type-particles", "heart", nil},
+		{"image particle", "applause-type-particles", "image", nil},
+		{"unknown particle", "applause-type-particles", "unknown", iccerror.ErrInvalid},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := new(backendStub)
+			ds := dsmock.Stub(dsmock.YAMLData(fmt.Sprintf(data, tt.applauseType)))
+			app, _ := applause.New(backend, ds, applause.WithParticles([]string{"heart"}))
+
+			err := app.Send(t.Context(), 1, 5, tt.particle)
+
+			if tt.expectErr == nil && err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+
+			if tt.expectErr != nil && !errors.Is(err, tt.expectErr) {
+				t.Errorf("Send returned `%v`, expected `%v`", err, tt.expectErr)
+			}
+		})
+	}
+}
+
+func TestApplauseSetThresholds(t *testing.T) {
+	data := `---
+	user/5/meeting_user_ids: [50]
+	meeting_user/50:
+		meeting_id: 1
+		user_id: 5
+		group_ids: [13]
+	group/13/permissions: [%s]
+	meeting/1/admin_group_id: 1
+	`
+
+	for _, tt := range []struct {
+		name       string
+		permission string
+		thresholds applause.Thresholds
+		expectErr  error
+	}{
+		{"allowed", "meeting.can_manage_settings", applause.Thresholds{Start: 20, Peak: 60}, nil},
+		{"not allowed", "meeting.can_see_livestream", applause.Thresholds{Start: 20, Peak: 60}, iccerror.ErrNotAllowed},
+		{"invalid", "meeting.can_manage_settings", applause.Thresholds{Start: 70, Peak: 60}, iccerror.ErrInvalid},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := dsmock.Stub(dsmock.YAMLData(fmt.Sprintf(data, tt.permission)))
+			app, _ := applause.New(new(backendStub), ds)
+
+			err := app.SetThresholds(t.Context(), 1, 5, tt.thresholds)
+			if tt.expectErr != nil {
+				if !errors.Is(err, tt.expectErr) {
+					t.Errorf("SetThresholds returned `%v`, expected `%v`", err, tt.expectErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("SetThresholds: %v", err)
+			}
+
+			got, err := app.Thresholds(t.Context(), 1, 5)
+			if err != nil {
+				t.Fatalf("Thresholds: %v", err)
+			}
+
+			if got != tt.thresholds {
+				t.Errorf("got thresholds %v, expected %v", got, tt.thresholds)
+			}
+		})
+	}
 }
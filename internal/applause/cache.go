@@ -0,0 +1,157 @@
+package applause
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/OpenSlides/openslides-go/datastore/dsfetch"
+	"github.com/OpenSlides/openslides-go/datastore/dskey"
+	"github.com/OpenSlides/openslides-go/datastore/flow"
+)
+
+// meetingCache caches the number of present users, the applause config, the
+// current speaker and the thresholds of the meetings.
+//
+// The values are removed, when the datastore changes. So it can only be used,
+// if the service was created with the option WithChanges(). The thresholds are
+// not in the datastore. They are removed, when the backend reports a change.
+type meetingCache struct {
+	mu           sync.Mutex
+	presentUsers map[int]int
+	configs      map[int]Config
+	speakers     map[int]int
+	thresholds   map[int]Thresholds
+
+	// generation is increased on each invalidation. A value, that was fetched
+	// while the generation changed, is not saved, since it could be outdated.
+	generation uint64
+}
+
+func newMeetingCache() *meetingCache {
+	return &meetingCache{
+		presentUsers: make(map[int]int),
+		configs:      make(map[int]Config),
+		speakers:     make(map[int]int),
+		thresholds:   make(map[int]Thresholds),
+	}
+}
+
+// cached returns the value of a meeting from the cache. If the value is not in
+// the cache, it is fetched and saved.
+func cached[T any](c *meetingCache, values map[int]T, meetingID int, fetch func() (T, error)) (T, error) {
+	c.mu.Lock()
+	value, ok := values[meetingID]
+	generation := c.generation
+	c.mu.Unlock()
+
+	if ok {
+		return value, nil
+	}
+
+	value, err := fetch()
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	c.mu.Lock()
+	if c.generation == generation {
+		values[meetingID] = value
+	}
+	c.mu.Unlock()
+
+	return value, nil
+}
+
+// invalidate removes the values of the given meetings. If meetingIDs is nil,
+// all values are removed.
+func (c *meetingCache) invalidate(meetingIDs []int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+
+	if meetingIDs == nil {
+		clear(c.presentUsers)
+		clear(c.configs)
+		clear(c.speakers)
+		return
+	}
+
+	for _, meetingID := range meetingIDs {
+		delete(c.presentUsers, meetingID)
+		delete(c.configs, meetingID)
+		delete(c.speakers, meetingID)
+	}
+}
+
+// invalidateSpeakers removes the current speaker of the given meetings. If
+// meetingIDs is nil, the speakers of all meetings are removed.
+func (c *meetingCache) invalidateSpeakers(meetingIDs []int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+
+	if meetingIDs == nil {
+		clear(c.speakers)
+		return
+	}
+
+	for _, meetingID := range meetingIDs {
+		delete(c.speakers, meetingID)
+	}
+}
+
+// invalidateThresholds removes the thresholds of a meeting.
+func (c *meetingCache) invalidateThresholds(meetingID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	delete(c.thresholds, meetingID)
+}
+
+// isPresentUserKey returns true, if the key contains the present users of a
+// meeting.
+func isPresentUserKey(key dskey.Key) bool {
+	return key.Collection() == "meeting" && key.Field() == "present_user_ids"
+}
+
+// presentUser returns the number of users in this meeting.
+func (a *Applause) presentUser(ctx context.Context, meetingID int) (int, error) {
+	if a.cache == nil {
+		return fetchPresentUser(ctx, a.datastore, meetingID)
+	}
+
+	return cached(a.cache, a.cache.presentUsers, meetingID, func() (int, error) {
+		return fetchPresentUser(ctx, a.datastore, meetingID)
+	})
+}
+
+// config returns the applause configuration of a meeting.
+func (a *Applause) config(ctx context.Context, meetingID int) (Config, error) {
+	if a.cache == nil {
+		return fetchConfig(ctx, a.datastore, meetingID, a.particles)
+	}
+
+	return cached(a.cache, a.cache.configs, meetingID, func() (Config, error) {
+		return fetchConfig(ctx, a.datastore, meetingID, a.particles)
+	})
+}
+
+// fetchPresentUser returns the number of users in a meeting from the
+// datastore.
+func fetchPresentUser(ctx context.Context, getter flow.Getter, meetingID int) (int, error) {
+	fetch := dsfetch.New(getter)
+	ids, err := fetch.Meeting_PresentUserIDs(meetingID).Value(ctx)
+	if err != nil {
+		var errDoesNotExist dsfetch.DoesNotExistError
+		if !errors.As(err, &errDoesNotExist) {
+			return 0, fmt.Errorf("get present users for meeting %d: %w", meetingID, err)
+		}
+	}
+	return len(ids), nil
+}
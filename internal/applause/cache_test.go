@@ -0,0 +1,88 @@
+package applause
+
+import "testing"
+
+func TestMeetingCache(t *testing.T) {
+	c := newMeetingCache()
+
+	var fetched int
+	fetch := func() (int, error) {
+		fetched++
+		return fetched, nil
+	}
+
+	for range 2 {
+		value, err := cached(c, c.presentUsers, 1, fetch)
+		if err != nil {
+			t.Fatalf("cached: %v", err)
+		}
+
+		if value != 1 {
+			t.Errorf("cached returned %d, expected 1", value)
+		}
+	}
+
+	if fetched != 1 {
+		t.Errorf("value was fetched %d times, expected 1", fetched)
+	}
+
+	c.invalidate([]int{2})
+	if value, _ := cached(c, c.presentUsers, 1, fetch); value != 1 {
+		t.Errorf("invalidating another meeting changed the value to %d", value)
+	}
+
+	c.invalidate([]int{1})
+	if value, _ := cached(c, c.presentUsers, 1, fetch); value != 2 {
+		t.Errorf("after invalidation cached returned %d, expected 2", value)
+	}
+
+	c.invalidate(nil)
+	if value, _ := cached(c, c.presentUsers, 1, fetch); value != 3 {
+		t.Errorf("after invalidating all meetings cached returned %d, expected 3", value)
+	}
+}
+
+func TestMeetingCacheInvalidateWhileFetching(t *testing.T) {
+	c := newMeetingCache()
+
+	value, err := cached(c, c.presentUsers, 1, func() (int, error) {
+		c.invalidate([]int{1})
+		return 1, nil
+	})
+	if err != nil {
+		t.Fatalf("cached: %v", err)
+	}
+
+	if value != 1 {
+		t.Errorf("cached returned %d, expected 1", value)
+	}
+
+	if _, ok := c.presentUsers[1]; ok {
+		t.Errorf("value, that was fetched during an invalidation, was saved")
+	}
+}
+
+func TestMeetingCacheThresholds(t *testing.T) {
+	c := newMeetingCache()
+
+	var fetched int
+	fetch := func() (Thresholds, error) {
+		fetched++
+		return Thresholds{Start: fetched, Peak: 100}, nil
+	}
+
+	if _, err := cached(c, c.thresholds, 1, fetch); err != nil {
+		t.Fatalf("cached: %v", err)
+	}
+
+	// The thresholds are not in the datastore, so datastore changes keep them.
+	c.invalidate(nil)
+	if value, _ := cached(c, c.thresholds, 1, fetch); value.Start != 1 {
+		t.Errorf("invalidating the datastore values changed the thresholds to %v", value)
+	}
+
+	c.invalidateThresholds(1)
+	if value, _ := cached(c, c.thresholds, 1, fetch); value.Start != 2 {
+		t.Errorf("after invalidation cached returned %v, expected start 2", value)
+	}
+}
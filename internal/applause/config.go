@@ -0,0 +1,178 @@
+package applause
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/OpenSlides/openslides-go/datastore/dsfetch"
+	"github.com/OpenSlides/openslides-go/datastore/dskey"
+	"github.com/OpenSlides/openslides-go/datastore/flow"
+	"github.com/OpenSlides/openslides-go/oslog"
+)
+
+// particleType is the applause type of meetings, that show particles.
+const particleType = "applause-type-particles"
+
+// imageParticle is the id of the particle, that uses the particle image of the
+// meeting.
+const imageParticle = "image"
+
+// Config is the applause configuration of a meeting.
+//
+// Particles are the ids of the particles, that can be sent in the meeting. It
+// is empty, if the meeting does not use the particle applause type.
+type Config struct {
+	Enabled          bool     `json:"enabled"`
+	Type             string   `json:"type"`
+	ShowLevel        bool     `json:"show_level"`
+	MinAmount        int      `json:"min_amount"`
+	MaxAmount        int      `json:"max_amount"`
+	Timeout          int      `json:"timeout"`
+	ParticleImageURL string   `json:"particle_image_url"`
+	Particles        []string `json:"particles"`
+}
+
+// configFields are the meeting fields, that are used in the Config.
+var configFields = map[string]struct{}{
+	"applause_enable":     {},
+	"applause_type":       {},
+	"applause_show_level": {},
+	"applause_min_amount": {},
+	"applause_max_amount": {},
+	"applause_timeout":    {},
+
+	"applause_particle_image_url": {},
+}
+
+// isConfigKey returns true, if the key is part of the Config of a meeting.
+func isConfigKey(key dskey.Key) bool {
+	if key.Collection() != "meeting" {
+		return false
+	}
+	_, ok := configFields[key.Field()]
+	return ok
+}
+
+// fetchConfig returns the applause configuration of a meeting.
+//
+// The particles are used, if the meeting uses the particle applause type. The
+// image particle is added, if the meeting has a particle image.
+func fetchConfig(ctx context.Context, getter flow.Getter, meetingID int, particles []string) (Config, error) {
+	fetch := dsfetch.New(getter)
+
+	var config Config
+	fetch.Meeting_ApplauseEnable(meetingID).Lazy(&config.Enabled)
+	fetch.Meeting_ApplauseType(meetingID).Lazy(&config.Type)
+	fetch.Meeting_ApplauseShowLevel(meetingID).Lazy(&config.ShowLevel)
+	fetch.Meeting_ApplauseMinAmount(meetingID).Lazy(&config.MinAmount)
+	fetch.Meeting_ApplauseMaxAmount(meetingID).Lazy(&config.MaxAmount)
+	fetch.Meeting_ApplauseTimeout(meetingID).Lazy(&config.Timeout)
+	fetch.Meeting_ApplauseParticleImageUrl(meetingID).Lazy(&config.ParticleImageURL)
+	if err := fetch.Execute(ctx); err != nil {
+		return Config{}, fmt.Errorf("fetching applause config of meeting %d: %w", meetingID, err)
+	}
+
+	config.Particles = []string{}
+	if config.Type == particleType {
+		config.Particles = append(config.Particles, particles...)
+		if config.ParticleImageURL != "" {
+			config.Particles = append(config.Particles, imageParticle)
+		}
+	}
+
+	return config, nil
+}
+
+// watchMeetings removes the meetings from the cache, when their present users,
+// their applause config or their speakers changed after changeID. It sends the
+// ids of the meetings with a changed config to the loop.
+func (a *Applause) watchMeetings(ctx context.Context, changeID uint64) {
+	for {
+		var keys []dskey.Key
+		var err error
+		changeID, keys, err = a.changes.WaitFunc(ctx, changeID, func(key dskey.Key) bool {
+			return isConfigKey(key) || isPresentUserKey(key) || isSpeakerKey(key)
+		})
+		if err != nil {
+			return
+		}
+
+		if keys == nil {
+			// The changes were pruned, so it is unknown what changed.
+			a.cache.invalidate(nil)
+			continue
+		}
+
+		var meetingIDs []int
+		var configIDs []int
+		var speakerKeys []dskey.Key
+		for _, key := range keys {
+			if isSpeakerKey(key) {
+				speakerKeys = append(speakerKeys, key)
+				continue
+			}
+
+			meetingIDs = append(meetingIDs, key.ID())
+			if isConfigKey(key) && !slices.Contains(configIDs, key.ID()) {
+				configIDs = append(configIDs, key.ID())
+			}
+		}
+
+		if len(meetingIDs) > 0 {
+			a.cache.invalidate(meetingIDs)
+		}
+
+		if len(speakerKeys) > 0 {
+			speakerMeetingIDs, err := speakerMeetings(ctx, a.datastore, speakerKeys)
+			if err != nil {
+				// Without the meetings, the speakers of all meetings are removed.
+				oslog.Error("Getting meetings of changed speakers: %v", err)
+				a.cache.invalidateSpeakers(nil)
+			} else if len(speakerMeetingIDs) > 0 {
+				a.cache.invalidateSpeakers(speakerMeetingIDs)
+			}
+		}
+
+		if len(configIDs) == 0 {
+			continue
+		}
+
+		select {
+		case a.configChanged <- configIDs:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// publishConfig publishes the config of the meetings together with their
+// current applause level.
+func (a *Applause) publishConfig(ctx context.Context, meetingIDs []int, last map[int]MSG) error {
+	message := make(map[int]MSG, len(meetingIDs))
+	for _, meetingID := range meetingIDs {
+		config, err := a.config(ctx, meetingID)
+		if err != nil {
+			return fmt.Errorf("fetching config: %w", err)
+		}
+
+		presentUser, err := a.presentUser(ctx, meetingID)
+		if err != nil {
+			return fmt.Errorf("getting present Users: %w", err)
+		}
+
+		msg := last[meetingID]
+		msg.PresentUsers = presentUser
+		msg.Config = &config
+
+		message[meetingID] = msg
+	}
+
+	b, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("encoding message: %w", err)
+	}
+	a.topic.Publish(string(b))
+	return nil
+}
@@ -0,0 +1,281 @@
+package applause
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/OpenSlides/openslides-go/datastore/dsfetch"
+	"github.com/OpenSlides/openslides-go/datastore/dskey"
+	"github.com/OpenSlides/openslides-go/datastore/flow"
+	"github.com/OpenSlides/openslides-go/perm"
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+)
+
+// HistoryEntry is a change of the applause level in a meeting.
+//
+// SpeakerID is the id of the speaker object, that was speaking, when the
+// level changed. It is 0, if nobody was speaking.
+type HistoryEntry struct {
+	Time      int64 `json:"time"`
+	Level     int   `json:"level"`
+	SpeakerID int   `json:"speaker_id"`
+}
+
+// SpeakerTotal is the applause, a speaker received.
+//
+// Applause is the sum of the applause level for each second of the speech.
+// Duration is the number of seconds with applause.
+type SpeakerTotal struct {
+	SpeakerID int   `json:"speaker_id"`
+	MaxLevel  int   `json:"max_level"`
+	Applause  int64 `json:"applause"`
+	Duration  int64 `json:"duration"`
+}
+
+// History is the applause history of a meeting.
+type History struct {
+	Timeline []HistoryEntry `json:"timeline"`
+	Speakers []SpeakerTotal `json:"speakers"`
+}
+
+// History returns the applause history of a meeting.
+//
+// The user needs the permission to manage the meeting settings.
+func (a *Applause) History(ctx context.Context, meetingID, userID int) (History, error) {
+	if userID == 0 {
+		return History{}, iccerror.NewMessageError(iccerror.ErrNotAllowed, "Anonymous is not allowed to see the applause history.")
+	}
+
+	perms, err := perm.New(ctx, dsfetch.New(a.datastore), userID, meetingID)
+	if err != nil {
+		return History{}, fmt.Errorf("getting permissions: %w", err)
+	}
+
+	if !perms.Has(perm.MeetingCanManageSettings) {
+		return History{}, iccerror.NewMessageError(iccerror.ErrNotAllowed, "You need the permission %s in meeting %d.", perm.MeetingCanManageSettings, meetingID)
+	}
+
+	encoded, err := a.backend.ApplauseHistory(meetingID)
+	if err != nil {
+		return History{}, fmt.Errorf("fetching applause history from backend: %w", err)
+	}
+
+	timeline := make([]HistoryEntry, 0, len(encoded))
+	for _, value := range encoded {
+		var entry HistoryEntry
+		if err := json.Unmarshal(value, &entry); err != nil {
+			return History{}, fmt.Errorf("decoding history entry: %w", err)
+		}
+		timeline = append(timeline, entry)
+	}
+
+	slices.SortFunc(timeline, func(a, b HistoryEntry) int {
+		return int(a.Time - b.Time)
+	})
+
+	return History{
+		Timeline: timeline,
+		Speakers: speakerTotals(timeline, time.Now().Unix()),
+	}, nil
+}
+
+// speakerTotals calculates the applause of each speaker from a sorted
+// timeline.
+//
+// Each level is counted until the next entry. The last entry is counted until
+// now, since its applause did not end yet. The speakers are sorted by their
+// applause, the speaker with the most applause first.
+func speakerTotals(timeline []HistoryEntry, now int64) []SpeakerTotal {
+	totals := make(map[int]*SpeakerTotal)
+	var order []int
+	for i, entry := range timeline {
+		if entry.SpeakerID == 0 || entry.Level == 0 {
+			continue
+		}
+
+		total, ok := totals[entry.SpeakerID]
+		if !ok {
+			total = &SpeakerTotal{SpeakerID: entry.SpeakerID}
+			totals[entry.SpeakerID] = total
+			order = append(order, entry.SpeakerID)
+		}
+
+		end := now
+		if i+1 < len(timeline) {
+			end = timeline[i+1].Time
+		}
+
+		duration := max(end-entry.Time, 0)
+		total.MaxLevel = max(total.MaxLevel, entry.Level)
+		total.Applause += int64(entry.Level) * duration
+		total.Duration += duration
+	}
+
+	result := make([]SpeakerTotal, 0, len(order))
+	for _, speakerID := range order {
+		result = append(result, *totals[speakerID])
+	}
+
+	slices.SortStableFunc(result, func(a, b SpeakerTotal) int {
+		return int(b.Applause - a.Applause)
+	})
+	return result
+}
+
+// record saves the applause level of a meeting in the history, if the level
+// or the speaker changed since the last entry. The speaker of a level 0 is
+// not relevant.
+//
+// The backend compares the entry with the last entry, since every instance
+// records the meetings.
+func (a *Applause) record(ctx context.Context, meetingID int, entry HistoryEntry) error {
+	speakerID, err := a.currentSpeaker(ctx, meetingID)
+	if err != nil {
+		return fmt.Errorf("getting current speaker: %w", err)
+	}
+	entry.SpeakerID = speakerID
+
+	state := strconv.Itoa(entry.Level)
+	if entry.Level != 0 {
+		state = fmt.Sprintf("%d:%d", entry.Level, entry.SpeakerID)
+	}
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("encoding history entry: %w", err)
+	}
+
+	if err := a.backend.ApplauseRecord(meetingID, entry.Time, state, b); err != nil {
+		return fmt.Errorf("saving history entry: %w", err)
+	}
+	return nil
+}
+
+// isSpeakerKey returns true, if the key is used to find the current speaker
+// of a meeting.
+func isSpeakerKey(key dskey.Key) bool {
+	switch key.Collection() {
+	case "meeting":
+		return key.Field() == "list_of_speakers_ids"
+	case "list_of_speakers":
+		return key.Field() == "closed" || key.Field() == "speaker_ids"
+	case "speaker":
+		return key.Field() == "begin_time" || key.Field() == "end_time"
+	default:
+		return false
+	}
+}
+
+// speakerMeetings returns the ids of the meetings, that belong to the given
+// speaker keys.
+//
+// Objects, that do not exist anymore, are skipped. Deleting them also changes
+// the list of speakers or the meeting, so their meeting is found anyway.
+func speakerMeetings(ctx context.Context, getter flow.Getter, keys []dskey.Key) ([]int, error) {
+	fetch := dsfetch.New(getter)
+
+	meetingIDs := make([]int, len(keys))
+	for i, key := range keys {
+		switch key.Collection() {
+		case "meeting":
+			meetingIDs[i] = key.ID()
+		case "list_of_speakers":
+			fetch.ListOfSpeakers_MeetingID(key.ID()).Lazy(&meetingIDs[i])
+		case "speaker":
+			fetch.Speaker_MeetingID(key.ID()).Lazy(&meetingIDs[i])
+		}
+	}
+
+	if err := fetch.Execute(ctx); err != nil {
+		var errDoesNotExist dsfetch.DoesNotExistError
+		if !errors.As(err, &errDoesNotExist) {
+			return nil, fmt.Errorf("fetching meetings of speakers: %w", err)
+		}
+	}
+
+	var result []int
+	for _, meetingID := range meetingIDs {
+		if meetingID != 0 && !slices.Contains(result, meetingID) {
+			result = append(result, meetingID)
+		}
+	}
+	return result, nil
+}
+
+// currentSpeaker returns the id of the speaker, that is speaking in a meeting.
+func (a *Applause) currentSpeaker(ctx context.Context, meetingID int) (int, error) {
+	if a.cache == nil {
+		return fetchCurrentSpeaker(ctx, a.datastore, meetingID)
+	}
+
+	return cached(a.cache, a.cache.speakers, meetingID, func() (int, error) {
+		return fetchCurrentSpeaker(ctx, a.datastore, meetingID)
+	})
+}
+
+// fetchCurrentSpeaker returns the id of the speaker in a meeting, that has
+// started but not finished speaking. Only the open lists of speakers are
+// looked at. If there are more than one speaker, the speaker, that started
+// last, is returned. Returns 0, if nobody is speaking.
+func fetchCurrentSpeaker(ctx context.Context, getter flow.Getter, meetingID int) (int, error) {
+	fetch := dsfetch.New(getter)
+
+	listIDs, err := fetch.Meeting_ListOfSpeakersIDs(meetingID).Value(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("fetching lists of speakers of meeting %d: %w", meetingID, err)
+	}
+
+	closed := make([]bool, len(listIDs))
+	for i, listID := range listIDs {
+		fetch.ListOfSpeakers_Closed(listID).Lazy(&closed[i])
+	}
+
+	if err := fetch.Execute(ctx); err != nil {
+		return 0, fmt.Errorf("fetching closed state of lists of speakers: %w", err)
+	}
+
+	speakerIDs := make([][]int, len(listIDs))
+	for i, listID := range listIDs {
+		if !closed[i] {
+			fetch.ListOfSpeakers_SpeakerIDs(listID).Lazy(&speakerIDs[i])
+		}
+	}
+
+	if err := fetch.Execute(ctx); err != nil {
+		return 0, fmt.Errorf("fetching speakers of lists of speakers: %w", err)
+	}
+
+	var openSpeakerIDs []int
+	for _, ids := range speakerIDs {
+		openSpeakerIDs = append(openSpeakerIDs, ids...)
+	}
+
+	beginTimes := make([]int, len(openSpeakerIDs))
+	endTimes := make([]int, len(openSpeakerIDs))
+	for i, speakerID := range openSpeakerIDs {
+		fetch.Speaker_BeginTime(speakerID).Lazy(&beginTimes[i])
+		fetch.Speaker_EndTime(speakerID).Lazy(&endTimes[i])
+	}
+
+	if err := fetch.Execute(ctx); err != nil {
+		return 0, fmt.Errorf("fetching speaker times: %w", err)
+	}
+
+	var current, currentBegin int
+	for i, speakerID := range openSpeakerIDs {
+		if beginTimes[i] == 0 || endTimes[i] != 0 {
+			continue
+		}
+
+		if beginTimes[i] > currentBegin {
+			current = speakerID
+			currentBegin = beginTimes[i]
+		}
+	}
+	return current, nil
+}
@@ -0,0 +1,27 @@
+package applause
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSpeakerTotals(t *testing.T) {
+	timeline := []HistoryEntry{
+		{Time: 100, Level: 2, SpeakerID: 1},
+		{Time: 102, Level: 4, SpeakerID: 1},
+		{Time: 103, Level: 4, SpeakerID: 2},
+		{Time: 108, Level: 0, SpeakerID: 2},
+		{Time: 110, Level: 3, SpeakerID: 0},
+		{Time: 111, Level: 5, SpeakerID: 1},
+	}
+
+	got := speakerTotals(timeline, 113)
+
+	expect := []SpeakerTotal{
+		{SpeakerID: 2, MaxLevel: 4, Applause: 20, Duration: 5},
+		{SpeakerID: 1, MaxLevel: 5, Applause: 18, Duration: 5},
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("speakerTotals returned %v, expected %v", got, expect)
+	}
+}
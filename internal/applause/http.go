@@ -2,21 +2,27 @@ package applause
 
 import (
 	"context"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"time"
 
+	"github.com/OpenSlides/openslides-go/oslog"
 	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
 	"github.com/OpenSlides/openslides-icc-service/internal/icchttp"
 )
 
 // Sender saves the applause.
 type Sender interface {
-	Send(ctx context.Context, meetingID, uid int) error
+	Send(ctx context.Context, meetingID, uid int, particle string) error
 }
 
-// HandleSend registers the icc/applause route.
+// HandleSend registers the icc/applause/send route.
+//
+// The optional query `particle` sends a particle with the applause.
 func HandleSend(mux *http.ServeMux, applause Sender, auth icchttp.Authenticater) {
 	url := icchttp.Path + "/applause/send"
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -36,7 +42,7 @@ func HandleSend(mux *http.ServeMux, applause Sender, auth icchttp.Authenticater)
 			return
 		}
 
-		if err := applause.Send(r.Context(), meetingID, uid); err != nil {
+		if err := applause.Send(r.Context(), meetingID, uid, r.URL.Query().Get("particle")); err != nil {
 			icchttp.Error(w, fmt.Errorf("saving applause: %w", err))
 			return
 		}
@@ -48,14 +54,63 @@ func HandleSend(mux *http.ServeMux, applause Sender, auth icchttp.Authenticater)
 	)
 }
 
+// Holder saves held applause.
+type Holder interface {
+	Start(ctx context.Context, meetingID, uid int) error
+	Stop(ctx context.Context, meetingID, uid int) error
+}
+
+// HandleHold registers the icc/applause/start and icc/applause/stop routes.
+func HandleHold(mux *http.ServeMux, applause Holder, auth icchttp.Authenticater) {
+	for action, call := range map[string]func(context.Context, int, int) error{
+		"start": applause.Start,
+		"stop":  applause.Stop,
+	} {
+		url := icchttp.Path + "/applause/" + action
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+
+			uid := auth.FromContext(r.Context())
+			if uid == 0 {
+				w.WriteHeader(401)
+				icchttp.ErrorNoStatus(w, iccerror.NewMessageError(iccerror.ErrNotAllowed, "Anonymous user can not send applause."))
+				return
+			}
+
+			meetingID, err := strconv.Atoi(r.URL.Query().Get("meeting_id"))
+			if err != nil {
+				icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrInvalid, "Query meeting_id has to be an int."))
+				return
+			}
+
+			if err := call(r.Context(), meetingID, uid); err != nil {
+				icchttp.Error(w, fmt.Errorf("%s applause: %w", action, err))
+				return
+			}
+		})
+
+		mux.Handle(
+			url,
+			icchttp.AuthMiddleware(handler, auth),
+		)
+	}
+}
+
 // Receive gets applause messages.
 type Receive interface {
 	Receive(ctx context.Context, tid uint64, meetingID int) (newTID uint64, msg MSG, err error)
-	CanReceive(ctx context.Context, meetingID, userID int) error
+	CanReceive(ctx context.Context, meetingID, userID, projectorID int) (context.Context, error)
 }
 
 // HandleReceive registers the icc/applause route.
-func HandleReceive(mux *http.ServeMux, applause Receive, auth icchttp.Authenticater) {
+//
+// Anonymous users can receive applause, if the meeting allows it. Projectors
+// can use the query `projector_id` to receive applause with the permission
+// projector.can_see.
+//
+// The first message contains the heartbeat interval. On an idle stream, the
+// last message is repeated with the field `heartbeat` after the interval.
+func HandleReceive(mux *http.ServeMux, applause Receive, auth icchttp.Authenticater, heartbeat time.Duration) {
 	url := icchttp.Path + "/applause"
 	handler := http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
@@ -69,27 +124,41 @@ func HandleReceive(mux *http.ServeMux, applause Receive, auth icchttp.Authentica
 				return
 			}
 
-			if err := applause.CanReceive(r.Context(), meetingID, auth.FromContext(r.Context())); err != nil {
+			heartbeat, err := icchttp.HeartbeatInterval(r, heartbeat)
+			if err != nil {
 				icchttp.Error(w, err)
 				return
 			}
 
-			encoder := json.NewEncoder(w)
-			var tid uint64
-			for {
-				var message MSG
-				tid, message, err = applause.Receive(r.Context(), tid, meetingID)
+			var projectorID int
+			if projectorStr := r.URL.Query().Get("projector_id"); projectorStr != "" {
+				projectorID, err = strconv.Atoi(projectorStr)
 				if err != nil {
-					icchttp.ErrorNoStatus(w, fmt.Errorf("receive applause data: %w", err))
+					icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrInvalid, "Query projector_id has to be an int."))
 					return
 				}
+			}
 
-				if err := encoder.Encode(message); err != nil {
-					icchttp.ErrorNoStatus(w, fmt.Errorf("writing message: %w", err))
-					return
-				}
-				w.(http.Flusher).Flush()
+			// The context is canceled, when the user looses the permission.
+			ctx, err := applause.CanReceive(r.Context(), meetingID, auth.FromContext(r.Context()), projectorID)
+			if err != nil {
+				icchttp.Error(w, err)
+				return
 			}
+
+			receive := func(ctx context.Context, tid uint64, last MSG) (uint64, MSG, error) {
+				return applause.Receive(ctx, tid, meetingID)
+			}
+
+			// The heartbeat only repeats the level.
+			idle := func(last MSG) MSG {
+				last.Config = nil
+				last.Particles = nil
+				last.Event = ""
+				return last
+			}
+
+			icchttp.Stream(w, r.WithContext(ctx), heartbeat, receive, idle)
 		})
 
 	mux.Handle(
@@ -97,3 +166,143 @@ func HandleReceive(mux *http.ServeMux, applause Receive, auth icchttp.Authentica
 		icchttp.AuthMiddleware(handler, auth),
 	)
 }
+
+// Historian returns the applause history of a meeting.
+type Historian interface {
+	History(ctx context.Context, meetingID, userID int) (History, error)
+}
+
+// HandleHistory registers the icc/applause/history route.
+//
+// The history is returned as json. With the query `format=csv`, it is returned
+// as csv. The query `table` selects the `timeline` (default) or the `speakers`
+// for the csv.
+func HandleHistory(mux *http.ServeMux, applause Historian, auth icchttp.Authenticater) {
+	url := icchttp.Path + "/applause/history"
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		meetingID, err := strconv.Atoi(r.URL.Query().Get("meeting_id"))
+		if err != nil {
+			icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrInvalid, "Query meeting_id has to be an int."))
+			return
+		}
+
+		format := r.URL.Query().Get("format")
+		if format != "" && format != "json" && format != "csv" {
+			icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrInvalid, "Query format has to be json or csv."))
+			return
+		}
+
+		table := r.URL.Query().Get("table")
+		if table != "" && table != "timeline" && table != "speakers" {
+			icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrInvalid, "Query table has to be timeline or speakers."))
+			return
+		}
+
+		history, err := applause.History(r.Context(), meetingID, auth.FromContext(r.Context()))
+		if err != nil {
+			icchttp.Error(w, fmt.Errorf("getting applause history: %w", err))
+			return
+		}
+
+		if format != "csv" {
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(history); err != nil {
+				icchttp.ErrorNoStatus(w, fmt.Errorf("writing history: %w", err))
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/csv")
+		if err := writeHistoryCSV(w, history, table); err != nil {
+			oslog.Error("Writing applause history: %v", err)
+		}
+	})
+
+	mux.Handle(
+		url,
+		icchttp.AuthMiddleware(handler, auth),
+	)
+}
+
+// ThresholdManager reads and sets the applause thresholds of a meeting.
+type ThresholdManager interface {
+	Thresholds(ctx context.Context, meetingID, userID int) (Thresholds, error)
+	SetThresholds(ctx context.Context, meetingID, userID int, thresholds Thresholds) error
+}
+
+// HandleThresholds registers the icc/applause/thresholds route.
+//
+// A GET request returns the thresholds of the meeting. A POST request sets
+// them from the body.
+func HandleThresholds(mux *http.ServeMux, applause ThresholdManager, auth icchttp.Authenticater) {
+	url := icchttp.Path + "/applause/thresholds"
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		meetingID, err := strconv.Atoi(r.URL.Query().Get("meeting_id"))
+		if err != nil {
+			icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrInvalid, "Query meeting_id has to be an int."))
+			return
+		}
+
+		uid := auth.FromContext(r.Context())
+
+		if r.Method != http.MethodPost {
+			thresholds, err := applause.Thresholds(r.Context(), meetingID, uid)
+			if err != nil {
+				icchttp.Error(w, fmt.Errorf("getting applause thresholds: %w", err))
+				return
+			}
+
+			if err := json.NewEncoder(w).Encode(thresholds); err != nil {
+				icchttp.ErrorNoStatus(w, fmt.Errorf("writing thresholds: %w", err))
+			}
+			return
+		}
+
+		var thresholds Thresholds
+		if err := json.NewDecoder(r.Body).Decode(&thresholds); err != nil {
+			icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrInvalid, "Body has to be a json object with the fields start and peak."))
+			return
+		}
+
+		if err := applause.SetThresholds(r.Context(), meetingID, uid, thresholds); err != nil {
+			icchttp.Error(w, fmt.Errorf("setting applause thresholds: %w", err))
+			return
+		}
+	})
+
+	mux.Handle(
+		url,
+		icchttp.AuthMiddleware(handler, auth),
+	)
+}
+
+// writeHistoryCSV writes the timeline or the speaker totals as csv.
+func writeHistoryCSV(w io.Writer, history History, table string) error {
+	writer := csv.NewWriter(w)
+
+	if table == "speakers" {
+		writer.Write([]string{"speaker_id", "max_level", "applause", "duration"})
+		for _, total := range history.Speakers {
+			writer.Write([]string{
+				strconv.Itoa(total.SpeakerID),
+				strconv.Itoa(total.MaxLevel),
+				strconv.FormatInt(total.Applause, 10),
+				strconv.FormatInt(total.Duration, 10),
+			})
+		}
+	} else {
+		writer.Write([]string{"time", "level", "speaker_id"})
+		for _, entry := range history.Timeline {
+			writer.Write([]string{
+				strconv.FormatInt(entry.Time, 10),
+				strconv.Itoa(entry.Level),
+				strconv.Itoa(entry.SpeakerID),
+			})
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
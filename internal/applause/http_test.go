@@ -83,4 +83,150 @@ func TestHandleSend(t *testing.T) {
 			t.Errorf("handler returned the error message: %s", resp.Body.String())
 		}
 	})
+
+	t.Run("Particle", func(t *testing.T) {
+		applauser := applauserStub{}
+		mux := http.NewServeMux()
+		applause.HandleSend(mux, &applauser, &icctest.AutherStub{UserID: 1})
+		resp := httptest.NewRecorder()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("GET", url+"&particle=heart", nil))
+
+		if resp.Result().StatusCode != 200 {
+			t.Fatalf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+		}
+
+		if applauser.calledParticle != "heart" {
+			t.Errorf("applauser was called with particle `%s`, expected `heart`", applauser.calledParticle)
+		}
+	})
+}
+
+func TestHandleHistory(t *testing.T) {
+	historian := historianStub{
+		history: applause.History{
+			Timeline: []applause.HistoryEntry{{Time: 100, Level: 3, SpeakerID: 7}, {Time: 104, Level: 0, SpeakerID: 7}},
+			Speakers: []applause.SpeakerTotal{{SpeakerID: 7, MaxLevel: 3, Applause: 12, Duration: 4}},
+		},
+	}
+	mux := http.NewServeMux()
+	applause.HandleHistory(mux, &historian, &icctest.AutherStub{UserID: 1})
+
+	for _, tt := range []struct {
+		name   string
+		query  string
+		expect string
+	}{
+		{
+			"json",
+			"",
+			`{"timeline":[{"time":100,"level":3,"speaker_id":7},{"time":104,"level":0,"speaker_id":7}],"speakers":[{"speaker_id":7,"max_level":3,"applause":12,"duration":4}]}` + "\n",
+		},
+		{
+			"csv timeline",
+			"&format=csv",
+			"time,level,speaker_id\n100,3,7\n104,0,7\n",
+		},
+		{
+			"csv speakers",
+			"&format=csv&table=speakers",
+			"speaker_id,max_level,applause,duration\n7,3,12,4\n",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			mux.ServeHTTP(resp, httptest.NewRequest("GET", "/system/icc/applause/history?meeting_id=1"+tt.query, nil))
+
+			if resp.Result().StatusCode != 200 {
+				t.Fatalf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+			}
+
+			if got := resp.Body.String(); got != tt.expect {
+				t.Errorf("got body\n%s\nexpected\n%s", got, tt.expect)
+			}
+		})
+	}
+
+	t.Run("not allowed", func(t *testing.T) {
+		historian := historianStub{err: iccerror.NewMessageError(iccerror.ErrNotAllowed, "not allowed")}
+		mux := http.NewServeMux()
+		applause.HandleHistory(mux, &historian, &icctest.AutherStub{UserID: 1})
+
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, httptest.NewRequest("GET", "/system/icc/applause/history?meeting_id=1", nil))
+
+		if resp.Result().StatusCode != 400 {
+			t.Errorf("handler returned status %s, expected 400", resp.Result().Status)
+		}
+
+		if !strings.Contains(resp.Body.String(), iccerror.ErrNotAllowed.Type()) {
+			t.Errorf("handler returned message `%s`, expected to contain `%s`", resp.Body.String(), iccerror.ErrNotAllowed.Type())
+		}
+	})
+}
+
+func TestHandleHold(t *testing.T) {
+	holder := holderStub{}
+	mux := http.NewServeMux()
+	applause.HandleHold(mux, &holder, &icctest.AutherStub{UserID: 1})
+
+	for _, action := range []string{"start", "start", "stop"} {
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, httptest.NewRequest("GET", "/system/icc/applause/"+action+"?meeting_id=1", nil))
+
+		if resp.Result().StatusCode != 200 {
+			t.Fatalf("%s returned status %s: %s", action, resp.Result().Status, resp.Body.String())
+		}
+	}
+
+	if holder.started != 2 || holder.stopped != 1 {
+		t.Errorf("applause was started %d times and stopped %d times, expected 2 and 1", holder.started, holder.stopped)
+	}
+
+	t.Run("Anonymous", func(t *testing.T) {
+		mux := http.NewServeMux()
+		applause.HandleHold(mux, &holderStub{}, &icctest.AutherStub{})
+
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, httptest.NewRequest("GET", "/system/icc/applause/start?meeting_id=1", nil))
+
+		if resp.Result().StatusCode != 401 {
+			t.Errorf("handler returned status %s, expected 401", resp.Result().Status)
+		}
+	})
+}
+
+func TestHandleThresholds(t *testing.T) {
+	manager := thresholdManagerStub{thresholds: applause.DefaultThresholds}
+	mux := http.NewServeMux()
+	applause.HandleThresholds(mux, &manager, &icctest.AutherStub{UserID: 1})
+
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, httptest.NewRequest("POST", "/system/icc/applause/thresholds?meeting_id=1", strings.NewReader(`{"start":20,"peak":60}`)))
+
+	if resp.Result().StatusCode != 200 {
+		t.Fatalf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+	}
+
+	if expect := (applause.Thresholds{Start: 20, Peak: 60}); manager.thresholds != expect {
+		t.Errorf("thresholds were set to %v, expected %v", manager.thresholds, expect)
+	}
+
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, httptest.NewRequest("GET", "/system/icc/applause/thresholds?meeting_id=1", nil))
+
+	if resp.Result().StatusCode != 200 {
+		t.Fatalf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+	}
+
+	if got, expect := resp.Body.String(), `{"start":20,"peak":60}`+"\n"; got != expect {
+		t.Errorf("got body %s, expected %s", got, expect)
+	}
+
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, httptest.NewRequest("POST", "/system/icc/applause/thresholds?meeting_id=1", strings.NewReader(`not json`)))
+
+	if resp.Result().StatusCode != 400 {
+		t.Errorf("handler returned status %s for invalid body, expected 400", resp.Result().Status)
+	}
 }
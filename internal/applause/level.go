@@ -0,0 +1,61 @@
+package applause
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// holdRamp is the time until a held applause counts maxHoldWeight.
+	holdRamp = 5 * time.Second
+
+	// maxHoldWeight is the maximum weight of a held applause. A single
+	// applause has the weight 1.
+	maxHoldWeight = 2.0
+)
+
+// meetingWindow returns the time, an applause is counted in a meeting.
+func (a *Applause) meetingWindow(config Config) time.Duration {
+	if config.Timeout <= 0 {
+		return a.window
+	}
+	return min(time.Duration(config.Timeout)*time.Second, a.maxWindow)
+}
+
+// weightedCount returns the applause count, where each held applause is
+// weighted by the time it is held.
+//
+// A held applause starts with the weight 1, like a single applause, and grows
+// to maxHoldWeight in holdRamp.
+func weightedCount(claps int, holdStarts []int64, now time.Time) int {
+	weighted := float64(claps)
+	for _, start := range holdStarts {
+		held := now.Sub(time.Unix(start, 0))
+		ramp := min(max(held.Seconds()/holdRamp.Seconds(), 0), 1)
+		weighted += 1 + ramp*(maxHoldWeight-1)
+	}
+	return int(math.Round(weighted))
+}
+
+// scaleLevel returns the applause level and the level in percent.
+//
+// The level is 0, if less users than the min amount of the meeting applaused.
+// The percent is the level between the min amount and the max amount. If the
+// meeting has no max amount, the number of present users is used.
+func scaleLevel(count, presentUsers int, config Config) (level, percent int) {
+	if count == 0 || count < config.MinAmount {
+		return 0, 0
+	}
+
+	maxAmount := config.MaxAmount
+	if maxAmount <= 0 {
+		maxAmount = presentUsers
+	}
+
+	if maxAmount <= config.MinAmount {
+		return count, 100
+	}
+
+	percent = (count - config.MinAmount) * 100 / (maxAmount - config.MinAmount)
+	return count, min(max(percent, 0), 100)
+}
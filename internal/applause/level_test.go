@@ -0,0 +1,73 @@
+package applause
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMeetingWindow(t *testing.T) {
+	a := Applause{window: 5 * time.Second, maxWindow: time.Minute}
+
+	for _, tt := range []struct {
+		timeout int
+		expect  time.Duration
+	}{
+		{0, 5 * time.Second},
+		{10, 10 * time.Second},
+		{120, time.Minute},
+	} {
+		if got := a.meetingWindow(Config{Timeout: tt.timeout}); got != tt.expect {
+			t.Errorf("meetingWindow with timeout %d returned %s, expected %s", tt.timeout, got, tt.expect)
+		}
+	}
+}
+
+func TestWeightedCount(t *testing.T) {
+	now := time.Unix(100, 0)
+
+	for _, tt := range []struct {
+		name       string
+		claps      int
+		holdStarts []int64
+		expect     int
+	}{
+		{"only claps", 3, nil, 3},
+		{"new hold counts like a clap", 0, []int64{100}, 1},
+		{"half ramp", 0, []int64{98, 98}, 3},
+		{"full ramp", 0, []int64{95}, 2},
+		{"longer than ramp", 0, []int64{10}, 2},
+		{"claps and holds", 2, []int64{100, 90}, 5},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := weightedCount(tt.claps, tt.holdStarts, now); got != tt.expect {
+				t.Errorf("weightedCount returned %d, expected %d", got, tt.expect)
+			}
+		})
+	}
+}
+
+func TestScaleLevel(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		count         int
+		presentUsers  int
+		config        Config
+		expectLevel   int
+		expectPercent int
+	}{
+		{"no applause", 0, 10, Config{}, 0, 0},
+		{"present users as max", 5, 10, Config{}, 5, 50},
+		{"below min amount", 2, 10, Config{MinAmount: 3}, 0, 0},
+		{"between min and max", 6, 100, Config{MinAmount: 2, MaxAmount: 10}, 6, 50},
+		{"above max amount", 20, 100, Config{MinAmount: 2, MaxAmount: 10}, 20, 100},
+		{"max not above min", 5, 0, Config{MinAmount: 2}, 5, 100},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			level, percent := scaleLevel(tt.count, tt.presentUsers, tt.config)
+
+			if level != tt.expectLevel || percent != tt.expectPercent {
+				t.Errorf("scaleLevel returned (%d, %d), expected (%d, %d)", level, percent, tt.expectLevel, tt.expectPercent)
+			}
+		})
+	}
+}
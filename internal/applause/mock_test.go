@@ -1,31 +1,148 @@
 package applause_test
 
-import "context"
+import (
+	"context"
+	"fmt"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/applause"
+)
 
 type applauserStub struct {
 	expectedErr     error
 	called          bool
 	calledUserID    int
 	calledMeetingID int
+	calledParticle  string
 }
 
-func (s *applauserStub) Send(ctx context.Context, meetingID, uid int) error {
+func (s *applauserStub) Send(ctx context.Context, meetingID, uid int, particle string) error {
 	s.called = true
 	s.calledUserID = uid
 	s.calledMeetingID = meetingID
+	s.calledParticle = particle
 	return s.expectedErr
 }
 
+type holderStub struct {
+	started int
+	stopped int
+}
+
+func (h *holderStub) Start(ctx context.Context, meetingID, uid int) error {
+	h.started++
+	return nil
+}
+
+func (h *holderStub) Stop(ctx context.Context, meetingID, uid int) error {
+	h.stopped++
+	return nil
+}
+
 type backendStub struct {
 	PublishCalled int
-	ExpectSince   map[int]int
+	ExpectCount   map[int]int
+	History       map[int64][]byte
+	Holders       map[int][]int64
+	Thresholds    map[int][]byte
+	Events        map[string]bool
+
+	historyState string
 }
 
-func (b *backendStub) ApplausePublish(meetingID, userID int, time int64) error {
+func (b *backendStub) ApplausePublish(meetingID, userID int, time int64, particle string) error {
 	b.PublishCalled++
 	return nil
 }
 
-func (b backendStub) ApplauseSince(time int64) (map[int]int, error) {
-	return b.ExpectSince, nil
+func (b *backendStub) ApplauseParticles(meetingID int, from, to int64) (map[string]int, error) {
+	return nil, nil
+}
+
+func (b *backendStub) ApplauseChanged(ctx context.Context) (int, error) {
+	<-ctx.Done()
+	return 0, ctx.Err()
+}
+
+func (b *backendStub) ApplauseCount(meetingID int, time int64) (int, error) {
+	return b.ExpectCount[meetingID], nil
+}
+
+func (b *backendStub) ApplauseStart(meetingID, userID int, time, expire int64) error {
+	return nil
+}
+
+func (b *backendStub) ApplauseStop(meetingID, userID int) error {
+	return nil
+}
+
+func (b *backendStub) ApplauseHolders(meetingID int, time int64) ([]int64, error) {
+	return b.Holders[meetingID], nil
+}
+
+func (b *backendStub) ApplauseRecord(meetingID int, time int64, state string, entry []byte) error {
+	if state == b.historyState {
+		return nil
+	}
+
+	if b.History == nil {
+		b.History = make(map[int64][]byte)
+	}
+	b.History[time] = entry
+	b.historyState = state
+	return nil
+}
+
+func (b *backendStub) ApplauseHistory(meetingID int) (map[int64][]byte, error) {
+	return b.History, nil
+}
+
+func (b *backendStub) ApplauseThresholds(meetingID int) ([]byte, error) {
+	return b.Thresholds[meetingID], nil
+}
+
+func (b *backendStub) ApplauseSetThresholds(meetingID int, thresholds []byte) error {
+	if b.Thresholds == nil {
+		b.Thresholds = make(map[int][]byte)
+	}
+	b.Thresholds[meetingID] = thresholds
+	return nil
+}
+
+func (b *backendStub) ApplauseThresholdsChanged(ctx context.Context) (int, error) {
+	<-ctx.Done()
+	return 0, ctx.Err()
+}
+
+func (b *backendStub) ApplauseEventFirst(meetingID int, event string, start int64) (int64, bool, error) {
+	if b.Events == nil {
+		b.Events = make(map[string]bool)
+	}
+	key := fmt.Sprintf("%d/%d/%s", meetingID, start, event)
+	if b.Events[key] {
+		return start, false, nil
+	}
+	b.Events[key] = true
+	return start, true, nil
+}
+
+type historianStub struct {
+	history applause.History
+	err     error
+}
+
+func (h *historianStub) History(ctx context.Context, meetingID, userID int) (applause.History, error) {
+	return h.history, h.err
+}
+
+type thresholdManagerStub struct {
+	thresholds applause.Thresholds
+}
+
+func (s *thresholdManagerStub) Thresholds(ctx context.Context, meetingID, userID int) (applause.Thresholds, error) {
+	return s.thresholds, nil
+}
+
+func (s *thresholdManagerStub) SetThresholds(ctx context.Context, meetingID, userID int, thresholds applause.Thresholds) error {
+	s.thresholds = thresholds
+	return nil
 }
@@ -0,0 +1,244 @@
+package applause
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/OpenSlides/openslides-go/datastore/dsfetch"
+	"github.com/OpenSlides/openslides-go/perm"
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+)
+
+// Names of the applause events.
+const (
+	EventStarted = "applause-started"
+	EventPeak    = "applause-peak"
+	EventEnded   = "applause-ended"
+)
+
+// Thresholds are the applause levels in percent, that create events.
+//
+// The applause starts, when the level reaches Start and ends, when it falls
+// below Start. The applause has a peak, when the level reaches Peak.
+type Thresholds struct {
+	Start int `json:"start"`
+	Peak  int `json:"peak"`
+}
+
+// DefaultThresholds are the thresholds of meetings without own thresholds.
+var DefaultThresholds = Thresholds{Start: 10, Peak: 80}
+
+// validate returns an error, if the thresholds are invalid.
+func (t Thresholds) validate() error {
+	if t.Start < 1 || t.Start > t.Peak || t.Peak > 100 {
+		return iccerror.NewMessageError(iccerror.ErrInvalid, "Thresholds have to be 1 <= start <= peak <= 100.")
+	}
+	return nil
+}
+
+// EventPublisher sends applause events to other services.
+type EventPublisher interface {
+	PublishSystem(meetingID int, name string, message any) error
+}
+
+// WithEventPublisher sets a publisher, that gets the applause events of all
+// meetings.
+func WithEventPublisher(p EventPublisher) Option {
+	return func(a *Applause) {
+		a.events = p
+	}
+}
+
+// Thresholds returns the thresholds of a meeting.
+func (a *Applause) Thresholds(ctx context.Context, meetingID, userID int) (Thresholds, error) {
+	if err := a.canManage(ctx, meetingID, userID); err != nil {
+		return Thresholds{}, err
+	}
+
+	return a.thresholds(meetingID)
+}
+
+// SetThresholds sets the thresholds of a meeting.
+//
+// The user needs the permission to manage the meeting settings.
+func (a *Applause) SetThresholds(ctx context.Context, meetingID, userID int, thresholds Thresholds) error {
+	if err := thresholds.validate(); err != nil {
+		return err
+	}
+
+	if err := a.canManage(ctx, meetingID, userID); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(thresholds)
+	if err != nil {
+		return fmt.Errorf("encoding thresholds: %w", err)
+	}
+
+	if err := a.backend.ApplauseSetThresholds(meetingID, b); err != nil {
+		return fmt.Errorf("saving thresholds: %w", err)
+	}
+
+	// The other instances remove the thresholds in watchThresholds.
+	if a.cache != nil {
+		a.cache.invalidateThresholds(meetingID)
+	}
+	return nil
+}
+
+func (a *Applause) canManage(ctx context.Context, meetingID, userID int) error {
+	if userID == 0 {
+		return iccerror.NewMessageError(iccerror.ErrNotAllowed, "Anonymous is not allowed to manage applause.")
+	}
+
+	perms, err := perm.New(ctx, dsfetch.New(a.datastore), userID, meetingID)
+	if err != nil {
+		return fmt.Errorf("getting permissions: %w", err)
+	}
+
+	if !perms.Has(perm.MeetingCanManageSettings) {
+		return iccerror.NewMessageError(iccerror.ErrNotAllowed, "You need the permission %s in meeting %d.", perm.MeetingCanManageSettings, meetingID)
+	}
+	return nil
+}
+
+// thresholds returns the thresholds of a meeting.
+func (a *Applause) thresholds(meetingID int) (Thresholds, error) {
+	if a.cache == nil {
+		return a.fetchThresholds(meetingID)
+	}
+
+	return cached(a.cache, a.cache.thresholds, meetingID, func() (Thresholds, error) {
+		return a.fetchThresholds(meetingID)
+	})
+}
+
+// fetchThresholds returns the thresholds of a meeting from the backend.
+func (a *Applause) fetchThresholds(meetingID int) (Thresholds, error) {
+	b, err := a.backend.ApplauseThresholds(meetingID)
+	if err != nil {
+		return Thresholds{}, fmt.Errorf("fetching thresholds from backend: %w", err)
+	}
+
+	if b == nil {
+		return DefaultThresholds, nil
+	}
+
+	var thresholds Thresholds
+	if err := json.Unmarshal(b, &thresholds); err != nil {
+		return Thresholds{}, fmt.Errorf("decoding thresholds: %w", err)
+	}
+	return thresholds, nil
+}
+
+// watchThresholds removes the thresholds of a meeting from the cache, when
+// they changed on any instance.
+func (a *Applause) watchThresholds(ctx context.Context, errHandler func(error)) {
+	if errHandler == nil {
+		errHandler = func(error) {}
+	}
+
+	for {
+		meetingID, err := a.backend.ApplauseThresholdsChanged(ctx)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+
+			errHandler(fmt.Errorf("receiving threshold changes from backend: %w", err))
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		a.cache.invalidateThresholds(meetingID)
+	}
+}
+
+// episode is the state of the applause in a meeting between the start and the
+// end event.
+//
+// start is the time of the episode in the backend. It is only set, if the
+// events are published.
+type episode struct {
+	started bool
+	peaked  bool
+	start   int64
+}
+
+// next returns the event, that is created by the level percent, and the new
+// state. The event is empty, if no threshold was crossed.
+//
+// Only one event is returned for each call. If the level reaches start and
+// peak at the same time, the peak event is returned on the next call.
+func (e episode) next(thresholds Thresholds, percent int) (string, episode) {
+	switch {
+	case !e.started && percent >= thresholds.Start:
+		return EventStarted, episode{started: true}
+
+	case e.started && percent < thresholds.Start:
+		return EventEnded, episode{}
+
+	case e.started && !e.peaked && percent >= thresholds.Peak:
+		return EventPeak, episode{started: true, peaked: true, start: e.start}
+
+	default:
+		return "", e
+	}
+}
+
+// evaluateEvent returns the event of a meeting for the new message and
+// updates the episodes.
+func (a *Applause) evaluateEvent(meetingID int, msg MSG, episodes map[int]episode) (string, error) {
+	thresholds, err := a.thresholds(meetingID)
+	if err != nil {
+		return "", fmt.Errorf("getting thresholds: %w", err)
+	}
+
+	event, state := episodes[meetingID].next(thresholds, msg.LevelPercent)
+	episodes[meetingID] = state
+	return event, nil
+}
+
+// publishEvent sends an event to the event publisher.
+//
+// Each instance detects the events. So the backend saves the episodes of the
+// meetings and each event of an episode is only sent once. The start of the
+// episode is saved in the episodes.
+func (a *Applause) publishEvent(meetingID int, event string, msg MSG, now int64, episodes map[int]episode) error {
+	if a.events == nil {
+		return nil
+	}
+
+	state := episodes[meetingID]
+	start := state.start
+	if event == EventStarted {
+		start = now
+	}
+
+	start, first, err := a.backend.ApplauseEventFirst(meetingID, event, start)
+	if err != nil {
+		return fmt.Errorf("checking event in backend: %w", err)
+	}
+
+	if event != EventEnded {
+		state.start = start
+		episodes[meetingID] = state
+	}
+
+	if !first {
+		return nil
+	}
+
+	message := struct {
+		Level        int `json:"level"`
+		LevelPercent int `json:"level_percent"`
+	}{msg.Level, msg.LevelPercent}
+
+	if err := a.events.PublishSystem(meetingID, event, message); err != nil {
+		return fmt.Errorf("publishing event: %w", err)
+	}
+	return nil
+}
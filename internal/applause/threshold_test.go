@@ -0,0 +1,74 @@
+package applause
+
+import "testing"
+
+func TestEpisodeNext(t *testing.T) {
+	thresholds := Thresholds{Start: 10, Peak: 80}
+
+	var e episode
+	var events []string
+	for _, percent := range []int{0, 5, 20, 90, 100, 50, 85, 5, 0, 95, 95, 0} {
+		var event string
+		event, e = e.next(thresholds, percent)
+		if event != "" {
+			events = append(events, event)
+		}
+	}
+
+	expect := []string{
+		EventStarted, EventPeak, EventEnded,
+		EventStarted, EventPeak, EventEnded,
+	}
+	if len(events) != len(expect) {
+		t.Fatalf("got events %v, expected %v", events, expect)
+	}
+
+	for i := range expect {
+		if events[i] != expect[i] {
+			t.Errorf("got events %v, expected %v", events, expect)
+			break
+		}
+	}
+}
+
+func TestThresholdsValidate(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		thresholds Thresholds
+		valid      bool
+	}{
+		{"default", DefaultThresholds, true},
+		{"same values", Thresholds{Start: 50, Peak: 50}, true},
+		{"start zero", Thresholds{Start: 0, Peak: 50}, false},
+		{"start above peak", Thresholds{Start: 60, Peak: 50}, false},
+		{"peak above 100", Thresholds{Start: 10, Peak: 101}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.thresholds.validate()
+			if tt.valid && err != nil {
+				t.Errorf("validate returned: %v", err)
+			}
+
+			if !tt.valid && err == nil {
+				t.Errorf("validate returned no error")
+			}
+		})
+	}
+}
+
+func TestReceiveKeepsEvent(t *testing.T) {
+	a, _ := New(nil, nil)
+	tid := a.topic.LastID()
+
+	a.topic.Publish(`{"1":{"level":5,"level_percent":50,"event":"applause-started"}}`)
+	a.topic.Publish(`{"1":{"level":6,"level_percent":60}}`)
+
+	_, msg, err := a.Receive(t.Context(), tid, 1)
+	if err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+
+	if msg.Level != 6 || msg.Event != EventStarted {
+		t.Errorf("got level %d with event `%s`, expected level 6 with event `%s`", msg.Level, msg.Event, EventStarted)
+	}
+}
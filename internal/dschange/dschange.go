@@ -0,0 +1,167 @@
+// Package dschange distributes the changed keys of the datastore.
+//
+// The flow of the datastore can only be updated by one caller. The Watcher is
+// this caller and lets many goroutines wait for changes of their keys.
+package dschange
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/OpenSlides/openslides-go/datastore/dskey"
+	"github.com/OpenSlides/openslides-go/datastore/flow"
+	"github.com/OpenSlides/openslides-go/oslog"
+	"github.com/ostcar/topic"
+)
+
+// pruneTime is the time, changed keys are kept. The waiting goroutines read
+// the changes immediately, so it can be short.
+const pruneTime = time.Minute
+
+// Watcher knows the changed keys of the datastore.
+//
+// Has to be created with New().
+type Watcher struct {
+	topic *topic.Topic[dskey.Key]
+}
+
+// New initializes a Watcher.
+func New() (*Watcher, func(context.Context, func(error))) {
+	w := Watcher{
+		topic: topic.New[dskey.Key](),
+	}
+
+	background := func(ctx context.Context, errHandler func(error)) {
+		go w.pruneOldData(ctx)
+	}
+
+	return &w, background
+}
+
+// Update is the callback for flow.Updater.Update.
+func (w *Watcher) Update(data map[dskey.Key][]byte, err error) {
+	if err != nil {
+		oslog.Error("Receiving datastore update: %v", err)
+		return
+	}
+
+	if len(data) == 0 {
+		return
+	}
+
+	keys := make([]dskey.Key, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	w.topic.Publish(keys...)
+}
+
+// LastID returns the id of the newest change.
+//
+// It has to be called before the keys are read from the datastore and is the
+// argument for Wait().
+func (w *Watcher) LastID() uint64 {
+	return w.topic.LastID()
+}
+
+// Wait blocks until one of the keys changed after the given id.
+//
+// Returns the id to use for the next call. If the changes after the id are
+// already pruned, it returns, since it is unknown if the keys were changed.
+func (w *Watcher) Wait(ctx context.Context, id uint64, keys []dskey.Key) (uint64, error) {
+	wanted := make(map[dskey.Key]struct{}, len(keys))
+	for _, key := range keys {
+		wanted[key] = struct{}{}
+	}
+
+	id, _, err := w.WaitFunc(ctx, id, func(key dskey.Key) bool {
+		_, ok := wanted[key]
+		return ok
+	})
+	return id, err
+}
+
+// WaitFunc is like Wait, but waits for keys, where match returns true.
+//
+// Returns the matching keys. If the changes after the id are already pruned,
+// the returned keys are nil.
+func (w *Watcher) WaitFunc(ctx context.Context, id uint64, match func(dskey.Key) bool) (uint64, []dskey.Key, error) {
+	for {
+		newID, changed, err := w.topic.ReceiveSince(ctx, id)
+		if err != nil {
+			var errUnknownID topic.UnknownIDError
+			if errors.As(err, &errUnknownID) {
+				return w.topic.LastID(), nil, nil
+			}
+			return 0, nil, err
+		}
+		id = newID
+
+		var matched []dskey.Key
+		for _, key := range changed {
+			if match(key) {
+				matched = append(matched, key)
+			}
+		}
+
+		if len(matched) > 0 {
+			return id, matched, nil
+		}
+	}
+}
+
+// pruneOldData removes old changes.
+func (w *Watcher) pruneOldData(ctx context.Context) {
+	tick := time.NewTicker(pruneTime)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			w.topic.Prune(time.Now().Add(-pruneTime))
+		}
+	}
+}
+
+// Recorder is a flow.Getter that records all requested keys.
+type Recorder struct {
+	getter flow.Getter
+
+	mu   sync.Mutex
+	keys map[dskey.Key]struct{}
+}
+
+// NewRecorder initializes a Recorder.
+func NewRecorder(getter flow.Getter) *Recorder {
+	return &Recorder{
+		getter: getter,
+		keys:   make(map[dskey.Key]struct{}),
+	}
+}
+
+// Get fetches the keys from the wrapped getter and records them.
+func (r *Recorder) Get(ctx context.Context, keys ...dskey.Key) (map[dskey.Key][]byte, error) {
+	r.mu.Lock()
+	for _, key := range keys {
+		r.keys[key] = struct{}{}
+	}
+	r.mu.Unlock()
+
+	return r.getter.Get(ctx, keys...)
+}
+
+// Keys returns all keys that were requested.
+func (r *Recorder) Keys() []dskey.Key {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keys := make([]dskey.Key, 0, len(r.keys))
+	for key := range r.keys {
+		keys = append(keys, key)
+	}
+	return keys
+}
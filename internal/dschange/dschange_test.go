@@ -0,0 +1,67 @@
+package dschange_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/OpenSlides/openslides-go/datastore/dskey"
+	"github.com/OpenSlides/openslides-go/datastore/dsmock"
+	"github.com/OpenSlides/openslides-icc-service/internal/dschange"
+)
+
+func TestWait(t *testing.T) {
+	watcher, bg := dschange.New()
+	go bg(t.Context(), nil)
+
+	myKey := dskey.MustKey("user/1/username")
+	otherKey := dskey.MustKey("user/2/username")
+
+	t.Run("Other key changed", func(t *testing.T) {
+		id := watcher.LastID()
+		watcher.Update(map[dskey.Key][]byte{otherKey: []byte(`"hans"`)}, nil)
+
+		ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+		defer cancel()
+
+		if _, err := watcher.Wait(ctx, id, []dskey.Key{myKey}); err == nil {
+			t.Errorf("Wait returned without a change of the key")
+		}
+	})
+
+	t.Run("Key changed", func(t *testing.T) {
+		id := watcher.LastID()
+		watcher.Update(map[dskey.Key][]byte{otherKey: []byte(`"hans"`)}, nil)
+		watcher.Update(map[dskey.Key][]byte{myKey: []byte(`"hans"`)}, nil)
+
+		ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+		defer cancel()
+
+		newID, err := watcher.Wait(ctx, id, []dskey.Key{myKey})
+		if err != nil {
+			t.Fatalf("Wait returned: %v", err)
+		}
+
+		if newID != watcher.LastID() {
+			t.Errorf("Wait returned id %d, expected %d", newID, watcher.LastID())
+		}
+	})
+}
+
+func TestRecorder(t *testing.T) {
+	key1 := dskey.MustKey("user/1/username")
+	key2 := dskey.MustKey("user/2/username")
+	recorder := dschange.NewRecorder(dsmock.Stub{})
+
+	if _, err := recorder.Get(t.Context(), key1, key2); err != nil {
+		t.Fatalf("Get returned: %v", err)
+	}
+
+	if _, err := recorder.Get(t.Context(), key1); err != nil {
+		t.Fatalf("Get returned: %v", err)
+	}
+
+	if got := len(recorder.Keys()); got != 2 {
+		t.Errorf("recorder has %d keys, expected 2", got)
+	}
+}
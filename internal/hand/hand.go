@@ -0,0 +1,272 @@
+// Package hand lets participants of a meeting raise their hand.
+//
+// Each meeting has an ordered queue of raised hands. It is a lightweight
+// alternative to the list of speakers, that does not create objects in the
+// datastore. All receivers of a meeting get the full queue each time it
+// changes.
+package hand
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/OpenSlides/openslides-go/datastore/dsfetch"
+	"github.com/OpenSlides/openslides-go/datastore/flow"
+	"github.com/OpenSlides/openslides-go/perm"
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+	"github.com/OpenSlides/openslides-icc-service/internal/icchttp"
+	"github.com/OpenSlides/openslides-icc-service/internal/meetingtopic"
+	"github.com/OpenSlides/openslides-icc-service/internal/ratelimit"
+	"github.com/ostcar/topic"
+)
+
+// pruneTime is the time after that messages are removed from the topic.
+const pruneTime = 10 * time.Minute
+
+// Backend stores the queues of raised hands.
+type Backend interface {
+	// HandRaise adds a user to the end of the queue of a meeting. The time is
+	// a unix time stamp in milliseconds.
+	//
+	// If the user is already in the queue, the position and the time are
+	// kept.
+	HandRaise(meetingID, userID int, time int64) error
+
+	// HandLower removes a user from the queue of a meeting.
+	HandLower(meetingID, userID int) error
+
+	// HandClear removes all users from the queue of a meeting.
+	HandClear(meetingID int) error
+
+	// HandQueue returns the user ids of the queue of a meeting in order and
+	// the times in milliseconds, they raised their hands.
+	HandQueue(meetingID int) (userIDs []int, raisedAt []int64, err error)
+
+	// HandChanged is a blocking function that returns the id of a meeting,
+	// where the queue changed.
+	//
+	// It is expected, that only one goroutine is calling this function.
+	HandChanged(ctx context.Context) (meetingID int, err error)
+}
+
+// Hand holds the state of the service.
+type Hand struct {
+	backend   Backend
+	topic     *meetingtopic.Topic[MSG]
+	datastore flow.Getter
+	limiter   *ratelimit.Limiter
+}
+
+// Option configures the hand service.
+type Option func(*Hand)
+
+// WithRateLimit sets a rate limiter that is used for each raised hand.
+func WithRateLimit(l *ratelimit.Limiter) Option {
+	return func(h *Hand) {
+		h.limiter = l
+	}
+}
+
+// New returns an initialized state of the hand service.
+func New(b Backend, db flow.Getter, options ...Option) (*Hand, func(context.Context, func(error))) {
+	hand := Hand{
+		backend:   b,
+		topic:     meetingtopic.New[MSG](),
+		datastore: db,
+	}
+
+	for _, o := range options {
+		o(&hand)
+	}
+
+	background := func(ctx context.Context, errHandler func(error)) {
+		go hand.listen(ctx, errHandler)
+		go hand.pruneOldData(ctx)
+	}
+
+	return &hand, background
+}
+
+// Entry is a raised hand in the queue. RaisedAt is a unix time stamp in
+// milliseconds.
+type Entry struct {
+	UserID   int   `json:"user_id"`
+	RaisedAt int64 `json:"raised_at"`
+}
+
+// MSG contains the queue of a meeting.
+type MSG struct {
+	Queue []Entry `json:"queue"`
+
+	icchttp.StreamHeartbeat
+}
+
+// Raise adds a user to the queue of a meeting.
+func (h *Hand) Raise(ctx context.Context, meetingID, userID int) error {
+	if err := h.checkPermission(ctx, meetingID, userID, perm.ListOfSpeakersCanBeSpeaker); err != nil {
+		return err
+	}
+
+	if err := h.limiter.Allow("hand", userID, "", meetingID); err != nil {
+		return fmt.Errorf("rate limit: %w", err)
+	}
+
+	if err := h.backend.HandRaise(meetingID, userID, time.Now().UnixMilli()); err != nil {
+		return fmt.Errorf("raise hand in backend: %w", err)
+	}
+	return nil
+}
+
+// Lower removes a user from the queue of a meeting.
+//
+// If lowerUserID is 0 or the id of the user, the user lowers the own hand.
+// Lowering the hand of another user needs the permission to manage the list
+// of speakers.
+func (h *Hand) Lower(ctx context.Context, meetingID, userID, lowerUserID int) error {
+	if lowerUserID == 0 {
+		lowerUserID = userID
+	}
+
+	permission := perm.ListOfSpeakersCanBeSpeaker
+	if lowerUserID != userID {
+		permission = perm.ListOfSpeakersCanManage
+	}
+
+	if err := h.checkPermission(ctx, meetingID, userID, permission); err != nil {
+		return err
+	}
+
+	if err := h.backend.HandLower(meetingID, lowerUserID); err != nil {
+		return fmt.Errorf("lower hand in backend: %w", err)
+	}
+	return nil
+}
+
+// Clear removes all users from the queue of a meeting.
+//
+// The user needs the permission to manage the list of speakers.
+func (h *Hand) Clear(ctx context.Context, meetingID, userID int) error {
+	if err := h.checkPermission(ctx, meetingID, userID, perm.ListOfSpeakersCanManage); err != nil {
+		return err
+	}
+
+	if err := h.backend.HandClear(meetingID); err != nil {
+		return fmt.Errorf("clear hands in backend: %w", err)
+	}
+	return nil
+}
+
+// CanReceive returns an error, if the user can not receive the queue.
+func (h *Hand) CanReceive(ctx context.Context, meetingID, userID int) error {
+	return h.checkPermission(ctx, meetingID, userID, perm.ListOfSpeakersCanSee)
+}
+
+func (h *Hand) checkPermission(ctx context.Context, meetingID, userID int, permission perm.TPermission) error {
+	if userID == 0 {
+		return iccerror.NewMessageError(iccerror.ErrNotAllowed, "Anonymous is not allowed to raise hands.")
+	}
+
+	perms, err := perm.New(ctx, dsfetch.New(h.datastore), userID, meetingID)
+	if err != nil {
+		return fmt.Errorf("getting permissions: %w", err)
+	}
+
+	if !perms.Has(permission) {
+		return iccerror.NewMessageError(iccerror.ErrNotAllowed, "You need the permission %s in meeting %d.", permission, meetingID)
+	}
+	return nil
+}
+
+// queue returns the queue of a meeting from the backend.
+func (h *Hand) queue(meetingID int) ([]Entry, error) {
+	userIDs, raisedAt, err := h.backend.HandQueue(meetingID)
+	if err != nil {
+		return nil, fmt.Errorf("fetching queue from backend: %w", err)
+	}
+
+	queue := make([]Entry, len(userIDs))
+	for i, userID := range userIDs {
+		queue[i] = Entry{UserID: userID, RaisedAt: raisedAt[i]}
+	}
+	return queue, nil
+}
+
+// Receive returns the queue of a meeting.
+//
+// The first call with tid 0 returns the current queue. The next calls block
+// until the queue changes.
+func (h *Hand) Receive(ctx context.Context, tid uint64, meetingID int) (newTID uint64, msg MSG, err error) {
+	if tid == 0 {
+		tid = h.topic.LastID()
+
+		queue, err := h.queue(meetingID)
+		if err != nil {
+			return 0, MSG{}, fmt.Errorf("getting queue: %w", err)
+		}
+
+		return tid, MSG{Queue: queue}, nil
+	}
+
+	tid, messages, err := h.topic.Receive(ctx, tid, meetingID)
+	if err != nil {
+		var errUnknownID topic.UnknownIDError
+		if !errors.As(err, &errUnknownID) {
+			return 0, MSG{}, err
+		}
+
+		// Each message contains the full queue, so the pruned messages can be
+		// skipped.
+		return h.Receive(ctx, 0, meetingID)
+	}
+
+	// Only the newest queue of the meeting is relevant.
+	return tid, messages[len(messages)-1], nil
+}
+
+// listen waits for changed queues in the backend and saves them for the
+// clients to fetch.
+func (h *Hand) listen(ctx context.Context, errHandler func(error)) {
+	if errHandler == nil {
+		errHandler = func(error) {}
+	}
+
+	for {
+		meetingID, err := h.backend.HandChanged(ctx)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+
+			errHandler(fmt.Errorf("receiving hand changes from backend: %w", err))
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		queue, err := h.queue(meetingID)
+		if err != nil {
+			errHandler(fmt.Errorf("getting queue of meeting %d: %w", meetingID, err))
+			continue
+		}
+
+		if err := h.topic.Publish(map[int]MSG{meetingID: {Queue: queue}}); err != nil {
+			errHandler(err)
+		}
+	}
+}
+
+// pruneOldData removes old messages from the topic.
+func (h *Hand) pruneOldData(ctx context.Context) {
+	tick := time.NewTicker(time.Minute)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			h.topic.Prune(time.Now().Add(-pruneTime))
+		}
+	}
+}
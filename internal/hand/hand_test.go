@@ -0,0 +1,161 @@
+package hand_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/OpenSlides/openslides-go/datastore/dsmock"
+	"github.com/OpenSlides/openslides-icc-service/internal/hand"
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+)
+
+func TestPermissions(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		permissions string
+		userID      int
+		call        func(ctx context.Context, h *hand.Hand, userID int) error
+		allowed     bool
+	}{
+		{
+			"raise anonymous",
+			"list_of_speakers.can_be_speaker",
+			0,
+			func(ctx context.Context, h *hand.Hand, userID int) error { return h.Raise(ctx, 1, userID) },
+			false,
+		},
+		{
+			"raise",
+			"list_of_speakers.can_be_speaker",
+			5,
+			func(ctx context.Context, h *hand.Hand, userID int) error { return h.Raise(ctx, 1, userID) },
+			true,
+		},
+		{
+			"raise without permission",
+			"",
+			5,
+			func(ctx context.Context, h *hand.Hand, userID int) error { return h.Raise(ctx, 1, userID) },
+			false,
+		},
+		{
+			"lower own hand",
+			"list_of_speakers.can_be_speaker",
+			5,
+			func(ctx context.Context, h *hand.Hand, userID int) error { return h.Lower(ctx, 1, userID, 0) },
+			true,
+		},
+		{
+			"lower other hand",
+			"list_of_speakers.can_manage",
+			5,
+			func(ctx context.Context, h *hand.Hand, userID int) error { return h.Lower(ctx, 1, userID, 6) },
+			true,
+		},
+		{
+			"lower other hand as speaker",
+			"list_of_speakers.can_be_speaker",
+			5,
+			func(ctx context.Context, h *hand.Hand, userID int) error { return h.Lower(ctx, 1, userID, 6) },
+			false,
+		},
+		{
+			"clear",
+			"list_of_speakers.can_manage",
+			5,
+			func(ctx context.Context, h *hand.Hand, userID int) error { return h.Clear(ctx, 1, userID) },
+			true,
+		},
+		{
+			"clear as speaker",
+			"list_of_speakers.can_be_speaker",
+			5,
+			func(ctx context.Context, h *hand.Hand, userID int) error { return h.Clear(ctx, 1, userID) },
+			false,
+		},
+		{
+			"receive",
+			"list_of_speakers.can_see",
+			5,
+			func(ctx context.Context, h *hand.Hand, userID int) error { return h.CanReceive(ctx, 1, userID) },
+			true,
+		},
+		{
+			"receive without permission",
+			"",
+			5,
+			func(ctx context.Context, h *hand.Hand, userID int) error { return h.CanReceive(ctx, 1, userID) },
+			false,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := dsmock.Stub(dsmock.YAMLData(fmt.Sprintf(`---
+			user/5/meeting_user_ids: [50]
+			meeting_user/50:
+				meeting_id: 1
+				user_id: 5
+				group_ids: [13]
+			group/13/permissions: [%s]
+			meeting/1/admin_group_id: 1
+			`, tt.permissions)))
+			h, _ := hand.New(newBackendStub(), ds)
+
+			err := tt.call(t.Context(), h, tt.userID)
+
+			if tt.allowed {
+				if err != nil {
+					t.Errorf("got error `%v`, expected no error", err)
+				}
+				return
+			}
+
+			if !errors.Is(err, iccerror.ErrNotAllowed) {
+				t.Errorf("got error `%v`, expected `%v`", err, iccerror.ErrNotAllowed)
+			}
+		})
+	}
+}
+
+func TestReceive(t *testing.T) {
+	backend := newBackendStub()
+	h, bg := hand.New(backend, nil)
+	go bg(t.Context(), nil)
+
+	backend.queues[1] = []int{5, 6}
+
+	tid, msg, err := h.Receive(t.Context(), 0, 1)
+	if err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+
+	expect := []hand.Entry{{UserID: 5}, {UserID: 6}}
+	if !reflect.DeepEqual(msg.Queue, expect) {
+		t.Errorf("first message has queue %v, expected %v", msg.Queue, expect)
+	}
+
+	// A change in another meeting does not create a message.
+	if err := backend.HandRaise(2, 7, 0); err != nil {
+		t.Fatalf("HandRaise: %v", err)
+	}
+
+	if err := backend.HandLower(1, 5); err != nil {
+		t.Fatalf("HandLower: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), time.Second)
+	defer cancel()
+
+	_, msg, err = h.Receive(ctx, tid, 1)
+	if err != nil {
+		t.Fatalf("Receive after change: %v", err)
+	}
+
+	expect = []hand.Entry{{UserID: 6}}
+	if !reflect.DeepEqual(msg.Queue, expect) {
+		t.Errorf("message after change has queue %v, expected %v", msg.Queue, expect)
+	}
+}
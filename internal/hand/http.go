@@ -0,0 +1,119 @@
+package hand
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+	"github.com/OpenSlides/openslides-icc-service/internal/icchttp"
+)
+
+// Changer changes the queue of a meeting.
+type Changer interface {
+	Raise(ctx context.Context, meetingID, uid int) error
+	Lower(ctx context.Context, meetingID, uid, lowerUserID int) error
+	Clear(ctx context.Context, meetingID, uid int) error
+}
+
+// HandleChange registers the icc/hand/raise, icc/hand/lower and
+// icc/hand/clear routes.
+//
+// The lower route has the optional query `user_id` to lower the hand of
+// another user.
+func HandleChange(mux *http.ServeMux, hand Changer, auth icchttp.Authenticater) {
+	for action, call := range map[string]func(ctx context.Context, meetingID, uid int, r *http.Request) error{
+		"raise": func(ctx context.Context, meetingID, uid int, r *http.Request) error {
+			return hand.Raise(ctx, meetingID, uid)
+		},
+		"lower": func(ctx context.Context, meetingID, uid int, r *http.Request) error {
+			var lowerUserID int
+			if userStr := r.URL.Query().Get("user_id"); userStr != "" {
+				var err error
+				lowerUserID, err = strconv.Atoi(userStr)
+				if err != nil {
+					return iccerror.NewMessageError(iccerror.ErrInvalid, "Query user_id has to be an int.")
+				}
+			}
+			return hand.Lower(ctx, meetingID, uid, lowerUserID)
+		},
+		"clear": func(ctx context.Context, meetingID, uid int, r *http.Request) error {
+			return hand.Clear(ctx, meetingID, uid)
+		},
+	} {
+		url := icchttp.Path + "/hand/" + action
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+
+			uid := auth.FromContext(r.Context())
+			if uid == 0 {
+				w.WriteHeader(401)
+				icchttp.ErrorNoStatus(w, iccerror.NewMessageError(iccerror.ErrNotAllowed, "Anonymous user can not raise hands."))
+				return
+			}
+
+			meetingID, err := strconv.Atoi(r.URL.Query().Get("meeting_id"))
+			if err != nil {
+				icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrInvalid, "Query meeting_id has to be an int."))
+				return
+			}
+
+			if err := call(r.Context(), meetingID, uid, r); err != nil {
+				icchttp.Error(w, fmt.Errorf("%s hand: %w", action, err))
+				return
+			}
+		})
+
+		mux.Handle(
+			url,
+			icchttp.AuthMiddleware(handler, auth),
+		)
+	}
+}
+
+// Receiver gets the queues.
+type Receiver interface {
+	Receive(ctx context.Context, tid uint64, meetingID int) (newTID uint64, msg MSG, err error)
+	CanReceive(ctx context.Context, meetingID, userID int) error
+}
+
+// HandleReceive registers the icc/hand route.
+//
+// The first message contains the current queue and the heartbeat interval. On
+// an idle stream, the last message is repeated with the field `heartbeat`
+// after the interval.
+func HandleReceive(mux *http.ServeMux, hand Receiver, auth icchttp.Authenticater, heartbeat time.Duration) {
+	url := icchttp.Path + "/hand"
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store, max-age=0")
+
+		meetingID, err := strconv.Atoi(r.URL.Query().Get("meeting_id"))
+		if err != nil {
+			icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrInvalid, "Query meeting_id has to be an int."))
+			return
+		}
+
+		heartbeat, err := icchttp.HeartbeatInterval(r, heartbeat)
+		if err != nil {
+			icchttp.Error(w, err)
+			return
+		}
+
+		if err := hand.CanReceive(r.Context(), meetingID, auth.FromContext(r.Context())); err != nil {
+			icchttp.Error(w, err)
+			return
+		}
+
+		icchttp.Stream(w, r, heartbeat, func(ctx context.Context, tid uint64, _ MSG) (uint64, MSG, error) {
+			return hand.Receive(ctx, tid, meetingID)
+		}, nil)
+	})
+
+	mux.Handle(
+		url,
+		icchttp.AuthMiddleware(handler, auth),
+	)
+}
@@ -0,0 +1,77 @@
+package hand_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/hand"
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+	"github.com/OpenSlides/openslides-icc-service/internal/icctest"
+)
+
+func TestHandleChange(t *testing.T) {
+	t.Run("Anonymous", func(t *testing.T) {
+		changer := changerStub{}
+		mux := http.NewServeMux()
+		hand.HandleChange(mux, &changer, &icctest.AutherStub{})
+		resp := httptest.NewRecorder()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("GET", "/system/icc/hand/raise?meeting_id=1", nil))
+
+		if resp.Result().StatusCode != 401 {
+			t.Fatalf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+		}
+
+		if !strings.Contains(resp.Body.String(), iccerror.ErrNotAllowed.Type()) {
+			t.Errorf("handler returned message `%s`, expected to contain `%s`", resp.Body.String(), iccerror.ErrNotAllowed.Type())
+		}
+
+		if changer.called != "" {
+			t.Errorf("handler did call %s", changer.called)
+		}
+	})
+
+	for _, tt := range []struct {
+		name          string
+		url           string
+		expectCalled  string
+		expectLowerID int
+	}{
+		{"raise", "/system/icc/hand/raise?meeting_id=1", "raise", 0},
+		{"lower own", "/system/icc/hand/lower?meeting_id=1", "lower", 0},
+		{"lower other", "/system/icc/hand/lower?meeting_id=1&user_id=7", "lower", 7},
+		{"clear", "/system/icc/hand/clear?meeting_id=1", "clear", 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			changer := changerStub{}
+			mux := http.NewServeMux()
+			hand.HandleChange(mux, &changer, &icctest.AutherStub{UserID: 1})
+			resp := httptest.NewRecorder()
+
+			mux.ServeHTTP(resp, httptest.NewRequest("GET", tt.url, nil))
+
+			if resp.Result().StatusCode != 200 {
+				t.Fatalf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+			}
+
+			if changer.called != tt.expectCalled || changer.calledMeetingID != 1 || changer.calledUserID != 1 || changer.calledLowerID != tt.expectLowerID {
+				t.Errorf("handler called %s in meeting %d with user %d and lower id %d, expected %s, 1, 1 and %d", changer.called, changer.calledMeetingID, changer.calledUserID, changer.calledLowerID, tt.expectCalled, tt.expectLowerID)
+			}
+		})
+	}
+
+	t.Run("invalid user_id", func(t *testing.T) {
+		changer := changerStub{}
+		mux := http.NewServeMux()
+		hand.HandleChange(mux, &changer, &icctest.AutherStub{UserID: 1})
+		resp := httptest.NewRecorder()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("GET", "/system/icc/hand/lower?meeting_id=1&user_id=abc", nil))
+
+		if resp.Result().StatusCode != 400 {
+			t.Errorf("handler returned status %s, expected 400", resp.Result().Status)
+		}
+	})
+}
@@ -0,0 +1,95 @@
+package hand_test
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+type changerStub struct {
+	called          string
+	calledUserID    int
+	calledMeetingID int
+	calledLowerID   int
+}
+
+func (s *changerStub) Raise(ctx context.Context, meetingID, uid int) error {
+	s.called = "raise"
+	s.calledMeetingID = meetingID
+	s.calledUserID = uid
+	return nil
+}
+
+func (s *changerStub) Lower(ctx context.Context, meetingID, uid, lowerUserID int) error {
+	s.called = "lower"
+	s.calledMeetingID = meetingID
+	s.calledUserID = uid
+	s.calledLowerID = lowerUserID
+	return nil
+}
+
+func (s *changerStub) Clear(ctx context.Context, meetingID, uid int) error {
+	s.called = "clear"
+	s.calledMeetingID = meetingID
+	s.calledUserID = uid
+	return nil
+}
+
+type backendStub struct {
+	mu      sync.Mutex
+	queues  map[int][]int
+	changed chan int
+}
+
+func newBackendStub() *backendStub {
+	return &backendStub{
+		queues:  make(map[int][]int),
+		changed: make(chan int, 10),
+	}
+}
+
+func (b *backendStub) HandRaise(meetingID, userID int, time int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !slices.Contains(b.queues[meetingID], userID) {
+		b.queues[meetingID] = append(b.queues[meetingID], userID)
+	}
+	b.changed <- meetingID
+	return nil
+}
+
+func (b *backendStub) HandLower(meetingID, userID int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.queues[meetingID] = slices.DeleteFunc(b.queues[meetingID], func(id int) bool { return id == userID })
+	b.changed <- meetingID
+	return nil
+}
+
+func (b *backendStub) HandClear(meetingID int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.queues, meetingID)
+	b.changed <- meetingID
+	return nil
+}
+
+func (b *backendStub) HandQueue(meetingID int) ([]int, []int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	userIDs := slices.Clone(b.queues[meetingID])
+	return userIDs, make([]int64, len(userIDs)), nil
+}
+
+func (b *backendStub) HandChanged(ctx context.Context) (int, error) {
+	select {
+	case meetingID := <-b.changed:
+		return meetingID, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
@@ -1,6 +1,11 @@
 package iccerror
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
 
 const (
 	// ErrInternal should not happen.
@@ -12,6 +17,21 @@ const (
 	// ErrNotAllowed happens on a vote request, when the request user is
 	// anonymous or is not allowed for the request.
 	ErrNotAllowed
+
+	// ErrTooManyRequests happens, when a client sends more requests then
+	// allowed by the rate limits.
+	ErrTooManyRequests
+
+	// ErrTooSlow happens, when a client reads the messages of a stream too
+	// slowly.
+	ErrTooSlow
+
+	// ErrLoggedOut happens, when the session of a stream was logged out.
+	ErrLoggedOut
+
+	// ErrConflict happens, when a value was changed since the client read
+	// it.
+	ErrConflict
 )
 
 // TypeError is an error that can happend in this API.
@@ -26,6 +46,18 @@ func (err TypeError) Type() string {
 	case ErrNotAllowed:
 		return "not-allowed"
 
+	case ErrTooManyRequests:
+		return "too-many-requests"
+
+	case ErrTooSlow:
+		return "too-slow"
+
+	case ErrLoggedOut:
+		return "logged-out"
+
+	case ErrConflict:
+		return "conflict"
+
 	default:
 		return "internal"
 	}
@@ -40,6 +72,18 @@ func (err TypeError) Error() string {
 	case ErrNotAllowed:
 		msg = "You are not allowed to do this."
 
+	case ErrTooManyRequests:
+		msg = "You are sending too many requests."
+
+	case ErrTooSlow:
+		msg = "You are receiving the messages too slowly."
+
+	case ErrLoggedOut:
+		msg = "Your session was logged out."
+
+	case ErrConflict:
+		msg = "The data was changed in the meantime."
+
 	default:
 		msg = "Ups, something went wrong!"
 
@@ -64,9 +108,58 @@ func NewMessageError(t TypeError, format string, a ...any) error {
 }
 
 func (err MessageError) Error() string {
-	return fmt.Sprintf(`{"error":"%s","msg":"%s"}`, err.t.Type(), err.msg)
+	// The message can contain user input, so it has to be escaped.
+	bs, jsonErr := json.Marshal(struct {
+		Error string `json:"error"`
+		MSG   string `json:"msg"`
+	}{err.t.Type(), err.msg})
+	if jsonErr != nil {
+		return err.t.Error()
+	}
+	return string(bs)
+}
+
+// Type returns the name of the error type.
+func (err MessageError) Type() string {
+	return err.t.Type()
 }
 
 func (err MessageError) Unwrap() error {
 	return err.t
 }
+
+// RateLimitError is an ErrTooManyRequests with the duration after that the
+// client can try again.
+type RateLimitError struct {
+	retryAfter time.Duration
+}
+
+// NewRateLimitError creates an error for a client that has to wait before the
+// next request.
+func NewRateLimitError(retryAfter time.Duration) error {
+	return RateLimitError{retryAfter}
+}
+
+func (err RateLimitError) Error() string {
+	return NewMessageError(ErrTooManyRequests, "Too many requests. Try again in %d seconds.", err.RetryAfterSeconds()).Error()
+}
+
+// Type returns the name of the error type.
+func (err RateLimitError) Type() string {
+	return ErrTooManyRequests.Type()
+}
+
+// RetryAfter returns the duration the client has to wait.
+func (err RateLimitError) RetryAfter() time.Duration {
+	return err.retryAfter
+}
+
+// RetryAfterSeconds returns the duration the client has to wait in full
+// seconds as used in the http header `Retry-After`.
+func (err RateLimitError) RetryAfterSeconds() int {
+	return int(math.Ceil(err.retryAfter.Seconds()))
+}
+
+func (err RateLimitError) Unwrap() error {
+	return ErrTooManyRequests
+}
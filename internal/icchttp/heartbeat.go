@@ -0,0 +1,73 @@
+package icchttp
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+)
+
+const (
+	// MinHeartbeat is the smallest heartbeat interval a client can request.
+	MinHeartbeat = time.Second
+
+	// MaxHeartbeat is the biggest heartbeat interval a client can request.
+	MaxHeartbeat = 5 * time.Minute
+)
+
+// errHeartbeat is the cause of the context returned by HeartbeatContext, when
+// the interval is over.
+var errHeartbeat = errors.New("heartbeat is due")
+
+// HeartbeatInterval returns the interval in which heartbeats are written on an
+// idle stream.
+//
+// The client can request an interval in seconds with the url query
+// `heartbeat`. The value is limited to MinHeartbeat and MaxHeartbeat. 0
+// disables the heartbeat. Without the query, defaultInterval is used.
+func HeartbeatInterval(r *http.Request, defaultInterval time.Duration) (time.Duration, error) {
+	query := r.URL.Query().Get("heartbeat")
+	if query == "" {
+		return defaultInterval, nil
+	}
+
+	seconds, err := strconv.Atoi(query)
+	if err != nil || seconds < 0 {
+		return 0, iccerror.NewMessageError(iccerror.ErrInvalid, "url query heartbeat has to be a positive int")
+	}
+
+	if seconds == 0 {
+		return 0, nil
+	}
+
+	interval := time.Duration(seconds) * time.Second
+	return min(max(interval, MinHeartbeat), MaxHeartbeat), nil
+}
+
+// HeartbeatSeconds returns the interval in seconds as it is sent to the
+// client.
+func HeartbeatSeconds(interval time.Duration) int {
+	return int(math.Ceil(interval.Seconds()))
+}
+
+// HeartbeatContext returns a context, that is canceled after the interval.
+//
+// It is used to stop waiting for the next message of a stream. Use
+// IsHeartbeat() to check, if an error was returned because the heartbeat is
+// due. An interval of 0 disables the timeout.
+func HeartbeatContext(ctx context.Context, interval time.Duration) (context.Context, context.CancelFunc) {
+	if interval <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeoutCause(ctx, interval, errHeartbeat)
+}
+
+// IsHeartbeat returns true, if the error was created, because a context from
+// HeartbeatContext was canceled.
+func IsHeartbeat(err error) bool {
+	return errors.Is(err, errHeartbeat)
+}
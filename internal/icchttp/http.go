@@ -11,6 +11,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/OpenSlides/openslides-go/oslog"
 	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
@@ -33,25 +35,41 @@ func ErrorNoStatus(w io.Writer, err error) {
 		return
 	}
 
-	msg := err.Error()
-
 	var errTyped interface {
 		error
 		Type() string
 	}
 	if !errors.As(err, &errTyped) {
 		// Unknown error. Handle as 500er.
-		msg = iccerror.ErrInternal.Error()
 		oslog.Error("Error: %v", err)
+		fmt.Fprint(w, iccerror.ErrInternal.Error())
+		return
 	}
 
-	fmt.Fprint(w, msg)
+	// Only write the typed error, since the wrapping messages are not json.
+	fmt.Fprint(w, errTyped.Error())
+}
+
+// WriteTimeout is the maximum time, a write to a stream can take, before the
+// connection is closed.
+const WriteTimeout = 30 * time.Second
+
+// SetWriteDeadline sets the deadline for the next write to a stream.
+//
+// Connections to clients, that do not read the stream, are closed after the
+// deadline. Does nothing, if the ResponseWriter does not support deadlines.
+func SetWriteDeadline(w http.ResponseWriter) {
+	err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(WriteTimeout))
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		oslog.Debug("Can not set write deadline: %v", err)
+	}
 }
 
 // Error sends an error message to the client as json-message.
 //
 // If the error does not have a Type() string message, it is handled as 500er.
-// In other case, it is handled as 400er.
+// Errors of the type iccerror.ErrTooManyRequests are handled as 429er. In other
+// case, it is handled as 400er.
 func Error(w http.ResponseWriter, err error) {
 	if isConnectionClose(err) {
 		return
@@ -63,11 +81,24 @@ func Error(w http.ResponseWriter, err error) {
 	}
 	status := 500
 	if errors.As(err, &errTyped) {
-		if errTyped.Type() != iccerror.ErrInternal.Error() {
+		if errTyped.Type() != iccerror.ErrInternal.Type() {
 			status = 400
 		}
 	}
 
+	if errors.Is(err, iccerror.ErrConflict) {
+		status = 409
+	}
+
+	if errors.Is(err, iccerror.ErrTooManyRequests) {
+		status = 429
+
+		var errRateLimit iccerror.RateLimitError
+		if errors.As(err, &errRateLimit) {
+			w.Header().Set("Retry-After", strconv.Itoa(errRateLimit.RetryAfterSeconds()))
+		}
+	}
+
 	w.WriteHeader(status)
 	oslog.Debug("HTTP: Returning status %d", status)
 	ErrorNoStatus(w, err)
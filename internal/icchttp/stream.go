@@ -0,0 +1,84 @@
+package icchttp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// StreamHeartbeat contains the heartbeat fields of a message, that is written
+// with Stream. It has to be embedded in the message.
+//
+// HeartbeatInterval is only set on the first message of a stream. Heartbeat is
+// set, if the message is written because the stream was idle.
+type StreamHeartbeat struct {
+	HeartbeatInterval int  `json:"heartbeat_interval,omitempty"`
+	Heartbeat         bool `json:"heartbeat,omitempty"`
+}
+
+func (h *StreamHeartbeat) streamHeartbeat() *StreamHeartbeat {
+	return h
+}
+
+// StreamMessage is a pointer to a message, that embeds StreamHeartbeat.
+type StreamMessage[T any] interface {
+	*T
+	streamHeartbeat() *StreamHeartbeat
+}
+
+// Stream writes the messages from receive to the client until the request is
+// canceled or receive returns an error.
+//
+// receive gets the tid and the last message, that was written. The first call
+// gets the tid 0 and should return the first message of the stream.
+//
+// If the stream is idle for the heartbeat interval, the message from idle is
+// written with the field `heartbeat`. idle gets the last message. If idle is
+// nil, the last message is repeated.
+func Stream[T any, PT StreamMessage[T]](
+	w http.ResponseWriter,
+	r *http.Request,
+	heartbeat time.Duration,
+	receive func(ctx context.Context, tid uint64, last T) (uint64, T, error),
+	idle func(last T) T,
+) {
+	encoder := json.NewEncoder(w)
+	var tid uint64
+	var message T
+	for {
+		ctx, cancel := HeartbeatContext(r.Context(), heartbeat)
+		newTID, newMessage, err := receive(ctx, tid, message)
+		cancel()
+
+		switch {
+		case IsHeartbeat(err):
+			if idle != nil {
+				message = idle(message)
+			}
+			PT(&message).streamHeartbeat().HeartbeatInterval = 0
+			PT(&message).streamHeartbeat().Heartbeat = true
+
+		case err != nil:
+			// The last line can be written after a long idle time.
+			SetWriteDeadline(w)
+			ErrorNoStatus(w, fmt.Errorf("receiving message: %w", err))
+			return
+
+		default:
+			if tid == 0 && heartbeat > 0 {
+				PT(&newMessage).streamHeartbeat().HeartbeatInterval = HeartbeatSeconds(heartbeat)
+			}
+			tid = newTID
+			message = newMessage
+		}
+
+		SetWriteDeadline(w)
+		if err := encoder.Encode(message); err != nil {
+			ErrorNoStatus(w, fmt.Errorf("writing message: %w", err))
+			return
+		}
+		w.(http.Flusher).Flush()
+	}
+}
@@ -0,0 +1,162 @@
+package icchttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+)
+
+// DefaultTimeInterval is the interval of the time stream, if the client does
+// not request another interval.
+const DefaultTimeInterval = 10 * time.Second
+
+// TimeSample is the answer to a time request. All times are unix times in
+// milliseconds.
+//
+// ClientTime is the time sent by the client. ServerReceive is the time, the
+// server received the request and ServerSend the time it sent the answer.
+//
+// With the time ClientReceive, when the client got the answer, the client can
+// calculate like in NTP:
+//
+//	offset = ((ServerReceive - ClientTime) + (ServerSend - ClientReceive)) / 2
+//	round trip time = (ClientReceive - ClientTime) - (ServerSend - ServerReceive)
+type TimeSample struct {
+	ClientTime    int64 `json:"client_time,omitempty"`
+	ServerReceive int64 `json:"server_receive"`
+	ServerSend    int64 `json:"server_send"`
+}
+
+// ServerTime returns the current time of the server as unix time in
+// milliseconds.
+func ServerTime() int64 {
+	return time.Now().UnixMilli()
+}
+
+// clientTime reads the url query `client_time`.
+func clientTime(r *http.Request) (int64, error) {
+	query := r.URL.Query().Get("client_time")
+	if query == "" {
+		return 0, nil
+	}
+
+	t, err := strconv.ParseInt(query, 10, 64)
+	if err != nil || t < 0 {
+		return 0, iccerror.NewMessageError(iccerror.ErrInvalid, "url query client_time has to be a unix time in milliseconds")
+	}
+	return t, nil
+}
+
+// HandleTime registers the icc/time and icc/time/stream routes.
+//
+// The time route returns a TimeSample for the query `client_time`.
+//
+// The time stream starts with a TimeSample. Afterwards, the line
+// `{"server_time":...}` is written after each interval. The client can request
+// an interval in seconds with the query `interval`.
+//
+// The routes do not need authentication, since the time of the server is no
+// secret and the anonymous projector needs it. This also keeps the time of the
+// authentication out of the samples.
+func HandleTime(mux *http.ServeMux) {
+	mux.HandleFunc(
+		Path+"/time",
+		func(w http.ResponseWriter, r *http.Request) {
+			serverReceive := ServerTime()
+
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store, max-age=0")
+
+			clientTime, err := clientTime(r)
+			if err != nil {
+				Error(w, err)
+				return
+			}
+
+			sample := TimeSample{
+				ClientTime:    clientTime,
+				ServerReceive: serverReceive,
+				ServerSend:    ServerTime(),
+			}
+
+			if err := json.NewEncoder(w).Encode(sample); err != nil {
+				ErrorNoStatus(w, fmt.Errorf("writing time: %w", err))
+			}
+		},
+	)
+
+	mux.HandleFunc(
+		Path+"/time/stream",
+		func(w http.ResponseWriter, r *http.Request) {
+			serverReceive := ServerTime()
+
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store, max-age=0")
+
+			clientTime, err := clientTime(r)
+			if err != nil {
+				Error(w, err)
+				return
+			}
+
+			interval, err := timeInterval(r)
+			if err != nil {
+				Error(w, err)
+				return
+			}
+
+			encoder := json.NewEncoder(w)
+
+			SetWriteDeadline(w)
+			sample := TimeSample{
+				ClientTime:    clientTime,
+				ServerReceive: serverReceive,
+				ServerSend:    ServerTime(),
+			}
+			if err := encoder.Encode(sample); err != nil {
+				ErrorNoStatus(w, fmt.Errorf("writing time: %w", err))
+				return
+			}
+			w.(http.Flusher).Flush()
+
+			tick := time.NewTicker(interval)
+			defer tick.Stop()
+
+			for {
+				select {
+				case <-r.Context().Done():
+					return
+				case <-tick.C:
+				}
+
+				SetWriteDeadline(w)
+				if _, err := fmt.Fprintf(w, `{"server_time":%d}`+"\n", ServerTime()); err != nil {
+					ErrorNoStatus(w, fmt.Errorf("writing time: %w", err))
+					return
+				}
+				w.(http.Flusher).Flush()
+			}
+		},
+	)
+}
+
+// timeInterval reads the url query `interval` in seconds. The value is limited
+// like the heartbeat interval.
+func timeInterval(r *http.Request) (time.Duration, error) {
+	query := r.URL.Query().Get("interval")
+	if query == "" {
+		return DefaultTimeInterval, nil
+	}
+
+	seconds, err := strconv.Atoi(query)
+	if err != nil || seconds <= 0 {
+		return 0, iccerror.NewMessageError(iccerror.ErrInvalid, "url query interval has to be a positive int")
+	}
+
+	interval := time.Duration(seconds) * time.Second
+	return min(max(interval, MinHeartbeat), MaxHeartbeat), nil
+}
@@ -0,0 +1,74 @@
+package icchttp_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/icchttp"
+)
+
+func TestHandleTime(t *testing.T) {
+	mux := http.NewServeMux()
+	icchttp.HandleTime(mux)
+
+	t.Run("Sample", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		before := time.Now().UnixMilli()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("GET", "/system/icc/time?client_time=1700000000000", nil))
+
+		if resp.Result().StatusCode != 200 {
+			t.Fatalf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+		}
+
+		var sample icchttp.TimeSample
+		if err := json.Unmarshal(resp.Body.Bytes(), &sample); err != nil {
+			t.Fatalf("decoding response: %v", err)
+		}
+
+		if sample.ClientTime != 1700000000000 {
+			t.Errorf("client time is %d, expected 1700000000000", sample.ClientTime)
+		}
+
+		if sample.ServerReceive < before || sample.ServerSend < sample.ServerReceive {
+			t.Errorf("server times are %d and %d, expected increasing times after %d", sample.ServerReceive, sample.ServerSend, before)
+		}
+	})
+
+	t.Run("Invalid client time", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("GET", "/system/icc/time?client_time=now", nil))
+
+		if resp.Result().StatusCode != 400 {
+			t.Errorf("handler returned status %s, expected 400", resp.Result().Status)
+		}
+	})
+
+	t.Run("Stream", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+		defer cancel()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("GET", "/system/icc/time/stream?client_time=5&interval=1", nil).WithContext(ctx))
+
+		lines := strings.Split(strings.TrimSpace(resp.Body.String()), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("stream returned %d lines, expected 2: %s", len(lines), resp.Body.String())
+		}
+
+		if !strings.HasPrefix(lines[0], `{"client_time":5,"server_receive":`) {
+			t.Errorf("first line is %s, expected a time sample", lines[0])
+		}
+
+		if !strings.HasPrefix(lines[1], `{"server_time":`) {
+			t.Errorf("second line is %s, expected the server time", lines[1])
+		}
+	})
+}
@@ -0,0 +1,87 @@
+// Package meetingtopic is a topic for the streams of meetings.
+//
+// Each message of the topic contains values for one or more meetings. The
+// receivers of a meeting only get the values of their meeting.
+package meetingtopic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ostcar/topic"
+)
+
+// Topic holds the messages for the meetings.
+type Topic[T any] struct {
+	topic *topic.Topic[string]
+}
+
+// New returns an initialized topic.
+func New[T any]() *Topic[T] {
+	t := topic.New[string]()
+
+	// Make sure the topic is not empty, so LastID is never 0. Receivers use
+	// the id 0 for their first message.
+	t.Publish("")
+
+	return &Topic[T]{topic: t}
+}
+
+// LastID returns the id of the newest message.
+func (t *Topic[T]) LastID() uint64 {
+	return t.topic.LastID()
+}
+
+// Publish sends the values to the receivers of their meetings.
+func (t *Topic[T]) Publish(values map[int]T) error {
+	b, err := json.Marshal(values)
+	if err != nil {
+		return fmt.Errorf("encoding message: %w", err)
+	}
+
+	t.topic.Publish(string(b))
+	return nil
+}
+
+// Receive blocks until there are values for the meeting after tid. It returns
+// the new tid and the values in the order they were published.
+//
+// If the messages after tid were already pruned, the error is a
+// topic.UnknownIDError.
+func (t *Topic[T]) Receive(ctx context.Context, tid uint64, meetingID int) (uint64, []T, error) {
+	for {
+		var messages []string
+		var err error
+		tid, messages, err = t.topic.ReceiveSince(ctx, tid)
+		if err != nil {
+			return 0, nil, fmt.Errorf("receiving message from topic: %w", err)
+		}
+
+		var values []T
+		for _, message := range messages {
+			if message == "" {
+				continue
+			}
+
+			var decoded map[int]T
+			if err := json.Unmarshal([]byte(message), &decoded); err != nil {
+				return 0, nil, fmt.Errorf("decoding message from topic: %w", err)
+			}
+
+			if value, ok := decoded[meetingID]; ok {
+				values = append(values, value)
+			}
+		}
+
+		if len(values) > 0 {
+			return tid, values, nil
+		}
+	}
+}
+
+// Prune removes the messages, that are older than until.
+func (t *Topic[T]) Prune(until time.Time) {
+	t.topic.Prune(until)
+}
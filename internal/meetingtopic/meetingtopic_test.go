@@ -0,0 +1,73 @@
+package meetingtopic_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/meetingtopic"
+	"github.com/ostcar/topic"
+)
+
+func TestReceive(t *testing.T) {
+	top := meetingtopic.New[string]()
+	tid := top.LastID()
+
+	if tid == 0 {
+		t.Fatalf("LastID of a new topic is 0")
+	}
+
+	for _, values := range []map[int]string{
+		{1: "first"},
+		{2: "other meeting"},
+		{1: "second", 2: "other meeting"},
+	} {
+		if err := top.Publish(values); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+
+	newTID, values, err := top.Receive(t.Context(), tid, 1)
+	if err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+
+	if newTID != top.LastID() {
+		t.Errorf("Receive returned tid %d, expected %d", newTID, top.LastID())
+	}
+
+	if !reflect.DeepEqual(values, []string{"first", "second"}) {
+		t.Errorf("Receive returned %v, expected [first second]", values)
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, _, err := top.Receive(ctx, newTID, 3); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Receive for a meeting without values returned `%v`, expected a timeout", err)
+	}
+}
+
+func TestReceivePruned(t *testing.T) {
+	top := meetingtopic.New[string]()
+	tid := top.LastID()
+
+	if err := top.Publish(map[int]string{1: "value"}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	top.Prune(time.Now().Add(time.Second))
+
+	// An empty topic blocks instead of returning an error.
+	if err := top.Publish(map[int]string{1: "new value"}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	_, _, err := top.Receive(t.Context(), tid, 1)
+
+	var errUnknownID topic.UnknownIDError
+	if !errors.As(err, &errUnknownID) {
+		t.Errorf("Receive returned `%v`, expected an UnknownIDError", err)
+	}
+}
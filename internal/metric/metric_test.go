@@ -0,0 +1,44 @@
+package metric_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/metric"
+)
+
+func TestMetrics(t *testing.T) {
+	counter := metric.NewCounter("test_counter_total", "A test counter.")
+	histogram := metric.NewHistogram("test_histogram", "A test histogram.", []float64{10, 1})
+
+	counter.Inc()
+	counter.Inc()
+	histogram.Observe(0.5)
+	histogram.Observe(5)
+	histogram.Observe(50)
+
+	mux := http.NewServeMux()
+	metric.HandleMetrics(mux)
+	resp := httptest.NewRecorder()
+
+	mux.ServeHTTP(resp, httptest.NewRequest("GET", "/metrics", nil))
+
+	if resp.Result().StatusCode != 200 {
+		t.Fatalf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+	}
+
+	for _, expect := range []string{
+		"test_counter_total 2\n",
+		`test_histogram_bucket{le="1"} 1` + "\n",
+		`test_histogram_bucket{le="10"} 2` + "\n",
+		`test_histogram_bucket{le="+Inf"} 3` + "\n",
+		"test_histogram_sum 55.5\n",
+		"test_histogram_count 3\n",
+	} {
+		if !strings.Contains(resp.Body.String(), expect) {
+			t.Errorf("metrics do not contain %q:\n%s", expect, resp.Body.String())
+		}
+	}
+}
@@ -26,6 +26,12 @@ func (c channelID) uid() int {
 	return uid
 }
 
+// ChannelUserID returns the user id of a channel id. Returns 0 for an invalid
+// channel id.
+func ChannelUserID(cid string) int {
+	return channelID(cid).uid()
+}
+
 func (c channelID) String() string {
 	return string(c)
 }
@@ -50,6 +56,13 @@ func (c *cIDGen) generate(uid int) channelID {
 	return channelID(cid)
 }
 
+// hostID returns the random id of this instance, that is used as first part of
+// each channel id.
+func (c *cIDGen) hostID() string {
+	c.hostGen.Do(c.buildHostID)
+	return c.host
+}
+
 func (c *cIDGen) buildHostID() {
 	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 	const length = 8
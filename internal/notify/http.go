@@ -1,11 +1,13 @@
 package notify
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/OpenSlides/openslides-go/oslog"
 	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
@@ -14,12 +16,19 @@ import (
 
 // Receiver is a type with the function Receive(). It is a blocking function
 // that writes the notify-messages to the writer as soon as they occur.
+//
+// KeepAlive is called for the lifetime of the channel.
 type Receiver interface {
 	Receive(meetingID, uid int) (cid string, mp NextMessage)
+	KeepAlive(ctx context.Context, cid string)
 }
 
 // HandleReceive registers the notify route.
-func HandleReceive(mux *http.ServeMux, notify Receiver, auth icchttp.Authenticater) {
+//
+// On an idle stream, the line `{"heartbeat":true,"server_time":...}` is written
+// after the heartbeat interval. The interval is sent to the client in the first
+// line. The server time is a unix time in milliseconds.
+func HandleReceive(mux *http.ServeMux, notify Receiver, auth icchttp.Authenticater, heartbeat time.Duration) {
 	url := icchttp.Path + "/notify"
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/octet-stream")
@@ -43,13 +52,28 @@ func HandleReceive(mux *http.ServeMux, notify Receiver, auth icchttp.Authenticat
 			}
 		}
 
+		heartbeat, err := icchttp.HeartbeatInterval(r, heartbeat)
+		if err != nil {
+			icchttp.Error(w, err)
+			return
+		}
+
 		cid, next := notify.Receive(meetingID, uid)
 
 		oslog.Debug("HTTP Recieve from user %d, channel id: %s", uid, cid)
 		defer oslog.Debug("Closed HTTP Recieve from user %d, channel id: %s", uid, cid)
 
+		// The channel is alive until the request is closed.
+		go notify.KeepAlive(r.Context(), cid)
+
 		// Send channel id.
-		if _, err := fmt.Fprintf(w, `{"channel_id": "%s"}`+"\n", cid); err != nil {
+		firstLine := fmt.Sprintf(`{"channel_id": "%s"}`, cid)
+		if heartbeat > 0 {
+			firstLine = fmt.Sprintf(`{"channel_id": "%s", "heartbeat_interval": %d}`, cid, icchttp.HeartbeatSeconds(heartbeat))
+		}
+
+		icchttp.SetWriteDeadline(w)
+		if _, err := fmt.Fprintln(w, firstLine); err != nil {
 			icchttp.Error(w, fmt.Errorf("sending channel id: %w", err))
 			return
 		}
@@ -58,12 +82,28 @@ func HandleReceive(mux *http.ServeMux, notify Receiver, auth icchttp.Authenticat
 		encoder := json.NewEncoder(w)
 
 		for {
-			message, err := next(r.Context())
+			ctx, cancel := icchttp.HeartbeatContext(r.Context(), heartbeat)
+			message, err := next(ctx)
+			cancel()
+
+			if icchttp.IsHeartbeat(err) {
+				icchttp.SetWriteDeadline(w)
+				if _, err := fmt.Fprintf(w, `{"heartbeat":true,"server_time":%d}`+"\n", icchttp.ServerTime()); err != nil {
+					icchttp.ErrorNoStatus(w, fmt.Errorf("sending heartbeat: %w", err))
+					return
+				}
+				w.(http.Flusher).Flush()
+				continue
+			}
+
 			if err != nil {
+				// The last line can be written after a long idle time.
+				icchttp.SetWriteDeadline(w)
 				icchttp.ErrorNoStatus(w, fmt.Errorf("receiving message: %w", err))
 				return
 			}
 
+			icchttp.SetWriteDeadline(w)
 			if err := encoder.Encode(message); err != nil {
 				icchttp.ErrorNoStatus(w, fmt.Errorf("sending message: %w", err))
 				return
@@ -81,7 +121,7 @@ func HandleReceive(mux *http.ServeMux, notify Receiver, auth icchttp.Authenticat
 
 // Publisher saves a notify message.
 type Publisher interface {
-	Publish(io.Reader, int) error
+	Publish(context.Context, io.Reader, int) error
 }
 
 // HandlePublish registers the notify/publish route.
@@ -97,7 +137,7 @@ func HandlePublish(mux *http.ServeMux, notify Publisher, auth icchttp.Authentica
 			return
 		}
 
-		if err := notify.Publish(r.Body, uid); err != nil {
+		if err := notify.Publish(r.Context(), r.Body, uid); err != nil {
 			icchttp.Error(w, fmt.Errorf("publish notify message: %w", err))
 			return
 		}
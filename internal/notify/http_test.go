@@ -2,6 +2,7 @@ package notify_test
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
@@ -23,7 +24,7 @@ func TestHandleReceive(t *testing.T) {
 		auther := icctest.AutherStub{}
 		receiver := receiverStub{}
 		mux := http.NewServeMux()
-		notify.HandleReceive(mux, &receiver, &auther)
+		notify.HandleReceive(mux, &receiver, &auther, 0)
 		resp := httptest.NewRecorder()
 
 		mux.ServeHTTP(resp, httptest.NewRequest("GET", url, nil))
@@ -50,7 +51,7 @@ func TestHandleReceive(t *testing.T) {
 			UserID: 1,
 		}
 		mux := http.NewServeMux()
-		notify.HandleReceive(mux, &receiver, &auther)
+		notify.HandleReceive(mux, &receiver, &auther, 0)
 		resp := httptest.NewRecorder()
 
 		ctx, cancel := context.WithCancel(context.Background())
@@ -86,7 +87,7 @@ func TestHandleReceive(t *testing.T) {
 			UserID: 1,
 		}
 		mux := http.NewServeMux()
-		notify.HandleReceive(mux, &receiver, &auther)
+		notify.HandleReceive(mux, &receiver, &auther, 0)
 		resp := httptest.NewRecorder()
 
 		ctx, cancel := context.WithCancel(context.Background())
@@ -127,7 +128,7 @@ func TestHandleReceive(t *testing.T) {
 			UserID: 1,
 		}
 		mux := http.NewServeMux()
-		notify.HandleReceive(mux, &receiver, &auther)
+		notify.HandleReceive(mux, &receiver, &auther, 0)
 		resp := httptest.NewRecorder()
 
 		ctx, cancel := context.WithCancel(context.Background())
@@ -164,7 +165,7 @@ func TestHandleReceive(t *testing.T) {
 			UserID: 1,
 		}
 		mux := http.NewServeMux()
-		notify.HandleReceive(mux, &receiver, &auther)
+		notify.HandleReceive(mux, &receiver, &auther, 0)
 		resp := httptest.NewRecorder()
 
 		ctx, cancel := context.WithCancel(context.Background())
@@ -196,7 +197,7 @@ func TestHandleReceive(t *testing.T) {
 			UserID: 1,
 		}
 		mux := http.NewServeMux()
-		notify.HandleReceive(mux, &receiver, &auther)
+		notify.HandleReceive(mux, &receiver, &auther, 0)
 		resp := httptest.NewRecorder()
 
 		ctx, cancel := context.WithCancel(context.Background())
@@ -218,6 +219,91 @@ func TestHandleReceive(t *testing.T) {
 			t.Errorf("handler did not return message: %s", resp.Body.String())
 		}
 	})
+
+	t.Run("Heartbeat", func(t *testing.T) {
+		receiver := receiverStub{
+			cid: "mycid",
+			nm:  mp.Next,
+		}
+		auther := icctest.AutherStub{
+			UserID: 1,
+		}
+		mux := http.NewServeMux()
+		notify.HandleReceive(mux, &receiver, &auther, 5*time.Millisecond)
+		resp := httptest.NewRecorder()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+		defer cancel()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("GET", url, nil).WithContext(ctx))
+
+		lines := strings.Split(resp.Body.String(), "\n")
+
+		if expect := `{"channel_id": "mycid", "heartbeat_interval": 1}`; lines[0] != expect {
+			t.Errorf("first line is %q, expected %q", lines[0], expect)
+		}
+
+		if len(lines) < 2 || !strings.HasPrefix(lines[1], `{"heartbeat":true,"server_time":`) {
+			t.Fatalf("handler did not send a heartbeat: %s", resp.Body.String())
+		}
+
+		var heartbeat struct {
+			ServerTime int64 `json:"server_time"`
+		}
+		if err := json.Unmarshal([]byte(lines[1]), &heartbeat); err != nil {
+			t.Fatalf("decoding heartbeat: %v", err)
+		}
+
+		if diff := time.Now().UnixMilli() - heartbeat.ServerTime; diff < 0 || diff > 1000 {
+			t.Errorf("heartbeat has server time %d, expected the current time", heartbeat.ServerTime)
+		}
+	})
+
+	t.Run("Heartbeat disabled by client", func(t *testing.T) {
+		receiver := receiverStub{
+			cid: "mycid",
+			nm:  mp.Next,
+		}
+		auther := icctest.AutherStub{
+			UserID: 1,
+		}
+		mux := http.NewServeMux()
+		notify.HandleReceive(mux, &receiver, &auther, 5*time.Millisecond)
+		resp := httptest.NewRecorder()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+		defer cancel()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("GET", url+"?heartbeat=0", nil).WithContext(ctx))
+
+		expect := `{"channel_id": "mycid"}` + "\n"
+		if resp.Body.String() != expect {
+			t.Errorf("resp body is %q, expected %q", resp.Body.String(), expect)
+		}
+	})
+
+	t.Run("Invalid heartbeat", func(t *testing.T) {
+		receiver := receiverStub{
+			cid: "mycid",
+			nm:  mp.Next,
+		}
+		auther := icctest.AutherStub{
+			UserID: 1,
+		}
+		mux := http.NewServeMux()
+		notify.HandleReceive(mux, &receiver, &auther, 0)
+		resp := httptest.NewRecorder()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("GET", url+"?heartbeat=soon", nil))
+
+		if resp.Result().StatusCode != 400 {
+			t.Fatalf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+		}
+
+		if receiver.called {
+			t.Errorf("handler did call the reciver")
+		}
+	})
 }
 
 func TestHandleSend(t *testing.T) {
@@ -291,4 +377,29 @@ func TestHandleSend(t *testing.T) {
 			t.Errorf("handler returned the error message: %s", resp.Body.String())
 		}
 	})
+	t.Run("Rate limit", func(t *testing.T) {
+		sender := publisherStub{
+			expectedErr: iccerror.NewRateLimitError(1500 * time.Millisecond),
+		}
+		auther := icctest.AutherStub{
+			UserID: 1,
+		}
+		mux := http.NewServeMux()
+		notify.HandlePublish(mux, &sender, &auther)
+		resp := httptest.NewRecorder()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("GET", url, nil))
+
+		if resp.Result().StatusCode != 429 {
+			t.Fatalf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+		}
+
+		if got := resp.Result().Header.Get("Retry-After"); got != "2" {
+			t.Errorf("handler returned Retry-After `%s`, expected `2`", got)
+		}
+
+		if !strings.Contains(resp.Body.String(), iccerror.ErrTooManyRequests.Type()) {
+			t.Errorf("handler returned message `%s`, expected to contain `%s`", resp.Body.String(), iccerror.ErrTooManyRequests.Type())
+		}
+	})
 }
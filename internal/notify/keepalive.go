@@ -0,0 +1,62 @@
+package notify
+
+import (
+	"context"
+	"time"
+
+	"github.com/OpenSlides/openslides-go/oslog"
+)
+
+// keepAliveInterval is the time between two calls of ChannelAlive for a
+// connected channel.
+const keepAliveInterval = 10 * time.Second
+
+// ChannelKeeper gets informed about the lifetime of the notify channels.
+//
+// It can be used to keep data, that belongs to a channel, as long as the
+// channel is connected.
+type ChannelKeeper interface {
+	// ChannelAlive is called regularly while a channel is connected.
+	ChannelAlive(channelID string) error
+
+	// ChannelClosed is called, when a channel was closed.
+	ChannelClosed(channelID string) error
+}
+
+// WithChannelKeeper sets a keeper, that gets informed about the lifetime of
+// the channels of this instance.
+func WithChannelKeeper(k ChannelKeeper) Option {
+	return func(n *Notify) {
+		n.keeper = k
+	}
+}
+
+// KeepAlive informs the channel keeper that a channel is connected until the
+// context is done.
+//
+// It is a blocking function. It does nothing, if the service has no channel
+// keeper.
+func (n *Notify) KeepAlive(ctx context.Context, channelID string) {
+	if n.keeper == nil {
+		return
+	}
+
+	tick := time.NewTicker(keepAliveInterval)
+	defer tick.Stop()
+
+	for {
+		if err := n.keeper.ChannelAlive(channelID); err != nil {
+			oslog.Error("Keeping channel %s alive: %v", channelID, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			if err := n.keeper.ChannelClosed(channelID); err != nil {
+				oslog.Error("Closing channel %s: %v", channelID, err)
+			}
+			return
+
+		case <-tick.C:
+		}
+	}
+}
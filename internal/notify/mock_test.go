@@ -1,8 +1,11 @@
 package notify_test
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"io"
+	"time"
 
 	"github.com/OpenSlides/openslides-icc-service/internal/notify"
 )
@@ -26,7 +29,7 @@ func (mp *messageProviderStub) Next(ctx context.Context) (notify.OutMessage, err
 	case err := <-mp.err:
 		return notify.OutMessage{}, err
 	case <-ctx.Done():
-		return notify.OutMessage{}, ctx.Err()
+		return notify.OutMessage{}, context.Cause(ctx)
 	}
 }
 
@@ -53,13 +56,15 @@ func (r *receiverStub) Receive(meetingID, uid int) (cid string, nm notify.NextMe
 	return r.cid, r.nm
 }
 
+func (r *receiverStub) KeepAlive(ctx context.Context, cid string) {}
+
 type publisherStub struct {
 	expectedErr  error
 	called       bool
 	calledUserID int
 }
 
-func (s *publisherStub) Publish(r io.Reader, uid int) error {
+func (s *publisherStub) Publish(ctx context.Context, r io.Reader, uid int) error {
 	s.called = true
 	s.calledUserID = uid
 	return s.expectedErr
@@ -68,16 +73,27 @@ func (s *publisherStub) Publish(r io.Reader, uid int) error {
 type backendStub struct {
 	messages         chan []byte
 	receivedMessages [][]byte
+	retained         map[int]map[string][]byte
+	scheduled        map[int64][][]byte
+	messageIDs       map[string]bool
+	lastID           int
+	deadLetters      chan string
+	publishErr       error
 }
 
 func newBackendStrub() *backendStub {
 	b := backendStub{}
 	b.messages = make(chan []byte, 10)
+	b.deadLetters = make(chan string, 10)
 	return &b
 }
 
 func (b *backendStub) reset() {
 	b.receivedMessages = b.receivedMessages[0:0]
+	b.retained = nil
+	b.scheduled = nil
+	b.messageIDs = nil
+	b.publishErr = nil
 	for {
 		select {
 		case <-b.messages:
@@ -88,17 +104,115 @@ func (b *backendStub) reset() {
 }
 
 func (b *backendStub) NotifyPublish(bs []byte) error {
+	if b.publishErr != nil {
+		return b.publishErr
+	}
+
 	b.messages <- bs
 	b.receivedMessages = append(b.receivedMessages, bs)
 	return nil
 }
 
-func (b *backendStub) NotifyReceive(ctx context.Context) (message []byte, err error) {
+func (b *backendStub) NotifyReceive(ctx context.Context) (id string, message []byte, err error) {
 	select {
 	case m := <-b.messages:
-		return m, nil
+		b.lastID++
+		id := fmt.Sprintf("%d-0", b.lastID)
+		if bytes.Contains(m, []byte(`"retain":true`)) {
+			id = retainedID(m)
+		}
+
+		// Simulate a stream entry, that the backend can not parse.
+		if bytes.HasPrefix(m, []byte("malformed:")) {
+			return id, m, fmt.Errorf("malformed entry")
+		}
+		return id, m, nil
 
 	case <-ctx.Done():
-		return nil, ctx.Err()
+		return "", nil, ctx.Err()
+	}
+}
+
+func (b *backendStub) NotifyRetain(meetingID int, name string, message []byte, clear bool) error {
+	if b.retained == nil {
+		b.retained = make(map[int]map[string][]byte)
+	}
+
+	if b.retained[meetingID] == nil {
+		b.retained[meetingID] = make(map[string][]byte)
+	}
+
+	if clear {
+		delete(b.retained[meetingID], name)
+	} else {
+		b.retained[meetingID][name] = message
+	}
+
+	return b.NotifyPublish(message)
+}
+
+func (b *backendStub) NotifyRetained(meetingID int) ([]string, [][]byte, error) {
+	var ids []string
+	var messages [][]byte
+	for _, m := range b.retained[meetingID] {
+		ids = append(ids, retainedID(m))
+		messages = append(messages, m)
+	}
+	return ids, messages, nil
+}
+
+// retainedID returns the id of a retained message in the stub. NotifyReceive
+// returns the same id for the message.
+func retainedID(message []byte) string {
+	return fmt.Sprintf("retained-%x", message)
+}
+
+func (b *backendStub) NotifySchedule(message []byte, deliverAt int64) error {
+	if b.scheduled == nil {
+		b.scheduled = make(map[int64][][]byte)
 	}
+	b.scheduled[deliverAt] = append(b.scheduled[deliverAt], message)
+	return nil
+}
+
+func (b *backendStub) NotifyPublishDue(now int64) error {
+	return nil
+}
+
+func (b *backendStub) NotifyFirstSeen(userID int, messageID string, window time.Duration) (bool, error) {
+	if b.messageIDs == nil {
+		b.messageIDs = make(map[string]bool)
+	}
+
+	key := fmt.Sprintf("%d:%s", userID, messageID)
+	if b.messageIDs[key] {
+		return false, nil
+	}
+	b.messageIDs[key] = true
+	return true, nil
+}
+
+func (b *backendStub) NotifyForget(userID int, messageID string) error {
+	delete(b.messageIDs, fmt.Sprintf("%d:%s", userID, messageID))
+	return nil
+}
+
+func (b *backendStub) NotifyDeadLetter(id string, message []byte, reason string) error {
+	b.deadLetters <- string(message)
+	return nil
+}
+
+type channelKeeperStub struct {
+	alive  chan string
+	closed chan string
+}
+
+func (k *channelKeeperStub) ChannelAlive(channelID string) error {
+	k.alive <- channelID
+	return nil
+}
+
+func (k *channelKeeperStub) ChannelClosed(channelID string) error {
+	k.closed <- channelID
+	return nil
 }
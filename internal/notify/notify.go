@@ -9,11 +9,22 @@ import (
 	"slices"
 	"time"
 
+	"github.com/OpenSlides/openslides-go/datastore/flow"
 	"github.com/OpenSlides/openslides-go/oslog"
 	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+	"github.com/OpenSlides/openslides-icc-service/internal/metric"
+	"github.com/OpenSlides/openslides-icc-service/internal/ratelimit"
 	"github.com/ostcar/topic"
 )
 
+const (
+	// maxMessageIDLength is the maximum length of the client message id.
+	maxMessageIDLength = 128
+
+	// pruneTime is the time after that messages are removed from the topic.
+	pruneTime = 10 * time.Minute
+)
+
 // Backend stores the notify messages.
 type Backend interface {
 	// NotifyPublish saves a valid notify message.
@@ -27,28 +38,152 @@ type Backend interface {
 	//
 	// It is expected, that only one goroutine is calling this function. The
 	// Backend keeps track what the last send message was.
-	NotifyReceive(ctx context.Context) (message []byte, err error)
+	//
+	// The returned id identifies the message. The ids are increasing in the
+	// order of the messages.
+	//
+	// If an invalid message can not be read, the id, the raw message and an
+	// error are returned. The next call returns the next message.
+	NotifyReceive(ctx context.Context) (id string, message []byte, err error)
+
+	// NotifyRetain publishes a valid notify message like NotifyPublish and
+	// saves it as the last message with the given name in a meeting. If clear is
+	// true, the retained message is removed instead.
+	//
+	// Both have to happen atomically. The retained messages of a meeting have
+	// to be removed after some time without a new retained message.
+	NotifyRetain(meetingID int, name string, message []byte, clear bool) error
+
+	// NotifyRetained returns all retained messages of a meeting with the ids,
+	// that NotifyReceive returns for them.
+	NotifyRetained(meetingID int) (ids []string, messages [][]byte, err error)
+
+	// NotifySchedule saves a message that should be published at the given
+	// time as unix time stamp.
+	NotifySchedule(message []byte, deliverAt int64) error
+
+	// NotifyPublishDue publishes all scheduled messages with a delivery time
+	// before or at `now`.
+	//
+	// The function can be called from many instances at the same time. The
+	// implementation has to make sure, that each message is only published
+	// once.
+	NotifyPublishDue(now int64) error
+
+	// NotifyFirstSeen returns true, if the message id was not seen for the
+	// user in the given duration. It remembers the message id for this
+	// duration.
+	NotifyFirstSeen(userID int, messageID string, window time.Duration) (bool, error)
+
+	// NotifyForget removes a message id, that was remembered with
+	// NotifyFirstSeen. It is called, when the message could not be saved.
+	NotifyForget(userID int, messageID string) error
+
+	// NotifyDeadLetter saves an invalid message, that was received with
+	// NotifyReceive, with the reason why it is invalid.
+	NotifyDeadLetter(id string, message []byte, reason string) error
 }
 
+var metricDeadLetters = metric.NewCounter(
+	"icc_notify_dead_letters_total",
+	"Number of invalid notify messages that were moved to the dead letter store.",
+)
+
 // Notify holds the state of the service.
 type Notify struct {
 	backend Backend
 	cIDGen  cIDGen
 	topic   *topic.Topic[string]
+	schemas *Schemas
+
+	maxMessageSize int64
+	limiter        *ratelimit.Limiter
+	dedupWindow    time.Duration
+	datastore      flow.Getter
+
+	slowPolicy SlowConsumerPolicy
+	maxLag     uint64
+
+	keeper ChannelKeeper
+}
+
+// Option configures the notify service.
+type Option func(*Notify)
+
+// WithSchemas sets json schemas that are used to validate the messages before
+// they are published.
+func WithSchemas(s *Schemas) Option {
+	return func(n *Notify) {
+		n.schemas = s
+	}
+}
+
+// WithMaxMessageSize sets the maximum size of a message in bytes. A size of 0
+// means no limit.
+func WithMaxMessageSize(size int64) Option {
+	return func(n *Notify) {
+		n.maxMessageSize = size
+	}
+}
+
+// WithRateLimit sets a rate limiter that is used for each published message.
+func WithRateLimit(l *ratelimit.Limiter) Option {
+	return func(n *Notify) {
+		n.limiter = l
+	}
+}
+
+// WithDeduplicationWindow sets the duration in which messages with the same
+// message id from the same user are only published once. The default is five
+// minutes.
+func WithDeduplicationWindow(d time.Duration) Option {
+	return func(n *Notify) {
+		n.dedupWindow = d
+	}
+}
+
+// WithSenderDetails adds the meeting user id and the name of the sender to
+// each message. The values are fetched from the given datastore.
+func WithSenderDetails(db flow.Getter) Option {
+	return func(n *Notify) {
+		n.datastore = db
+	}
+}
+
+// WithSlowConsumerPolicy sets what happens with receivers, that are more than
+// maxLag messages behind. A maxLag of 0 means no limit. The default is to
+// disconnect receivers that are more than 1000 messages behind.
+//
+// Receivers that are behind more than the pruning time are always handled as
+// slow.
+func WithSlowConsumerPolicy(policy SlowConsumerPolicy, maxLag uint64) Option {
+	return func(n *Notify) {
+		n.slowPolicy = policy
+		n.maxLag = maxLag
+	}
 }
 
 // New returns an initialized state of the notify service.
 //
 // The New function is not blocking. The context is used to stop a goroutine
 // that is started by this function.
-func New(b Backend) (*Notify, func(context.Context, func(error))) {
+func New(b Backend, options ...Option) (*Notify, func(context.Context, func(error))) {
 	notify := Notify{
-		backend: b,
-		topic:   topic.New[string](),
+		backend:     b,
+		topic:       topic.New[string](),
+		dedupWindow: 5 * time.Minute,
+		slowPolicy:  DisconnectSlowConsumer,
+		maxLag:      1000,
+	}
+
+	for _, o := range options {
+		o(&notify)
 	}
 
 	background := func(ctx context.Context, errHandler func(error)) {
 		go notify.listen(ctx, errHandler)
+		go notify.schedule(ctx, errHandler)
+		go notify.pruneOldData(ctx)
 	}
 
 	return &notify, background
@@ -62,19 +197,86 @@ func (n *Notify) listen(ctx context.Context, errhandler func(error)) {
 	}
 
 	for {
-		m, err := n.backend.NotifyReceive(ctx)
+		id, m, err := n.backend.NotifyReceive(ctx)
 		if err != nil {
 			if errors.Is(err, context.Canceled) {
 				return
 			}
 
+			if id != "" {
+				// The entry is invalid, but the backend can read the next one.
+				n.deadLetter(id, m, err, errhandler)
+				continue
+			}
+
 			errhandler(fmt.Errorf("receicing data from backend: %w", err))
 			time.Sleep(5 * time.Second)
 			continue
 		}
 
-		oslog.Debug("Found notify message: `%s`", m)
-		n.topic.Publish(string(m))
+		oslog.Debug("Found notify message %s: `%s`", id, m)
+
+		bs, err := prepareReceived(id, m)
+		if err != nil {
+			n.deadLetter(id, m, err, errhandler)
+			continue
+		}
+
+		n.topic.Publish(string(bs))
+	}
+}
+
+// deadLetter moves an invalid message to the dead letter store.
+func (n *Notify) deadLetter(id string, m []byte, reason error, errhandler func(error)) {
+	metricDeadLetters.Inc()
+	errhandler(fmt.Errorf("invalid notify message %s: %w", id, reason))
+
+	if err := n.backend.NotifyDeadLetter(id, m, reason.Error()); err != nil {
+		errhandler(fmt.Errorf("saving dead letter %s: %w", id, err))
+	}
+}
+
+// prepareReceived validates a message from the backend and adds the id as
+// sequence.
+//
+// The messages are validated once, so the messageProviders of all receivers
+// only get valid messages.
+func prepareReceived(id string, m []byte) ([]byte, error) {
+	var message Message
+	if err := json.Unmarshal(m, &message); err != nil {
+		return nil, fmt.Errorf("decoding message: %w", err)
+	}
+
+	if message.ChannelID.uid() == 0 && !message.System {
+		return nil, fmt.Errorf("invalid channel id `%s`", message.ChannelID)
+	}
+
+	if message.Name == "" {
+		return nil, fmt.Errorf("message without name")
+	}
+
+	message.Sequence = id
+
+	bs, err := json.Marshal(message)
+	if err != nil {
+		return nil, fmt.Errorf("encoding message: %w", err)
+	}
+
+	return bs, nil
+}
+
+// pruneOldData removes old messages from the topic.
+func (n *Notify) pruneOldData(ctx context.Context) {
+	tick := time.NewTicker(time.Minute)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			n.topic.Prune(time.Now().Add(-pruneTime))
+		}
 	}
 }
 
@@ -92,15 +294,32 @@ func (n *Notify) Receive(meetingID, uid int) (cid string, nm NextMessage) {
 		meetingID: meetingID,
 		channelID: channelID,
 		topic:     n.topic,
+		backend:   n.backend,
+
+		slowPolicy: n.slowPolicy,
+		maxLag:     n.maxLag,
 	}
 
 	return channelID.String(), mp.Next
 }
 
 // Publish reads and saves the notify event from the given reader.
-func (n *Notify) Publish(r io.Reader, uid int) error {
+func (n *Notify) Publish(ctx context.Context, r io.Reader, uid int) error {
+	if n.maxMessageSize > 0 {
+		r = io.LimitReader(r, n.maxMessageSize+1)
+	}
+
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("reading message: %w", err)
+	}
+
+	if n.maxMessageSize > 0 && int64(len(body)) > n.maxMessageSize {
+		return iccerror.NewMessageError(iccerror.ErrInvalid, "message is bigger than %d bytes", n.maxMessageSize)
+	}
+
 	var message Message
-	if err := json.NewDecoder(r).Decode(&message); err != nil {
+	if err := json.Unmarshal(body, &message); err != nil {
 		return iccerror.NewMessageError(iccerror.ErrInvalid, "invalid json: %v", err)
 	}
 
@@ -108,12 +327,68 @@ func (n *Notify) Publish(r io.Reader, uid int) error {
 		return fmt.Errorf("validate message: %w", err)
 	}
 
+	if n.schemas != nil {
+		if err := n.schemas.validate(message.Name, message.Message); err != nil {
+			return fmt.Errorf("validate message schema: %w", err)
+		}
+	}
+
+	if err := n.limiter.Allow("notify", uid, message.ChannelID.String(), message.ToMeeting); err != nil {
+		return fmt.Errorf("rate limit: %w", err)
+	}
+
+	if message.MessageID != "" {
+		firstSeen, err := n.backend.NotifyFirstSeen(uid, message.MessageID, n.dedupWindow)
+		if err != nil {
+			return fmt.Errorf("checking message id: %w", err)
+		}
+
+		if !firstSeen {
+			oslog.Debug("Ignoring duplicate notify message %s from user %d", message.MessageID, uid)
+			return nil
+		}
+	}
+
+	if err := n.save(ctx, message, uid); err != nil {
+		// Forget the message id, so the client can retry the message.
+		if message.MessageID != "" {
+			if err := n.backend.NotifyForget(uid, message.MessageID); err != nil {
+				oslog.Error("Forgetting message id %s of user %d: %v", message.MessageID, uid, err)
+			}
+		}
+		return err
+	}
+
+	return nil
+}
+
+// save sets the server fields of a valid message and saves it in the backend.
+func (n *Notify) save(ctx context.Context, message Message, uid int) error {
+	if err := n.setServerFields(ctx, &message, uid); err != nil {
+		return fmt.Errorf("setting server fields: %w", err)
+	}
+
 	bs, err := json.Marshal(message)
 	if err != nil {
 		return fmt.Errorf("marshal notify message: %v", err)
 	}
 
+	if message.DeliverAt > time.Now().Unix() {
+		oslog.Debug("Schedule notify message for %d: `%s`", message.DeliverAt, bs)
+		if err := n.backend.NotifySchedule(bs, message.DeliverAt); err != nil {
+			return fmt.Errorf("schedule message in backend: %w", err)
+		}
+		return nil
+	}
+
 	oslog.Debug("Saving notify message: `%s`", bs)
+	if message.Retain {
+		if err := n.backend.NotifyRetain(message.ToMeeting, message.Name, bs, message.clearsRetained()); err != nil {
+			return fmt.Errorf("retain message in backend: %w", err)
+		}
+		return nil
+	}
+
 	if err := n.backend.NotifyPublish(bs); err != nil {
 		return fmt.Errorf("saving message in backend: %w", err)
 	}
@@ -121,6 +396,66 @@ func (n *Notify) Publish(r io.Reader, uid int) error {
 	return nil
 }
 
+// PublishSystem sends a message from the server to all receivers of a
+// meeting.
+//
+// The message is not sent from a user. It has the field system and the
+// sender_user_id 0.
+func (n *Notify) PublishSystem(meetingID int, name string, message any) error {
+	encoded, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("encoding system message: %w", err)
+	}
+
+	bs, err := json.Marshal(Message{
+		ChannelID: n.cIDGen.generate(0),
+		ToMeeting: meetingID,
+		Name:      name,
+		Message:   encoded,
+		SentAt:    time.Now().UnixMilli(),
+		Instance:  n.cIDGen.hostID(),
+		System:    true,
+	})
+	if err != nil {
+		return fmt.Errorf("marshal system message: %w", err)
+	}
+
+	oslog.Debug("Saving system notify message: `%s`", bs)
+	if err := n.backend.NotifyPublish(bs); err != nil {
+		return fmt.Errorf("saving system message in backend: %w", err)
+	}
+	return nil
+}
+
+// setServerFields sets the fields of the message, that are set by the server.
+//
+// Values for this fields, that are send by the client, are overwritten.
+func (n *Notify) setServerFields(ctx context.Context, message *Message, uid int) error {
+	now := time.Now()
+	message.SentAt = now.UnixMilli()
+	if message.DeliverAt > now.Unix() {
+		message.SentAt = message.DeliverAt * 1000
+	}
+
+	message.Instance = n.cIDGen.hostID()
+	message.Sequence = ""
+	message.System = false
+	message.SenderMeetingUserID = 0
+	message.SenderName = ""
+
+	if n.datastore != nil {
+		meetingUserID, name, err := senderDetails(ctx, n.datastore, uid, message.ToMeeting)
+		if err != nil {
+			return fmt.Errorf("fetching sender details: %w", err)
+		}
+
+		message.SenderMeetingUserID = meetingUserID
+		message.SenderName = name
+	}
+
+	return nil
+}
+
 func validateMessage(message Message, userID int) error {
 	if message.ChannelID.uid() != userID {
 		return iccerror.NewMessageError(iccerror.ErrInvalid, "invalid channel id `%s`", message.ChannelID)
@@ -130,17 +465,62 @@ func validateMessage(message Message, userID int) error {
 		return iccerror.NewMessageError(iccerror.ErrInvalid, "notify message does not have required field `name`")
 	}
 
+	if len(message.MessageID) > maxMessageIDLength {
+		return iccerror.NewMessageError(iccerror.ErrInvalid, "message_id can not be longer than %d characters", maxMessageIDLength)
+	}
+
+	if message.Retain && message.ToMeeting == 0 {
+		return iccerror.NewMessageError(iccerror.ErrInvalid, "retained messages need the field `to_meeting`")
+	}
+
+	if message.Retain && message.DeliverAt != 0 {
+		return iccerror.NewMessageError(iccerror.ErrInvalid, "scheduled messages can not be retained")
+	}
+
+	if message.ExpiresAt != 0 {
+		if message.expired(time.Now()) {
+			return iccerror.NewMessageError(iccerror.ErrInvalid, "message is already expired")
+		}
+
+		if message.ExpiresAt <= message.DeliverAt {
+			return iccerror.NewMessageError(iccerror.ErrInvalid, "message expires before it is delivered")
+		}
+	}
+
 	return nil
 }
 
 // Message is a message from the one client to all/some others.
 type Message struct {
 	ChannelID  channelID       `json:"channel_id"`
+	MessageID  string          `json:"message_id,omitempty"`
 	ToMeeting  int             `json:"to_meeting,omitempty"`
 	ToUsers    []int           `json:"to_users,omitempty"`
 	ToChannels []string        `json:"to_channels,omitempty"`
 	Name       string          `json:"name"`
 	Message    json.RawMessage `json:"message"`
+	Retain     bool            `json:"retain,omitempty"`
+	DeliverAt  int64           `json:"deliver_at,omitempty"`
+	ExpiresAt  int64           `json:"expires_at,omitempty"`
+
+	// The following fields are set by the server.
+	SentAt              int64  `json:"sent_at,omitempty"`
+	Sequence            string `json:"sequence,omitempty"`
+	Instance            string `json:"instance,omitempty"`
+	SenderMeetingUserID int    `json:"sender_meeting_user_id,omitempty"`
+	SenderName          string `json:"sender_name,omitempty"`
+	System              bool   `json:"system,omitempty"`
+}
+
+// expired returns true, if the message has an expire time that is reached.
+func (m Message) expired(now time.Time) bool {
+	return m.ExpiresAt != 0 && m.ExpiresAt <= now.Unix()
+}
+
+// clearsRetained returns true, if the message removes the retained message
+// with its name.
+func (m Message) clearsRetained() bool {
+	return len(m.Message) == 0 || string(m.Message) == "null"
 }
 
 func (m Message) forMe(meetingID, uid int, cID channelID) bool {
@@ -161,10 +541,17 @@ func (m Message) forMe(meetingID, uid int, cID channelID) bool {
 
 // OutMessage is a message that is going out of the service.
 type OutMessage struct {
-	SenderUserID    int             `json:"sender_user_id"`
-	SenderChannelID string          `json:"sender_channel_id"`
-	Name            string          `json:"name"`
-	Message         json.RawMessage `json:"message"`
+	SenderUserID        int             `json:"sender_user_id"`
+	SenderChannelID     string          `json:"sender_channel_id"`
+	SenderMeetingUserID int             `json:"sender_meeting_user_id,omitempty"`
+	SenderName          string          `json:"sender_name,omitempty"`
+	MessageID           string          `json:"message_id,omitempty"`
+	Timestamp           int64           `json:"timestamp"`
+	Sequence            string          `json:"sequence,omitempty"`
+	Instance            string          `json:"instance"`
+	System              bool            `json:"system,omitempty"`
+	Name                string          `json:"name"`
+	Message             json.RawMessage `json:"message"`
 }
 
 // messageProvider returns messages by calling Next().
@@ -174,43 +561,145 @@ type messageProvider struct {
 	meetingID int
 	channelID channelID
 
-	topic      *topic.Topic[string]
-	messageBuf []string
+	topic *topic.Topic[string]
+
+	// messageBuf contains the messages for the receiver, that were not
+	// returned yet.
+	messageBuf []Message
+
+	backend         Backend
+	retainedFetched bool
+
+	// retained contains the sequences of the retained messages, that were
+	// not received from the topic yet.
+	retained map[string]bool
+
+	slowPolicy SlowConsumerPolicy
+	maxLag     uint64
 }
 
 // Next returns the next message. Can be called many times.
 func (mp *messageProvider) Next(ctx context.Context) (OutMessage, error) {
 	var message Message
 
+	if !mp.retainedFetched {
+		if err := mp.fetchRetained(); err != nil {
+			return OutMessage{}, fmt.Errorf("fetching retained messages: %w", err)
+		}
+	}
+
 	for {
-		if len(mp.messageBuf) == 0 {
-			tid, messages, err := mp.topic.ReceiveSince(ctx, mp.tid)
-			if err != nil {
-				return OutMessage{}, fmt.Errorf("fetching message from topic: %w", err)
+		// The lag is measured before the new messages are fetched. Otherwise
+		// it would only contain the messages of the last fetch.
+		lag := mp.lag()
+		metricConsumerLag.Observe(float64(lag))
+		if mp.maxLag > 0 && lag > mp.maxLag {
+			if err := mp.tooSlow(); err != nil {
+				return OutMessage{}, err
+			}
+			continue
+		}
+
+		// Fetch new messages, also when the buffer is not empty, so the tid
+		// follows the topic, while the receiver reads the buffer.
+		if len(mp.messageBuf) == 0 || mp.topic.LastID() > mp.tid {
+			if err := mp.receive(ctx); err != nil {
+				return OutMessage{}, err
 			}
+		}
 
-			mp.tid = tid
-			mp.messageBuf = messages
+		if len(mp.messageBuf) == 0 {
+			continue
 		}
 
-		m := mp.messageBuf[0]
+		message = mp.messageBuf[0]
 		mp.messageBuf = mp.messageBuf[1:]
 
+		if !message.expired(time.Now()) {
+			break
+		}
+	}
+
+	out := OutMessage{
+		SenderUserID:        message.ChannelID.uid(),
+		SenderChannelID:     message.ChannelID.String(),
+		SenderMeetingUserID: message.SenderMeetingUserID,
+		SenderName:          message.SenderName,
+		MessageID:           message.MessageID,
+		Timestamp:           message.SentAt,
+		Sequence:            message.Sequence,
+		Instance:            message.Instance,
+		System:              message.System,
+		Name:                message.Name,
+		Message:             message.Message,
+	}
+
+	return out, nil
+}
+
+// receive adds the new messages of the topic, that are for the receiver, to
+// the message buffer. It blocks until there is a new message in the topic.
+func (mp *messageProvider) receive(ctx context.Context) error {
+	tid, messages, err := mp.topic.ReceiveSince(ctx, mp.tid)
+	if err != nil {
+		var errUnknownID topic.UnknownIDError
+		if !errors.As(err, &errUnknownID) {
+			return fmt.Errorf("fetching message from topic: %w", err)
+		}
+
+		// The messages of the receiver were already pruned.
+		return mp.tooSlow()
+	}
+
+	mp.tid = tid
+	for _, m := range messages {
+		var message Message
 		if err := json.Unmarshal([]byte(m), &message); err != nil {
-			return OutMessage{}, fmt.Errorf("decoding message: %w", err)
+			// Never stop the connection because of invalid data from other
+			// users.
+			oslog.Error("Skipping invalid notify message: %v", err)
+			continue
+		}
+
+		if mp.retained[message.Sequence] {
+			// The message was already returned as retained message.
+			delete(mp.retained, message.Sequence)
+			continue
 		}
 
 		if message.forMe(mp.meetingID, mp.uid, mp.channelID) {
-			break
+			mp.messageBuf = append(mp.messageBuf, message)
 		}
 	}
+	return nil
+}
 
-	out := OutMessage{
-		message.ChannelID.uid(),
-		message.ChannelID.String(),
-		message.Name,
-		message.Message,
+// fetchRetained adds the retained messages of the meeting to the message
+// buffer, so they are returned before all other messages.
+//
+// The tid is set before the retained messages are fetched, so a retained
+// message can also be received from the topic. It is skipped there.
+func (mp *messageProvider) fetchRetained() error {
+	if mp.meetingID != 0 {
+		ids, retained, err := mp.backend.NotifyRetained(mp.meetingID)
+		if err != nil {
+			return fmt.Errorf("getting retained messages from backend: %w", err)
+		}
+
+		mp.retained = make(map[string]bool, len(retained))
+		for i, m := range retained {
+			var message Message
+			if err := json.Unmarshal(m, &message); err != nil {
+				oslog.Error("Skipping invalid retained notify message: %v", err)
+				continue
+			}
+
+			message.Sequence = ids[i]
+			mp.retained[message.Sequence] = true
+			mp.messageBuf = append(mp.messageBuf, message)
+		}
 	}
 
-	return out, nil
+	mp.retainedFetched = true
+	return nil
 }
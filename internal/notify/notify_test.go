@@ -2,7 +2,11 @@ package notify_test
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -19,7 +23,7 @@ func TestSend(t *testing.T) {
 	t.Run("invalid json", func(t *testing.T) {
 		defer backend.reset()
 
-		err := n.Publish(strings.NewReader(`{123`), 1)
+		err := n.Publish(t.Context(), strings.NewReader(`{123`), 1)
 
 		if !errors.Is(err, iccerror.ErrInvalid) {
 			t.Errorf("send() returned err `%s`, expected `%s`", err, iccerror.ErrInvalid.Error())
@@ -29,7 +33,7 @@ func TestSend(t *testing.T) {
 	t.Run("invalid format", func(t *testing.T) {
 		defer backend.reset()
 
-		err := n.Publish(strings.NewReader(`{"to_users":1,"message":"hans"}`), 1)
+		err := n.Publish(t.Context(), strings.NewReader(`{"to_users":1,"message":"hans"}`), 1)
 
 		if !errors.Is(err, iccerror.ErrInvalid) {
 			t.Errorf("send() returned err `%s`, expected `%s`", err, iccerror.ErrInvalid.Error())
@@ -39,7 +43,7 @@ func TestSend(t *testing.T) {
 	t.Run("no channel_id", func(t *testing.T) {
 		defer backend.reset()
 
-		err := n.Publish(strings.NewReader(`
+		err := n.Publish(t.Context(), strings.NewReader(`
 		{
 			"to_users": [2],
 			"message": "hans"
@@ -54,7 +58,7 @@ func TestSend(t *testing.T) {
 	t.Run("invalid channel_id", func(t *testing.T) {
 		defer backend.reset()
 
-		err := n.Publish(strings.NewReader(`
+		err := n.Publish(t.Context(), strings.NewReader(`
 		{
 			"channel_id": "abc",
 			"to_users": [2],
@@ -69,7 +73,7 @@ func TestSend(t *testing.T) {
 	t.Run("no Name", func(t *testing.T) {
 		defer backend.reset()
 
-		err := n.Publish(strings.NewReader(`
+		err := n.Publish(t.Context(), strings.NewReader(`
 		{
 			"channel_id": "server:1:2",
 			"to_users": [2],
@@ -84,7 +88,7 @@ func TestSend(t *testing.T) {
 	t.Run("valid", func(t *testing.T) {
 		defer backend.reset()
 
-		err := n.Publish(strings.NewReader(`
+		err := n.Publish(t.Context(), strings.NewReader(`
 		{
 			"channel_id": "server:1:2",
 			"name": "message-name",
@@ -100,9 +104,26 @@ func TestSend(t *testing.T) {
 			t.Fatalf("backend received %d messages, expected 1", len(backend.receivedMessages))
 		}
 
-		expected := `{"channel_id":"server:1:2","to_users":[2],"name":"message-name","message":"hans"}`
-		if string(backend.receivedMessages[0]) != expected {
-			t.Errorf("received message:\n%s\n\nexpected:\n%s", backend.receivedMessages[0], expected)
+		var received map[string]json.RawMessage
+		if err := json.Unmarshal(backend.receivedMessages[0], &received); err != nil {
+			t.Fatalf("decoding received message: %v", err)
+		}
+
+		if _, ok := received["sent_at"]; !ok {
+			t.Errorf("received message has no sent_at: %s", backend.receivedMessages[0])
+		}
+
+		if _, ok := received["instance"]; !ok {
+			t.Errorf("received message has no instance: %s", backend.receivedMessages[0])
+		}
+
+		delete(received, "sent_at")
+		delete(received, "instance")
+		got, _ := json.Marshal(received)
+
+		expected := `{"channel_id":"server:1:2","message":"hans","name":"message-name","to_users":[2]}`
+		if string(got) != expected {
+			t.Errorf("received message:\n%s\n\nexpected:\n%s", got, expected)
 		}
 	})
 }
@@ -115,7 +136,7 @@ func TestReceive(t *testing.T) {
 	_, next := n.Receive(1, 2)
 
 	t.Run("Get first message", func(t *testing.T) {
-		if err := n.Publish(strings.NewReader(`{"channel_id":"server:1:2","name":"message-name","to_users":[2],"message":"hans"}`), 1); err != nil {
+		if err := n.Publish(t.Context(), strings.NewReader(`{"channel_id":"server:1:2","name":"message-name","to_users":[2],"message":"hans"}`), 1); err != nil {
 			t.Fatalf("sending message: %v", err)
 		}
 
@@ -139,10 +160,22 @@ func TestReceive(t *testing.T) {
 		if string(notifyMessage.Message) != `"hans"` {
 			t.Errorf("message.message == %s, expected hans", notifyMessage.Message)
 		}
+
+		if notifyMessage.Timestamp == 0 {
+			t.Errorf("message.timestamp is not set")
+		}
+
+		if notifyMessage.Sequence == "" {
+			t.Errorf("message.sequence is not set")
+		}
+
+		if notifyMessage.Instance == "" {
+			t.Errorf("message.instance is not set")
+		}
 	})
 
 	t.Run("Message for meeting", func(t *testing.T) {
-		if err := n.Publish(strings.NewReader(`{"channel_id":"server:1:2","name":"to-meeting-name","to_meeting":1,"message":"klaus"}`), 1); err != nil {
+		if err := n.Publish(t.Context(), strings.NewReader(`{"channel_id":"server:1:2","name":"to-meeting-name","to_meeting":1,"message":"klaus"}`), 1); err != nil {
 			t.Fatalf("sending message: %v", err)
 		}
 
@@ -161,7 +194,7 @@ func TestReceive(t *testing.T) {
 	})
 
 	t.Run("Message not for me", func(t *testing.T) {
-		if err := n.Publish(strings.NewReader(`{"channel_id":"server:1:2","name":"message-name","to_users":[3],"message":"hans"}`), 1); err != nil {
+		if err := n.Publish(t.Context(), strings.NewReader(`{"channel_id":"server:1:2","name":"message-name","to_users":[3],"message":"hans"}`), 1); err != nil {
 			t.Fatalf("sending message: %v", err)
 		}
 
@@ -183,3 +216,612 @@ func TestReceive(t *testing.T) {
 		}
 	})
 }
+
+func TestPublishSchema(t *testing.T) {
+	dir := t.TempDir()
+	schema := `{
+		"type": "object",
+		"properties": {"screen": {"type": "integer"}},
+		"required": ["screen"]
+	}`
+	if err := os.WriteFile(filepath.Join(dir, "screen-share.json"), []byte(schema), 0o644); err != nil {
+		t.Fatalf("writing schema: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name          string
+		rejectUnknown bool
+		message       string
+		expectValid   bool
+	}{
+		{
+			"valid",
+			false,
+			`{"channel_id":"server:1:2","name":"screen-share","to_meeting":1,"message":{"screen":5}}`,
+			true,
+		},
+		{
+			"wrong type",
+			false,
+			`{"channel_id":"server:1:2","name":"screen-share","to_meeting":1,"message":{"screen":"five"}}`,
+			false,
+		},
+		{
+			"missing field",
+			false,
+			`{"channel_id":"server:1:2","name":"screen-share","to_meeting":1,"message":{}}`,
+			false,
+		},
+		{
+			"unknown name",
+			false,
+			`{"channel_id":"server:1:2","name":"other","to_meeting":1,"message":"hans"}`,
+			true,
+		},
+		{
+			"unknown name rejected",
+			true,
+			`{"channel_id":"server:1:2","name":"other","to_meeting":1,"message":"hans"}`,
+			false,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			schemas, err := notify.LoadSchemas(dir, tt.rejectUnknown)
+			if err != nil {
+				t.Fatalf("loading schemas: %v", err)
+			}
+
+			backend := newBackendStrub()
+			n, _ := notify.New(backend, notify.WithSchemas(schemas))
+
+			err = n.Publish(t.Context(), strings.NewReader(tt.message), 1)
+
+			if tt.expectValid {
+				if err != nil {
+					t.Errorf("Publish returned unexpected error: %v", err)
+				}
+				return
+			}
+
+			if !errors.Is(err, iccerror.ErrInvalid) {
+				t.Errorf("Publish returned err `%v`, expected `%s`", err, iccerror.ErrInvalid.Error())
+			}
+
+			if len(backend.receivedMessages) != 0 {
+				t.Errorf("invalid message was saved in the backend")
+			}
+		})
+	}
+}
+
+func TestPublishMaxSize(t *testing.T) {
+	backend := newBackendStrub()
+	n, _ := notify.New(backend, notify.WithMaxMessageSize(100))
+
+	t.Run("small message", func(t *testing.T) {
+		defer backend.reset()
+
+		err := n.Publish(t.Context(), strings.NewReader(`{"channel_id":"server:1:2","name":"message-name","to_users":[2],"message":"hans"}`), 1)
+
+		if err != nil {
+			t.Errorf("Publish returned unexpected error: %v", err)
+		}
+	})
+
+	t.Run("big message", func(t *testing.T) {
+		defer backend.reset()
+
+		message := `{"channel_id":"server:1:2","name":"message-name","to_users":[2],"message":"` + strings.Repeat("a", 100) + `"}`
+		err := n.Publish(t.Context(), strings.NewReader(message), 1)
+
+		if !errors.Is(err, iccerror.ErrInvalid) {
+			t.Errorf("Publish returned err `%v`, expected `%s`", err, iccerror.ErrInvalid.Error())
+		}
+
+		if len(backend.receivedMessages) != 0 {
+			t.Errorf("big message was saved in the backend")
+		}
+	})
+}
+
+func TestRetain(t *testing.T) {
+	backend := newBackendStrub()
+	n, bg := notify.New(backend)
+	go bg(t.Context(), nil)
+
+	t.Run("retain without meeting", func(t *testing.T) {
+		defer backend.reset()
+
+		err := n.Publish(t.Context(), strings.NewReader(`{"channel_id":"server:1:2","name":"screen","to_users":[2],"retain":true,"message":"hans"}`), 1)
+
+		if !errors.Is(err, iccerror.ErrInvalid) {
+			t.Errorf("Publish returned err `%v`, expected `%s`", err, iccerror.ErrInvalid.Error())
+		}
+	})
+
+	t.Run("new receiver gets retained message", func(t *testing.T) {
+		defer backend.reset()
+
+		if err := n.Publish(t.Context(), strings.NewReader(`{"channel_id":"server:1:2","name":"screen","to_meeting":1,"retain":true,"message":"first"}`), 1); err != nil {
+			t.Fatalf("publish first message: %v", err)
+		}
+
+		if err := n.Publish(t.Context(), strings.NewReader(`{"channel_id":"server:1:2","name":"screen","to_meeting":1,"retain":true,"message":"second"}`), 1); err != nil {
+			t.Fatalf("publish second message: %v", err)
+		}
+
+		_, next := n.Receive(1, 2)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		message, err := next(ctx)
+		if err != nil {
+			t.Fatalf("next returned: %v", err)
+		}
+
+		if string(message.Message) != `"second"` {
+			t.Errorf("got message %s, expected \"second\"", message.Message)
+		}
+	})
+
+	t.Run("retained message is not returned twice", func(t *testing.T) {
+		defer backend.reset()
+
+		_, next := n.Receive(3, 2)
+
+		if err := n.Publish(t.Context(), strings.NewReader(`{"channel_id":"server:1:2","name":"screen","to_meeting":3,"retain":true,"message":"first"}`), 1); err != nil {
+			t.Fatalf("publish retained message: %v", err)
+		}
+
+		if err := n.Publish(t.Context(), strings.NewReader(`{"channel_id":"server:1:2","name":"other","to_meeting":3,"message":"second"}`), 1); err != nil {
+			t.Fatalf("publish message: %v", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		for _, expect := range []string{`"first"`, `"second"`} {
+			message, err := next(ctx)
+			if err != nil {
+				t.Fatalf("next returned: %v", err)
+			}
+
+			if string(message.Message) != expect {
+				t.Errorf("got message %s, expected %s", message.Message, expect)
+			}
+		}
+	})
+
+	t.Run("receiver in other meeting", func(t *testing.T) {
+		defer backend.reset()
+
+		if err := n.Publish(t.Context(), strings.NewReader(`{"channel_id":"server:1:2","name":"screen","to_meeting":1,"retain":true,"message":"first"}`), 1); err != nil {
+			t.Fatalf("publish message: %v", err)
+		}
+
+		_, next := n.Receive(2, 2)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		if _, err := next(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("next returned `%v`, expected a timeout", err)
+		}
+	})
+
+	t.Run("clear retained message", func(t *testing.T) {
+		defer backend.reset()
+
+		if err := n.Publish(t.Context(), strings.NewReader(`{"channel_id":"server:1:2","name":"screen","to_meeting":1,"retain":true,"message":"first"}`), 1); err != nil {
+			t.Fatalf("publish message: %v", err)
+		}
+
+		if err := n.Publish(t.Context(), strings.NewReader(`{"channel_id":"server:1:2","name":"screen","to_meeting":1,"retain":true,"message":null}`), 1); err != nil {
+			t.Fatalf("clear message: %v", err)
+		}
+
+		if len(backend.retained[1]) != 0 {
+			t.Errorf("backend has retained messages %v, expected none", backend.retained[1])
+		}
+	})
+}
+
+func TestScheduleAndExpire(t *testing.T) {
+	backend := newBackendStrub()
+	n, bg := notify.New(backend)
+	go bg(t.Context(), nil)
+
+	t.Run("scheduled message", func(t *testing.T) {
+		defer backend.reset()
+
+		deliverAt := time.Now().Add(time.Hour).Unix()
+		message := fmt.Sprintf(`{"channel_id":"server:1:2","name":"reminder","to_meeting":1,"deliver_at":%d,"message":"hans"}`, deliverAt)
+		if err := n.Publish(t.Context(), strings.NewReader(message), 1); err != nil {
+			t.Fatalf("Publish returned unexpected error: %v", err)
+		}
+
+		if len(backend.receivedMessages) != 0 {
+			t.Errorf("scheduled message was published")
+		}
+
+		if len(backend.scheduled[deliverAt]) != 1 {
+			t.Errorf("message was not scheduled")
+		}
+	})
+
+	t.Run("scheduled message in the past", func(t *testing.T) {
+		defer backend.reset()
+
+		if err := n.Publish(t.Context(), strings.NewReader(`{"channel_id":"server:1:2","name":"reminder","to_meeting":1,"deliver_at":1,"message":"hans"}`), 1); err != nil {
+			t.Fatalf("Publish returned unexpected error: %v", err)
+		}
+
+		if len(backend.receivedMessages) != 1 {
+			t.Errorf("message was not published")
+		}
+	})
+
+	t.Run("expired message", func(t *testing.T) {
+		defer backend.reset()
+
+		err := n.Publish(t.Context(), strings.NewReader(`{"channel_id":"server:1:2","name":"reminder","to_meeting":1,"expires_at":1,"message":"hans"}`), 1)
+
+		if !errors.Is(err, iccerror.ErrInvalid) {
+			t.Errorf("Publish returned err `%v`, expected `%s`", err, iccerror.ErrInvalid.Error())
+		}
+	})
+
+	t.Run("expires before delivery", func(t *testing.T) {
+		defer backend.reset()
+
+		deliverAt := time.Now().Add(time.Hour).Unix()
+		message := fmt.Sprintf(`{"channel_id":"server:1:2","name":"reminder","to_meeting":1,"deliver_at":%d,"expires_at":%d,"message":"hans"}`, deliverAt, deliverAt-1)
+		err := n.Publish(t.Context(), strings.NewReader(message), 1)
+
+		if !errors.Is(err, iccerror.ErrInvalid) {
+			t.Errorf("Publish returned err `%v`, expected `%s`", err, iccerror.ErrInvalid.Error())
+		}
+	})
+
+	t.Run("expired message is not delivered", func(t *testing.T) {
+		defer backend.reset()
+
+		_, next := n.Receive(1, 2)
+
+		backend.messages <- []byte(`{"channel_id":"server:1:2","name":"stale","to_meeting":1,"expires_at":1,"message":"hans"}`)
+		backend.messages <- []byte(`{"channel_id":"server:1:2","name":"fresh","to_meeting":1,"message":"hans"}`)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		message, err := next(ctx)
+		if err != nil {
+			t.Fatalf("next returned: %v", err)
+		}
+
+		if message.Name != "fresh" {
+			t.Errorf("got message %s, expected fresh", message.Name)
+		}
+	})
+}
+
+func TestMessageID(t *testing.T) {
+	backend := newBackendStrub()
+	n, bg := notify.New(backend)
+	go bg(t.Context(), nil)
+
+	t.Run("duplicate message is published once", func(t *testing.T) {
+		defer backend.reset()
+
+		message := `{"channel_id":"server:1:2","message_id":"abc","name":"message-name","to_users":[2],"message":"hans"}`
+		for range 2 {
+			if err := n.Publish(t.Context(), strings.NewReader(message), 1); err != nil {
+				t.Fatalf("Publish returned unexpected error: %v", err)
+			}
+		}
+
+		if len(backend.receivedMessages) != 1 {
+			t.Errorf("backend received %d messages, expected 1", len(backend.receivedMessages))
+		}
+	})
+
+	t.Run("message can be retried after a failed publish", func(t *testing.T) {
+		defer backend.reset()
+
+		message := `{"channel_id":"server:1:2","message_id":"abc","name":"message-name","to_users":[2],"message":"hans"}`
+
+		backend.publishErr = errors.New("backend down")
+		if err := n.Publish(t.Context(), strings.NewReader(message), 1); err == nil {
+			t.Fatalf("Publish did not return the error of the backend")
+		}
+
+		backend.publishErr = nil
+		if err := n.Publish(t.Context(), strings.NewReader(message), 1); err != nil {
+			t.Fatalf("Publish returned unexpected error: %v", err)
+		}
+
+		if len(backend.receivedMessages) != 1 {
+			t.Errorf("backend received %d messages, expected 1", len(backend.receivedMessages))
+		}
+	})
+
+	t.Run("same message id from other user", func(t *testing.T) {
+		defer backend.reset()
+
+		if err := n.Publish(t.Context(), strings.NewReader(`{"channel_id":"server:1:2","message_id":"abc","name":"message-name","to_users":[2],"message":"hans"}`), 1); err != nil {
+			t.Fatalf("Publish returned unexpected error: %v", err)
+		}
+
+		if err := n.Publish(t.Context(), strings.NewReader(`{"channel_id":"server:2:2","message_id":"abc","name":"message-name","to_users":[2],"message":"hans"}`), 2); err != nil {
+			t.Fatalf("Publish returned unexpected error: %v", err)
+		}
+
+		if len(backend.receivedMessages) != 2 {
+			t.Errorf("backend received %d messages, expected 2", len(backend.receivedMessages))
+		}
+	})
+
+	t.Run("message id is forwarded", func(t *testing.T) {
+		defer backend.reset()
+
+		_, next := n.Receive(1, 3)
+
+		if err := n.Publish(t.Context(), strings.NewReader(`{"channel_id":"server:1:2","message_id":"xyz","name":"message-name","to_users":[3],"message":"hans"}`), 1); err != nil {
+			t.Fatalf("Publish returned unexpected error: %v", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		message, err := next(ctx)
+		if err != nil {
+			t.Fatalf("next returned: %v", err)
+		}
+
+		if message.MessageID != "xyz" {
+			t.Errorf("got message id `%s`, expected `xyz`", message.MessageID)
+		}
+	})
+}
+
+func TestServerFields(t *testing.T) {
+	backend := newBackendStrub()
+	n, bg := notify.New(backend)
+	go bg(t.Context(), nil)
+
+	_, next := n.Receive(1, 4)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	message := `{"channel_id":"server:1:2","name":"message-name","to_users":[4],"message":"hans","sent_at":5,"sequence":"fake","instance":"fake","sender_name":"fake"}`
+	for range 2 {
+		if err := n.Publish(ctx, strings.NewReader(message), 1); err != nil {
+			t.Fatalf("Publish returned unexpected error: %v", err)
+		}
+	}
+
+	first, err := next(ctx)
+	if err != nil {
+		t.Fatalf("next returned: %v", err)
+	}
+
+	second, err := next(ctx)
+	if err != nil {
+		t.Fatalf("next returned: %v", err)
+	}
+
+	if first.Timestamp == 5 || first.Sequence == "fake" || first.Instance == "fake" || first.SenderName != "" {
+		t.Errorf("client values were not overwritten: %v", first)
+	}
+
+	if first.Sequence == second.Sequence {
+		t.Errorf("both messages have the sequence %s", first.Sequence)
+	}
+
+	if first.Instance != second.Instance {
+		t.Errorf("messages from the same instance have different instance values: %s and %s", first.Instance, second.Instance)
+	}
+}
+
+func TestPublishSystem(t *testing.T) {
+	backend := newBackendStrub()
+	n, bg := notify.New(backend)
+	go bg(t.Context(), nil)
+
+	_, next := n.Receive(1, 4)
+	_, nextOtherMeeting := n.Receive(2, 4)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	fake := `{"channel_id":"server:0:2","name":"fake","to_meeting":1,"message":"hans","system":true}`
+	if err := n.Publish(ctx, strings.NewReader(fake), 0); err != nil {
+		t.Fatalf("Publish returned unexpected error: %v", err)
+	}
+
+	if err := n.PublishSystem(1, "system-event", map[string]int{"level": 5}); err != nil {
+		t.Fatalf("PublishSystem returned unexpected error: %v", err)
+	}
+
+	message, err := next(ctx)
+	if err != nil {
+		t.Fatalf("next returned: %v", err)
+	}
+
+	if message.Name != "system-event" || !message.System || message.SenderUserID != 0 || string(message.Message) != `{"level":5}` {
+		t.Errorf("got message %v, expected the system message", message)
+	}
+
+	if _, err := nextOtherMeeting(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("receiver of another meeting got a message, err: %v", err)
+	}
+}
+
+func TestPoisonMessage(t *testing.T) {
+	backend := newBackendStrub()
+	n, bg := notify.New(backend)
+	go bg(t.Context(), nil)
+
+	_, next := n.Receive(1, 5)
+
+	backend.messages <- []byte(`malformed:entry`)
+	backend.messages <- []byte(`{invalid json`)
+	backend.messages <- []byte(`{"channel_id":"invalid","name":"message-name","to_users":[5],"message":"hans"}`)
+	backend.messages <- []byte(`{"channel_id":"server:1:2","name":"valid","to_users":[5],"message":"hans"}`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	message, err := next(ctx)
+	if err != nil {
+		t.Fatalf("next returned: %v", err)
+	}
+
+	if message.Name != "valid" {
+		t.Errorf("got message %s, expected valid", message.Name)
+	}
+
+	for _, expect := range []string{`malformed:entry`, `{invalid json`, `{"channel_id":"invalid","name":"message-name","to_users":[5],"message":"hans"}`} {
+		select {
+		case got := <-backend.deadLetters:
+			if got != expect {
+				t.Errorf("got dead letter `%s`, expected `%s`", got, expect)
+			}
+		case <-ctx.Done():
+			t.Fatalf("message `%s` was not saved as dead letter", expect)
+		}
+	}
+}
+
+func TestSlowConsumer(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		policy notify.SlowConsumerPolicy
+	}{
+		{"disconnect", notify.DisconnectSlowConsumer},
+		{"drop", notify.DropForSlowConsumer},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := newBackendStrub()
+			n, bg := notify.New(backend, notify.WithSlowConsumerPolicy(tt.policy, 2))
+			go bg(t.Context(), nil)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			_, slow := n.Receive(1, 5)
+			_, fast := n.Receive(1, 6)
+
+			// The fast receiver reads each message, before the next one is
+			// sent. Afterwards, the slow receiver is five messages behind.
+			for i := range 5 {
+				backend.messages <- fmt.Appendf(nil, `{"channel_id":"server:1:2","name":"message-%d","to_users":[5,6],"message":"hans"}`, i)
+				if _, err := fast(ctx); err != nil {
+					t.Fatalf("fast receiver returned: %v", err)
+				}
+			}
+
+			if tt.policy == notify.DisconnectSlowConsumer {
+				_, err := slow(ctx)
+				if !errors.Is(err, iccerror.ErrTooSlow) {
+					t.Fatalf("slow receiver returned `%v`, expected `%v`", err, iccerror.ErrTooSlow)
+				}
+				return
+			}
+
+			backend.messages <- []byte(`{"channel_id":"server:1:2","name":"new-message","to_users":[5,6],"message":"hans"}`)
+
+			message, err := slow(ctx)
+			if err != nil {
+				t.Fatalf("slow receiver returned: %v", err)
+			}
+
+			if message.Name != "new-message" {
+				t.Errorf("slow receiver got message %s, expected new-message", message.Name)
+			}
+		})
+	}
+}
+
+func TestSlowConsumerBlocked(t *testing.T) {
+	backend := newBackendStrub()
+	n, bg := notify.New(backend, notify.WithSlowConsumerPolicy(notify.DisconnectSlowConsumer, 2))
+	go bg(t.Context(), nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, blocked := n.Receive(1, 5)
+	_, other := n.Receive(1, 6)
+
+	backend.messages <- []byte(`{"channel_id":"server:1:2","name":"first","to_users":[5],"message":"hans"}`)
+	if _, err := blocked(ctx); err != nil {
+		t.Fatalf("blocked receiver returned: %v", err)
+	}
+
+	// The receiver does not read, while messages are published. Also the
+	// messages for other users count, since the receiver did not fetch them.
+	for i := range 5 {
+		backend.messages <- fmt.Appendf(nil, `{"channel_id":"server:1:2","name":"other-%d","to_users":[6],"message":"hans"}`, i)
+		if _, err := other(ctx); err != nil {
+			t.Fatalf("other receiver returned: %v", err)
+		}
+	}
+
+	_, err := blocked(ctx)
+	if !errors.Is(err, iccerror.ErrTooSlow) {
+		t.Fatalf("blocked receiver returned `%v`, expected `%v`", err, iccerror.ErrTooSlow)
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	keeper := channelKeeperStub{
+		alive:  make(chan string, 1),
+		closed: make(chan string, 1),
+	}
+	n, _ := notify.New(newBackendStrub(), notify.WithChannelKeeper(&keeper))
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		n.KeepAlive(ctx, "server:1:2")
+		close(done)
+	}()
+
+	select {
+	case cid := <-keeper.alive:
+		if cid != "server:1:2" {
+			t.Errorf("ChannelAlive was called with %s, expected server:1:2", cid)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("ChannelAlive was not called")
+	}
+
+	cancel()
+
+	select {
+	case cid := <-keeper.closed:
+		if cid != "server:1:2" {
+			t.Errorf("ChannelClosed was called with %s, expected server:1:2", cid)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("ChannelClosed was not called")
+	}
+
+	<-done
+}
+
+func TestChannelUserID(t *testing.T) {
+	n, _ := notify.New(newBackendStrub())
+	cid, _ := n.Receive(1, 5)
+
+	if got := notify.ChannelUserID(cid); got != 5 {
+		t.Errorf("ChannelUserID returned %d, expected 5", got)
+	}
+
+	if got := notify.ChannelUserID("invalid"); got != 0 {
+		t.Errorf("ChannelUserID returned %d for an invalid channel id, expected 0", got)
+	}
+}
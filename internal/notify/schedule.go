@@ -0,0 +1,36 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// scheduleInterval is the time between two checks for scheduled messages.
+const scheduleInterval = time.Second
+
+// schedule publishes the scheduled messages, when their delivery time is
+// reached.
+//
+// The scheduled messages are saved in the backend, so they survive a restart.
+// The backend makes sure, that each message is only published once, even when
+// there are many instances of the service.
+func (n *Notify) schedule(ctx context.Context, errHandler func(error)) {
+	if errHandler == nil {
+		errHandler = func(error) {}
+	}
+
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := n.backend.NotifyPublishDue(now.Unix()); err != nil {
+				errHandler(fmt.Errorf("publish scheduled messages: %w", err))
+			}
+		}
+	}
+}
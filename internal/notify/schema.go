@@ -0,0 +1,91 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// Schemas validates the content of notify messages by their name.
+//
+// Has to be created with LoadSchemas().
+type Schemas struct {
+	schemas       map[string]*gojsonschema.Schema
+	rejectUnknown bool
+}
+
+// LoadSchemas reads all json schema files from a directory.
+//
+// Each file with the extension `.json` is a schema for the notify messages with
+// the filename (without the extension) as name. For example, the file
+// `screen-share.json` is used for all messages with the name `screen-share`.
+//
+// If rejectUnknown is true, messages with a name that has no schema are
+// invalid.
+func LoadSchemas(dir string, rejectUnknown bool) (*Schemas, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("searching schema files: %w", err)
+	}
+
+	schemas := make(map[string]*gojsonschema.Schema, len(files))
+	for _, file := range files {
+		bs, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("reading schema file %s: %w", file, err)
+		}
+
+		schema, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(bs))
+		if err != nil {
+			return nil, fmt.Errorf("parsing schema file %s: %w", file, err)
+		}
+
+		name := strings.TrimSuffix(filepath.Base(file), ".json")
+		schemas[name] = schema
+	}
+
+	return &Schemas{
+		schemas:       schemas,
+		rejectUnknown: rejectUnknown,
+	}, nil
+}
+
+// Len returns the number of loaded schemas.
+func (s *Schemas) Len() int {
+	return len(s.schemas)
+}
+
+// validate checks the content of a message with the schema for its name.
+func (s *Schemas) validate(name string, message json.RawMessage) error {
+	schema, ok := s.schemas[name]
+	if !ok {
+		if s.rejectUnknown {
+			return iccerror.NewMessageError(iccerror.ErrInvalid, "unknown message name `%s`", name)
+		}
+		return nil
+	}
+
+	if len(message) == 0 {
+		message = json.RawMessage("null")
+	}
+
+	result, err := schema.Validate(gojsonschema.NewBytesLoader(message))
+	if err != nil {
+		return iccerror.NewMessageError(iccerror.ErrInvalid, "invalid message: %v", err)
+	}
+
+	if !result.Valid() {
+		problems := make([]string, len(result.Errors()))
+		for i, resultErr := range result.Errors() {
+			problems[i] = resultErr.String()
+		}
+		return iccerror.NewMessageError(iccerror.ErrInvalid, "message `%s` does not match its schema: %s", name, strings.Join(problems, "; "))
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/OpenSlides/openslides-go/datastore/dsfetch"
+	"github.com/OpenSlides/openslides-go/datastore/flow"
+)
+
+// senderDetails returns the meeting user id and the display name of a user.
+//
+// The meeting user id is only returned, if meetingID is not 0 and the user is
+// in this meeting.
+func senderDetails(ctx context.Context, getter flow.Getter, userID, meetingID int) (int, string, error) {
+	fetch := dsfetch.New(getter)
+
+	var firstName, lastName, username string
+	var meetingUserIDs []int
+	fetch.User_FirstName(userID).Lazy(&firstName)
+	fetch.User_LastName(userID).Lazy(&lastName)
+	fetch.User_Username(userID).Lazy(&username)
+	fetch.User_MeetingUserIDs(userID).Lazy(&meetingUserIDs)
+	if err := fetch.Execute(ctx); err != nil {
+		return 0, "", fmt.Errorf("fetching user %d: %w", userID, err)
+	}
+
+	name := strings.TrimSpace(firstName + " " + lastName)
+	if name == "" {
+		name = username
+	}
+
+	if meetingID == 0 {
+		return 0, name, nil
+	}
+
+	meetingIDs := make([]int, len(meetingUserIDs))
+	for i, meetingUserID := range meetingUserIDs {
+		fetch.MeetingUser_MeetingID(meetingUserID).Lazy(&meetingIDs[i])
+	}
+	if err := fetch.Execute(ctx); err != nil {
+		return 0, "", fmt.Errorf("fetching meeting users of user %d: %w", userID, err)
+	}
+
+	for i, id := range meetingIDs {
+		if id == meetingID {
+			return meetingUserIDs[i], name, nil
+		}
+	}
+
+	return 0, name, nil
+}
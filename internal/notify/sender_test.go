@@ -0,0 +1,49 @@
+package notify
+
+import (
+	"context"
+	"testing"
+
+	"github.com/OpenSlides/openslides-go/datastore/dsmock"
+)
+
+func TestSenderDetails(t *testing.T) {
+	ds := dsmock.Stub(dsmock.YAMLData(`---
+	user/1:
+		first_name: Max
+		last_name: Mustermann
+		username: max
+		meeting_user_ids: [10, 11]
+	user/2/username: erika
+	meeting_user/10/meeting_id: 5
+	meeting_user/11/meeting_id: 6
+	`))
+
+	for _, tt := range []struct {
+		name          string
+		userID        int
+		meetingID     int
+		meetingUserID int
+		displayName   string
+	}{
+		{"without meeting", 1, 0, 0, "Max Mustermann"},
+		{"with meeting", 1, 6, 11, "Max Mustermann"},
+		{"other meeting", 1, 7, 0, "Max Mustermann"},
+		{"only username", 2, 5, 0, "erika"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			meetingUserID, name, err := senderDetails(context.Background(), ds, tt.userID, tt.meetingID)
+			if err != nil {
+				t.Fatalf("senderDetails returned unexpected error: %v", err)
+			}
+
+			if meetingUserID != tt.meetingUserID {
+				t.Errorf("got meeting user id %d, expected %d", meetingUserID, tt.meetingUserID)
+			}
+
+			if name != tt.displayName {
+				t.Errorf("got name `%s`, expected `%s`", name, tt.displayName)
+			}
+		})
+	}
+}
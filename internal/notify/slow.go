@@ -0,0 +1,77 @@
+package notify
+
+import (
+	"fmt"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+	"github.com/OpenSlides/openslides-icc-service/internal/metric"
+)
+
+// SlowConsumerPolicy decides what happens with a receiver, that falls too far
+// behind.
+type SlowConsumerPolicy int
+
+const (
+	// DisconnectSlowConsumer closes the connection of a slow receiver after
+	// sending an error of the type iccerror.ErrTooSlow.
+	DisconnectSlowConsumer SlowConsumerPolicy = iota
+
+	// DropForSlowConsumer skips all messages, that a slow receiver has not
+	// read yet.
+	DropForSlowConsumer
+)
+
+// ParseSlowConsumerPolicy parses the name of a SlowConsumerPolicy. Valid
+// values are `disconnect` and `drop`.
+func ParseSlowConsumerPolicy(s string) (SlowConsumerPolicy, error) {
+	switch s {
+	case "disconnect":
+		return DisconnectSlowConsumer, nil
+	case "drop":
+		return DropForSlowConsumer, nil
+	default:
+		return 0, fmt.Errorf("unknown slow consumer policy `%s`", s)
+	}
+}
+
+var (
+	metricConsumerLag = metric.NewHistogram(
+		"icc_notify_consumer_lag_messages",
+		"Number of messages a notify receiver is behind, when it reads a message.",
+		[]float64{0, 1, 10, 100, 1000, 10000},
+	)
+
+	metricSlowConsumers = metric.NewCounter(
+		"icc_notify_slow_consumers_total",
+		"Number of times a notify receiver fell too far behind.",
+	)
+)
+
+// lag returns the number of messages in the topic, that the receiver did not
+// fetch yet.
+//
+// All messages are counted, also the messages for other receivers. A
+// receiver, that waits for new messages, fetches them as soon as they are
+// published, so it only falls behind, when it does not read.
+func (mp *messageProvider) lag() uint64 {
+	lastID := mp.topic.LastID()
+	if lastID < mp.tid {
+		return 0
+	}
+	return lastID - mp.tid
+}
+
+// tooSlow handles a receiver that fell too far behind.
+//
+// Returns an error, if the connection should be closed.
+func (mp *messageProvider) tooSlow() error {
+	metricSlowConsumers.Inc()
+
+	if mp.slowPolicy == DisconnectSlowConsumer {
+		return iccerror.NewMessageError(iccerror.ErrTooSlow, "You are receiving notify messages too slowly. Please reconnect.")
+	}
+
+	mp.tid = mp.topic.LastID()
+	mp.messageBuf = nil
+	return nil
+}
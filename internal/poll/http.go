@@ -0,0 +1,150 @@
+package poll
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+	"github.com/OpenSlides/openslides-icc-service/internal/icchttp"
+)
+
+// Opener opens polls.
+type Opener interface {
+	Open(ctx context.Context, meetingID, uid int, settings Settings) (QuickPoll, error)
+}
+
+// HandleOpen registers the icc/poll/open route.
+//
+// The body contains the settings of the poll. The response is the new poll.
+func HandleOpen(mux *http.ServeMux, poll Opener, auth icchttp.Authenticater) {
+	url := icchttp.Path + "/poll/open"
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		uid := auth.FromContext(r.Context())
+		if uid == 0 {
+			w.WriteHeader(401)
+			icchttp.ErrorNoStatus(w, iccerror.NewMessageError(iccerror.ErrNotAllowed, "Anonymous user can not open polls."))
+			return
+		}
+
+		meetingID, err := strconv.Atoi(r.URL.Query().Get("meeting_id"))
+		if err != nil {
+			icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrInvalid, "Query meeting_id has to be an int."))
+			return
+		}
+
+		var settings Settings
+		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrInvalid, "Body has to be a json object with the fields options and duration."))
+			return
+		}
+
+		newPoll, err := poll.Open(r.Context(), meetingID, uid, settings)
+		if err != nil {
+			icchttp.Error(w, fmt.Errorf("opening poll: %w", err))
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(newPoll); err != nil {
+			icchttp.ErrorNoStatus(w, fmt.Errorf("writing poll: %w", err))
+		}
+	})
+
+	mux.Handle(
+		url,
+		icchttp.AuthMiddleware(handler, auth),
+	)
+}
+
+// Voter saves votes.
+type Voter interface {
+	Vote(ctx context.Context, meetingID, uid int, pollID, option string) error
+}
+
+// HandleVote registers the icc/poll/vote route.
+func HandleVote(mux *http.ServeMux, poll Voter, auth icchttp.Authenticater) {
+	url := icchttp.Path + "/poll/vote"
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		uid := auth.FromContext(r.Context())
+		if uid == 0 {
+			w.WriteHeader(401)
+			icchttp.ErrorNoStatus(w, iccerror.NewMessageError(iccerror.ErrNotAllowed, "Anonymous user can not vote."))
+			return
+		}
+
+		meetingID, err := strconv.Atoi(r.URL.Query().Get("meeting_id"))
+		if err != nil {
+			icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrInvalid, "Query meeting_id has to be an int."))
+			return
+		}
+
+		pollID := r.URL.Query().Get("poll_id")
+		option := r.URL.Query().Get("option")
+		if pollID == "" || option == "" {
+			icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrInvalid, "Query poll_id and option are required."))
+			return
+		}
+
+		if err := poll.Vote(r.Context(), meetingID, uid, pollID, option); err != nil {
+			icchttp.Error(w, fmt.Errorf("saving vote: %w", err))
+			return
+		}
+	})
+
+	mux.Handle(
+		url,
+		icchttp.AuthMiddleware(handler, auth),
+	)
+}
+
+// Receiver gets poll messages.
+type Receiver interface {
+	Receive(ctx context.Context, tid uint64, meetingID int) (newTID uint64, msg MSG, err error)
+	CanReceive(ctx context.Context, meetingID, userID int) error
+}
+
+// HandleReceive registers the icc/poll route.
+//
+// The first message contains the current poll and the heartbeat interval. On
+// an idle stream, the last message is repeated with the field `heartbeat`
+// after the interval.
+func HandleReceive(mux *http.ServeMux, poll Receiver, auth icchttp.Authenticater, heartbeat time.Duration) {
+	url := icchttp.Path + "/poll"
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store, max-age=0")
+
+		meetingID, err := strconv.Atoi(r.URL.Query().Get("meeting_id"))
+		if err != nil {
+			icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrInvalid, "Query meeting_id has to be an int."))
+			return
+		}
+
+		heartbeat, err := icchttp.HeartbeatInterval(r, heartbeat)
+		if err != nil {
+			icchttp.Error(w, err)
+			return
+		}
+
+		if err := poll.CanReceive(r.Context(), meetingID, auth.FromContext(r.Context())); err != nil {
+			icchttp.Error(w, err)
+			return
+		}
+
+		icchttp.Stream(w, r, heartbeat, func(ctx context.Context, tid uint64, _ MSG) (uint64, MSG, error) {
+			return poll.Receive(ctx, tid, meetingID)
+		}, nil)
+	})
+
+	mux.Handle(
+		url,
+		icchttp.AuthMiddleware(handler, auth),
+	)
+}
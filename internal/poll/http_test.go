@@ -0,0 +1,89 @@
+package poll_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+	"github.com/OpenSlides/openslides-icc-service/internal/icctest"
+	"github.com/OpenSlides/openslides-icc-service/internal/poll"
+)
+
+func TestHandleOpen(t *testing.T) {
+	t.Run("Anonymous", func(t *testing.T) {
+		mux := http.NewServeMux()
+		poll.HandleOpen(mux, &openerStub{}, &icctest.AutherStub{})
+		resp := httptest.NewRecorder()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("POST", "/system/icc/poll/open?meeting_id=1", strings.NewReader(`{}`)))
+
+		if resp.Result().StatusCode != 401 {
+			t.Fatalf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+		}
+
+		if !strings.Contains(resp.Body.String(), iccerror.ErrNotAllowed.Type()) {
+			t.Errorf("handler returned message `%s`, expected to contain `%s`", resp.Body.String(), iccerror.ErrNotAllowed.Type())
+		}
+	})
+
+	t.Run("User", func(t *testing.T) {
+		opener := openerStub{}
+		mux := http.NewServeMux()
+		poll.HandleOpen(mux, &opener, &icctest.AutherStub{UserID: 1})
+		resp := httptest.NewRecorder()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("POST", "/system/icc/poll/open?meeting_id=1", strings.NewReader(`{"options":["yes","no"],"duration":30}`)))
+
+		if resp.Result().StatusCode != 200 {
+			t.Fatalf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+		}
+
+		if expect := (poll.Settings{Options: []string{"yes", "no"}, Duration: 30}); !reflect.DeepEqual(opener.calledSettings, expect) {
+			t.Errorf("opener was called with %v, expected %v", opener.calledSettings, expect)
+		}
+
+		if got, expect := resp.Body.String(), `{"id":"abc","options":["yes","no"],"closes_at":100}`+"\n"; got != expect {
+			t.Errorf("got body %s, expected %s", got, expect)
+		}
+	})
+
+	t.Run("Open poll", func(t *testing.T) {
+		opener := openerStub{err: iccerror.NewMessageError(iccerror.ErrConflict, "Meeting 1 already has an open poll.")}
+		mux := http.NewServeMux()
+		poll.HandleOpen(mux, &opener, &icctest.AutherStub{UserID: 1})
+		resp := httptest.NewRecorder()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("POST", "/system/icc/poll/open?meeting_id=1", strings.NewReader(`{}`)))
+
+		if resp.Result().StatusCode != 409 {
+			t.Errorf("handler returned status %s, expected 409", resp.Result().Status)
+		}
+	})
+}
+
+func TestHandleVote(t *testing.T) {
+	voter := voterStub{}
+	mux := http.NewServeMux()
+	poll.HandleVote(mux, &voter, &icctest.AutherStub{UserID: 1})
+
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, httptest.NewRequest("GET", "/system/icc/poll/vote?meeting_id=1&poll_id=abc&option=yes", nil))
+
+	if resp.Result().StatusCode != 200 {
+		t.Fatalf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+	}
+
+	if voter.calledPollID != "abc" || voter.calledOption != "yes" {
+		t.Errorf("voter was called with poll %s and option %s, expected abc and yes", voter.calledPollID, voter.calledOption)
+	}
+
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, httptest.NewRequest("GET", "/system/icc/poll/vote?meeting_id=1&poll_id=abc", nil))
+
+	if resp.Result().StatusCode != 400 {
+		t.Errorf("handler returned status %s without option, expected 400", resp.Result().Status)
+	}
+}
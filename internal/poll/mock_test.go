@@ -0,0 +1,125 @@
+package poll_test
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/poll"
+)
+
+type backendStub struct {
+	mu      sync.Mutex
+	polls   map[int][]byte
+	open    map[int]int64
+	counts  map[string]map[string]int
+	closed  map[string]bool
+	changed chan int
+}
+
+func newBackendStub() *backendStub {
+	return &backendStub{
+		polls:   make(map[int][]byte),
+		open:    make(map[int]int64),
+		counts:  make(map[string]map[string]int),
+		closed:  make(map[string]bool),
+		changed: make(chan int, 10),
+	}
+}
+
+func (b *backendStub) PollOpen(meetingID int, poll []byte, closesAt, until int64) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.open[meetingID] > time.Now().Unix() {
+		return false, nil
+	}
+
+	b.polls[meetingID] = poll
+	b.open[meetingID] = closesAt
+	b.changed <- meetingID
+	return true, nil
+}
+
+func (b *backendStub) PollCurrent(meetingID int) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.polls[meetingID], nil
+}
+
+func (b *backendStub) PollVote(meetingID int, pollID string, userID int, option string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.counts[pollID] == nil {
+		b.counts[pollID] = make(map[string]int)
+	}
+	b.counts[pollID][option]++
+	b.changed <- meetingID
+	return nil
+}
+
+func (b *backendStub) PollCounts(meetingID int, pollID string) (map[string]int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	counts := make(map[string]int)
+	for option, count := range b.counts[pollID] {
+		counts[option] = count
+	}
+	return counts, nil
+}
+
+func (b *backendStub) PollChanged(ctx context.Context) (int, error) {
+	select {
+	case meetingID := <-b.changed:
+		return meetingID, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+func (b *backendStub) PollClose(meetingID int, pollID string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed[pollID] {
+		return false, nil
+	}
+	b.closed[pollID] = true
+	return true, nil
+}
+
+type eventPublisherStub struct {
+	events chan string
+}
+
+func (e *eventPublisherStub) PublishSystem(meetingID int, name string, message any) error {
+	e.events <- name
+	return nil
+}
+
+type openerStub struct {
+	err            error
+	calledSettings poll.Settings
+}
+
+func (s *openerStub) Open(ctx context.Context, meetingID, uid int, settings poll.Settings) (poll.QuickPoll, error) {
+	s.calledSettings = settings
+	if s.err != nil {
+		return poll.QuickPoll{}, s.err
+	}
+	return poll.QuickPoll{ID: "abc", Options: settings.Options, ClosesAt: 100}, nil
+}
+
+type voterStub struct {
+	calledPollID string
+	calledOption string
+}
+
+func (s *voterStub) Vote(ctx context.Context, meetingID, uid int, pollID, option string) error {
+	s.calledPollID = pollID
+	s.calledOption = option
+	return nil
+}
@@ -0,0 +1,514 @@
+// Package poll lets managers of a meeting open quick polls.
+//
+// A quick poll is a temperature check like thumbs-up or thumbs-down. It is not
+// saved in the datastore. Each user can vote once. The receivers of a meeting
+// get the counts of the options while the poll is open and the final result,
+// when the poll closes after its duration.
+package poll
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/OpenSlides/openslides-go/datastore/dsfetch"
+	"github.com/OpenSlides/openslides-go/datastore/flow"
+	"github.com/OpenSlides/openslides-go/perm"
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+	"github.com/OpenSlides/openslides-icc-service/internal/icchttp"
+	"github.com/OpenSlides/openslides-icc-service/internal/meetingtopic"
+	"github.com/OpenSlides/openslides-icc-service/internal/ratelimit"
+	"github.com/ostcar/topic"
+)
+
+const (
+	// interval is the time between two calculations of the counts.
+	interval = time.Second
+
+	// pruneTime is the time after that messages are removed from the topic.
+	pruneTime = 10 * time.Minute
+
+	// maxOptions is the maximum number of options of a poll.
+	maxOptions = 10
+
+	// defaultDuration is the duration of a poll, that does not set its own
+	// duration.
+	defaultDuration = time.Minute
+
+	// maxDuration is the maximum duration of a poll.
+	maxDuration = time.Hour
+)
+
+// EventResult is the name of the event, that is sent to the event publisher,
+// when a poll closes.
+const EventResult = "quick-poll-result"
+
+// DefaultOptions are the options of a poll, that does not set its own options.
+var DefaultOptions = []string{"thumbs-up", "thumbs-down"}
+
+// validOption is the format of a poll option.
+var validOption = regexp.MustCompile(`^[a-z0-9-]{1,32}$`)
+
+// Backend stores the polls and their votes.
+type Backend interface {
+	// PollOpen saves the encoded poll of a meeting until `until`. It replaces
+	// an old poll of the meeting.
+	//
+	// The poll is open until `closesAt`. If the meeting already has an open
+	// poll, the new poll is not saved and false is returned. The check has to
+	// be atomic, since many instances can open a poll at the same time.
+	//
+	// All instances have to be notified about the new poll.
+	PollOpen(meetingID int, poll []byte, closesAt, until int64) (bool, error)
+
+	// PollCurrent returns the encoded poll of a meeting. Returns nil, if the
+	// meeting has no poll.
+	PollCurrent(meetingID int) ([]byte, error)
+
+	// PollVote saves the vote of a user.
+	//
+	// The function can be called many times. The implementation of the
+	// interface has to make sure, that only the first vote of a user in a
+	// poll is counted.
+	PollVote(meetingID int, pollID string, userID int, option string) error
+
+	// PollCounts returns the number of votes for each option of a poll.
+	PollCounts(meetingID int, pollID string) (map[string]int, error)
+
+	// PollChanged is a blocking function that returns the id of a meeting,
+	// where a poll was opened or got new votes.
+	//
+	// It does not have to return the meeting for each vote.
+	//
+	// It is expected, that only one goroutine is calling this function.
+	PollChanged(ctx context.Context) (meetingID int, err error)
+
+	// PollClose marks a poll as closed. It returns true for the first call
+	// of a poll.
+	//
+	// The function is called from many instances. Only one of them has to
+	// get true.
+	PollClose(meetingID int, pollID string) (bool, error)
+}
+
+// EventPublisher sends the results of closed polls to other services.
+type EventPublisher interface {
+	PublishSystem(meetingID int, name string, message any) error
+}
+
+// Poll holds the state of the service.
+type Poll struct {
+	backend   Backend
+	topic     *meetingtopic.Topic[MSG]
+	datastore flow.Getter
+	limiter   *ratelimit.Limiter
+	events    EventPublisher
+
+	// pollChanged gets the ids of meetings with a new poll or new votes.
+	pollChanged chan int
+}
+
+// Option configures the poll service.
+type Option func(*Poll)
+
+// WithRateLimit sets a rate limiter that is used for each vote.
+func WithRateLimit(l *ratelimit.Limiter) Option {
+	return func(p *Poll) {
+		p.limiter = l
+	}
+}
+
+// WithEventPublisher sets a publisher, that gets the results of all closed
+// polls.
+func WithEventPublisher(e EventPublisher) Option {
+	return func(p *Poll) {
+		p.events = e
+	}
+}
+
+// New returns an initialized state of the poll service.
+func New(b Backend, db flow.Getter, options ...Option) (*Poll, func(context.Context, func(error))) {
+	poll := Poll{
+		backend:     b,
+		topic:       meetingtopic.New[MSG](),
+		datastore:   db,
+		pollChanged: make(chan int),
+	}
+
+	for _, o := range options {
+		o(&poll)
+	}
+
+	background := func(ctx context.Context, errHandler func(error)) {
+		go poll.listen(ctx, errHandler)
+		go poll.loop(ctx, errHandler)
+		go poll.pruneOldData(ctx)
+	}
+
+	return &poll, background
+}
+
+// Settings are the values of a poll, that are set by the manager.
+//
+// Duration is the time in seconds, the poll is open. If it is 0, the poll is
+// open for one minute. If Options is empty, DefaultOptions are used.
+type Settings struct {
+	Options  []string `json:"options"`
+	Duration int      `json:"duration"`
+}
+
+// QuickPoll is an open or closed poll. ClosesAt is a unix time stamp.
+type QuickPoll struct {
+	ID       string   `json:"id"`
+	Options  []string `json:"options"`
+	ClosesAt int64    `json:"closes_at"`
+}
+
+// closed returns true, if the poll is closed at the given time.
+func (q QuickPoll) closed(now time.Time) bool {
+	return q.ClosesAt <= now.Unix()
+}
+
+// MSG contains the poll of a meeting and the counts of its options.
+//
+// Poll is nil, if the meeting has no poll. Closed is set on the final result.
+type MSG struct {
+	Poll   *QuickPoll     `json:"poll"`
+	Counts map[string]int `json:"counts"`
+	Closed bool           `json:"closed,omitempty"`
+
+	icchttp.StreamHeartbeat
+}
+
+// Open opens a new poll in a meeting.
+//
+// The user needs the permission to manage polls. A meeting can only have one
+// open poll.
+func (p *Poll) Open(ctx context.Context, meetingID, userID int, settings Settings) (QuickPoll, error) {
+	if len(settings.Options) == 0 {
+		settings.Options = DefaultOptions
+	}
+
+	if err := validateSettings(settings); err != nil {
+		return QuickPoll{}, err
+	}
+
+	if err := p.checkPermission(ctx, meetingID, userID, perm.PollCanManage); err != nil {
+		return QuickPoll{}, err
+	}
+
+	now := time.Now()
+	duration := defaultDuration
+	if settings.Duration > 0 {
+		duration = time.Duration(settings.Duration) * time.Second
+	}
+
+	poll := QuickPoll{
+		ID:       strconv.FormatUint(rand.Uint64(), 36),
+		Options:  settings.Options,
+		ClosesAt: now.Add(duration).Unix(),
+	}
+
+	b, err := json.Marshal(poll)
+	if err != nil {
+		return QuickPoll{}, fmt.Errorf("encoding poll: %w", err)
+	}
+
+	// The poll is kept after it closed, so receivers can get the result.
+	opened, err := p.backend.PollOpen(meetingID, b, poll.ClosesAt, now.Add(duration+pruneTime).Unix())
+	if err != nil {
+		return QuickPoll{}, fmt.Errorf("saving poll: %w", err)
+	}
+
+	if !opened {
+		return QuickPoll{}, iccerror.NewMessageError(iccerror.ErrConflict, "Meeting %d already has an open poll.", meetingID)
+	}
+	return poll, nil
+}
+
+func validateSettings(settings Settings) error {
+	if len(settings.Options) < 2 || len(settings.Options) > maxOptions {
+		return iccerror.NewMessageError(iccerror.ErrInvalid, "A poll needs between 2 and %d options.", maxOptions)
+	}
+
+	for i, option := range settings.Options {
+		if !validOption.MatchString(option) {
+			return iccerror.NewMessageError(iccerror.ErrInvalid, "Invalid option `%s`. Only lowercase letters, numbers and `-` are allowed.", option)
+		}
+
+		if slices.Contains(settings.Options[:i], option) {
+			return iccerror.NewMessageError(iccerror.ErrInvalid, "Option `%s` is used twice.", option)
+		}
+	}
+
+	if settings.Duration < 0 || time.Duration(settings.Duration)*time.Second > maxDuration {
+		return iccerror.NewMessageError(iccerror.ErrInvalid, "The duration has to be between 0 and %d seconds.", int(maxDuration.Seconds()))
+	}
+	return nil
+}
+
+// Vote saves the vote of a user for an option of the open poll of a meeting.
+//
+// Only the first vote of a user is counted.
+func (p *Poll) Vote(ctx context.Context, meetingID, userID int, pollID, option string) error {
+	if err := p.checkPermission(ctx, meetingID, userID, perm.MeetingCanSeeFrontpage); err != nil {
+		return err
+	}
+
+	current, err := p.current(meetingID)
+	if err != nil {
+		return fmt.Errorf("getting current poll: %w", err)
+	}
+
+	if current == nil || current.ID != pollID || current.closed(time.Now()) {
+		return iccerror.NewMessageError(iccerror.ErrInvalid, "Poll %s is not open in meeting %d.", pollID, meetingID)
+	}
+
+	if !slices.Contains(current.Options, option) {
+		return iccerror.NewMessageError(iccerror.ErrInvalid, "Option `%s` is not part of poll %s.", option, pollID)
+	}
+
+	if err := p.limiter.Allow("poll", userID, "", meetingID); err != nil {
+		return fmt.Errorf("rate limit: %w", err)
+	}
+
+	if err := p.backend.PollVote(meetingID, pollID, userID, option); err != nil {
+		return fmt.Errorf("saving vote in backend: %w", err)
+	}
+	return nil
+}
+
+// CanReceive returns an error, if the user can not receive polls.
+func (p *Poll) CanReceive(ctx context.Context, meetingID, userID int) error {
+	return p.checkPermission(ctx, meetingID, userID, perm.MeetingCanSeeFrontpage)
+}
+
+func (p *Poll) checkPermission(ctx context.Context, meetingID, userID int, permission perm.TPermission) error {
+	if userID == 0 {
+		return iccerror.NewMessageError(iccerror.ErrNotAllowed, "Anonymous is not allowed to use polls.")
+	}
+
+	perms, err := perm.New(ctx, dsfetch.New(p.datastore), userID, meetingID)
+	if err != nil {
+		return fmt.Errorf("getting permissions: %w", err)
+	}
+
+	if !perms.Has(permission) {
+		return iccerror.NewMessageError(iccerror.ErrNotAllowed, "You need the permission %s in meeting %d.", permission, meetingID)
+	}
+	return nil
+}
+
+// current returns the poll of a meeting from the backend. Returns nil, if the
+// meeting has no poll.
+func (p *Poll) current(meetingID int) (*QuickPoll, error) {
+	b, err := p.backend.PollCurrent(meetingID)
+	if err != nil {
+		return nil, fmt.Errorf("fetching poll from backend: %w", err)
+	}
+
+	if b == nil {
+		return nil, nil
+	}
+
+	var poll QuickPoll
+	if err := json.Unmarshal(b, &poll); err != nil {
+		return nil, fmt.Errorf("decoding poll: %w", err)
+	}
+	return &poll, nil
+}
+
+// toMSG returns the message with the poll of a meeting and its counts.
+func (p *Poll) toMSG(meetingID int, now time.Time) (MSG, error) {
+	poll, err := p.current(meetingID)
+	if err != nil {
+		return MSG{}, fmt.Errorf("getting current poll: %w", err)
+	}
+
+	if poll == nil {
+		return MSG{Counts: map[string]int{}}, nil
+	}
+
+	counts, err := p.backend.PollCounts(meetingID, poll.ID)
+	if err != nil {
+		return MSG{}, fmt.Errorf("fetching counts from backend: %w", err)
+	}
+
+	if counts == nil {
+		counts = map[string]int{}
+	}
+
+	return MSG{Poll: poll, Counts: counts, Closed: poll.closed(now)}, nil
+}
+
+// Receive returns the poll of a meeting.
+//
+// The first call with tid 0 returns the current poll. The next calls block
+// until the poll or its counts change.
+func (p *Poll) Receive(ctx context.Context, tid uint64, meetingID int) (newTID uint64, msg MSG, err error) {
+	if tid == 0 {
+		tid = p.topic.LastID()
+
+		msg, err := p.toMSG(meetingID, time.Now())
+		if err != nil {
+			return 0, MSG{}, fmt.Errorf("getting poll message: %w", err)
+		}
+		return tid, msg, nil
+	}
+
+	tid, messages, err := p.topic.Receive(ctx, tid, meetingID)
+	if err != nil {
+		var errUnknownID topic.UnknownIDError
+		if !errors.As(err, &errUnknownID) {
+			return 0, MSG{}, err
+		}
+
+		// Each message contains the full state, so the pruned messages can be
+		// skipped.
+		return p.Receive(ctx, 0, meetingID)
+	}
+
+	// Only the newest message of the meeting is relevant.
+	return tid, messages[len(messages)-1], nil
+}
+
+// listen waits for meetings with changed polls in the backend and sends them
+// to the loop.
+func (p *Poll) listen(ctx context.Context, errHandler func(error)) {
+	if errHandler == nil {
+		errHandler = func(error) {}
+	}
+
+	for {
+		meetingID, err := p.backend.PollChanged(ctx)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+
+			errHandler(fmt.Errorf("receiving poll changes from backend: %w", err))
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		select {
+		case p.pollChanged <- meetingID:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// loop calculates the counts of the open polls and saves them for the clients
+// to fetch.
+//
+// A meeting is calculated from the first change of its poll until the poll
+// closes. Meetings without an open poll cost nothing.
+func (p *Poll) loop(ctx context.Context, errHandler func(error)) {
+	if errHandler == nil {
+		errHandler = func(error) {}
+	}
+
+	// lastMSG contains the last message of each meeting with an open poll.
+	lastMSG := make(map[int]MSG)
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		var meetingIDs []int
+		select {
+		case <-ctx.Done():
+			return
+
+		case meetingID := <-p.pollChanged:
+			meetingIDs = []int{meetingID}
+
+		case <-tick.C:
+			meetingIDs = slices.Collect(maps.Keys(lastMSG))
+		}
+
+		now := time.Now()
+		message := make(map[int]MSG)
+		for _, meetingID := range meetingIDs {
+			msg, err := p.toMSG(meetingID, now)
+			if err != nil {
+				errHandler(fmt.Errorf("getting poll message: %w", err))
+				continue
+			}
+
+			last := lastMSG[meetingID]
+			lastMSG[meetingID] = msg
+
+			if msg.Poll == nil || msg.Closed {
+				delete(lastMSG, meetingID)
+			}
+
+			if msg.Closed {
+				if err := p.publishResult(meetingID, msg); err != nil {
+					errHandler(fmt.Errorf("publishing poll result: %w", err))
+				}
+			}
+
+			if last.Poll != nil && msg.Poll != nil && last.Poll.ID == msg.Poll.ID && last.Closed == msg.Closed && maps.Equal(last.Counts, msg.Counts) {
+				continue
+			}
+			message[meetingID] = msg
+		}
+
+		if len(message) == 0 {
+			continue
+		}
+
+		if err := p.topic.Publish(message); err != nil {
+			errHandler(err)
+		}
+	}
+}
+
+// publishResult sends the result of a closed poll to the event publisher.
+//
+// Each instance detects the closed poll. So the backend is used to send the
+// result only once.
+func (p *Poll) publishResult(meetingID int, msg MSG) error {
+	first, err := p.backend.PollClose(meetingID, msg.Poll.ID)
+	if err != nil {
+		return fmt.Errorf("closing poll in backend: %w", err)
+	}
+
+	if !first || p.events == nil {
+		return nil
+	}
+
+	result := struct {
+		ID     string         `json:"id"`
+		Counts map[string]int `json:"counts"`
+	}{msg.Poll.ID, msg.Counts}
+
+	if err := p.events.PublishSystem(meetingID, EventResult, result); err != nil {
+		return fmt.Errorf("publishing result: %w", err)
+	}
+	return nil
+}
+
+// pruneOldData removes old messages from the topic.
+func (p *Poll) pruneOldData(ctx context.Context) {
+	tick := time.NewTicker(time.Minute)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			p.topic.Prune(time.Now().Add(-pruneTime))
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package poll_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/OpenSlides/openslides-go/datastore/dsmock"
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+	"github.com/OpenSlides/openslides-icc-service/internal/poll"
+)
+
+func TestOpenInvalidSettings(t *testing.T) {
+	p, _ := poll.New(newBackendStub(), nil)
+
+	for _, tt := range []struct {
+		name     string
+		settings poll.Settings
+	}{
+		{"one option", poll.Settings{Options: []string{"yes"}}},
+		{"invalid option", poll.Settings{Options: []string{"Yes", "no"}}},
+		{"same option", poll.Settings{Options: []string{"yes", "yes"}}},
+		{"too many options", poll.Settings{Options: []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k"}}},
+		{"negative duration", poll.Settings{Duration: -1}},
+		{"too long", poll.Settings{Duration: 3601}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := p.Open(t.Context(), 1, 5, tt.settings)
+
+			if !errors.Is(err, iccerror.ErrInvalid) {
+				t.Errorf("Open returned `%v`, expected `%v`", err, iccerror.ErrInvalid)
+			}
+		})
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		permissions string
+		userID      int
+		call        func(ctx context.Context, p *poll.Poll, userID int) error
+		allowed     bool
+	}{
+		{
+			"open anonymous",
+			"poll.can_manage",
+			0,
+			func(ctx context.Context, p *poll.Poll, userID int) error {
+				_, err := p.Open(ctx, 1, userID, poll.Settings{})
+				return err
+			},
+			false,
+		},
+		{
+			"open",
+			"poll.can_manage",
+			5,
+			func(ctx context.Context, p *poll.Poll, userID int) error {
+				_, err := p.Open(ctx, 1, userID, poll.Settings{})
+				return err
+			},
+			true,
+		},
+		{
+			"open without permission",
+			"meeting.can_see_frontpage",
+			5,
+			func(ctx context.Context, p *poll.Poll, userID int) error {
+				_, err := p.Open(ctx, 1, userID, poll.Settings{})
+				return err
+			},
+			false,
+		},
+		{
+			"vote anonymous",
+			"meeting.can_see_frontpage",
+			0,
+			func(ctx context.Context, p *poll.Poll, userID int) error {
+				return p.Vote(ctx, 1, userID, "abc", "thumbs-up")
+			},
+			false,
+		},
+		{
+			"vote without permission",
+			"",
+			5,
+			func(ctx context.Context, p *poll.Poll, userID int) error {
+				return p.Vote(ctx, 1, userID, "abc", "thumbs-up")
+			},
+			false,
+		},
+		{
+			"receive",
+			"meeting.can_see_frontpage",
+			5,
+			func(ctx context.Context, p *poll.Poll, userID int) error {
+				return p.CanReceive(ctx, 1, userID)
+			},
+			true,
+		},
+		{
+			"receive without permission",
+			"",
+			5,
+			func(ctx context.Context, p *poll.Poll, userID int) error {
+				return p.CanReceive(ctx, 1, userID)
+			},
+			false,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := dsmock.Stub(dsmock.YAMLData(fmt.Sprintf(`---
+			user/5/meeting_user_ids: [50]
+			meeting_user/50:
+				meeting_id: 1
+				user_id: 5
+				group_ids: [13]
+			group/13/permissions: [%s]
+			meeting/1/admin_group_id: 1
+			`, tt.permissions)))
+			p, _ := poll.New(newBackendStub(), ds)
+
+			err := tt.call(t.Context(), p, tt.userID)
+
+			if tt.allowed {
+				if err != nil {
+					t.Errorf("got error `%v`, expected no error", err)
+				}
+				return
+			}
+
+			if !errors.Is(err, iccerror.ErrNotAllowed) {
+				t.Errorf("got error `%v`, expected `%v`", err, iccerror.ErrNotAllowed)
+			}
+		})
+	}
+}
+
+func TestOpenTwice(t *testing.T) {
+	ds := dsmock.Stub(dsmock.YAMLData(`---
+	user/5/meeting_user_ids: [50]
+	meeting_user/50:
+		meeting_id: 1
+		user_id: 5
+		group_ids: [13]
+	group/13/permissions: [poll.can_manage]
+	meeting/1/admin_group_id: 1
+	`))
+	p, _ := poll.New(newBackendStub(), ds)
+
+	if _, err := p.Open(t.Context(), 1, 5, poll.Settings{}); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	_, err := p.Open(t.Context(), 1, 5, poll.Settings{})
+
+	if !errors.Is(err, iccerror.ErrConflict) {
+		t.Errorf("Open of a second poll returned `%v`, expected `%v`", err, iccerror.ErrConflict)
+	}
+}
+
+func TestReceive(t *testing.T) {
+	backend := newBackendStub()
+	p, bg := poll.New(backend, nil)
+	go bg(t.Context(), nil)
+
+	tid, msg, err := p.Receive(t.Context(), 0, 1)
+	if err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+
+	if msg.Poll != nil {
+		t.Errorf("first message has poll %v, expected no poll", msg.Poll)
+	}
+
+	closesAt := time.Now().Add(time.Minute).Unix()
+	if _, err := backend.PollOpen(1, fmt.Appendf(nil, `{"id":"abc","options":["yes","no"],"closes_at":%d}`, closesAt), closesAt, 0); err != nil {
+		t.Fatalf("PollOpen: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), time.Second)
+	defer cancel()
+
+	tid, msg, err = p.Receive(ctx, tid, 1)
+	if err != nil {
+		t.Fatalf("Receive after open: %v", err)
+	}
+
+	expect := poll.QuickPoll{ID: "abc", Options: []string{"yes", "no"}, ClosesAt: closesAt}
+	if msg.Poll == nil || !reflect.DeepEqual(*msg.Poll, expect) {
+		t.Errorf("message after open has poll %v, expected %v", msg.Poll, expect)
+	}
+
+	if err := backend.PollVote(1, "abc", 5, "yes"); err != nil {
+		t.Fatalf("PollVote: %v", err)
+	}
+
+	_, msg, err = p.Receive(ctx, tid, 1)
+	if err != nil {
+		t.Fatalf("Receive after vote: %v", err)
+	}
+
+	if expect := map[string]int{"yes": 1}; !reflect.DeepEqual(msg.Counts, expect) {
+		t.Errorf("message after vote has counts %v, expected %v", msg.Counts, expect)
+	}
+}
+
+func TestResult(t *testing.T) {
+	backend := newBackendStub()
+	events := eventPublisherStub{events: make(chan string, 1)}
+	p, bg := poll.New(backend, nil, poll.WithEventPublisher(&events))
+	go bg(t.Context(), nil)
+
+	tid, _, err := p.Receive(t.Context(), 0, 1)
+	if err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+
+	closesAt := time.Now().Add(-time.Second).Unix()
+	if _, err := backend.PollOpen(1, fmt.Appendf(nil, `{"id":"abc","options":["yes","no"],"closes_at":%d}`, closesAt), closesAt, 0); err != nil {
+		t.Fatalf("PollOpen: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), time.Second)
+	defer cancel()
+
+	_, msg, err := p.Receive(ctx, tid, 1)
+	if err != nil {
+		t.Fatalf("Receive after close: %v", err)
+	}
+
+	if !msg.Closed {
+		t.Errorf("message of a closed poll is not closed")
+	}
+
+	select {
+	case name := <-events.events:
+		if name != poll.EventResult {
+			t.Errorf("got event %s, expected %s", name, poll.EventResult)
+		}
+	case <-ctx.Done():
+		t.Errorf("result was not published")
+	}
+}
@@ -0,0 +1,157 @@
+// Package ratelimit implements token bucket rate limits.
+//
+// The buckets are saved in the backend, so the limits are shared between all
+// instances of the service.
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+)
+
+// Backend stores the token buckets.
+type Backend interface {
+	// RateLimitTake takes one token from each bucket with the given keys.
+	//
+	// The bucket keys[i] is refilled with rates[i] tokens per second and can
+	// hold at most bursts[i] tokens. A new bucket is full.
+	//
+	// Returns 0, if the tokens were taken. If one of the buckets is empty, no
+	// token is taken and it returns the duration until all buckets have a
+	// token. The check has to be atomic for all buckets.
+	RateLimitTake(keys []string, rates []float64, bursts []int) (time.Duration, error)
+}
+
+// Limit is the configuration of one token bucket.
+//
+// The zero value means no limit.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// ParseLimit parses a limit in the form `<requests>/<duration>`, for example
+// `20/10s`.
+//
+// The number of requests is used as burst. The bucket is refilled so that the
+// given number of requests are possible in the given duration. An empty string
+// or `0` disables the limit.
+func ParseLimit(s string) (Limit, error) {
+	if s == "" || s == "0" {
+		return Limit{}, nil
+	}
+
+	requestsStr, durationStr, found := strings.Cut(s, "/")
+	if !found {
+		return Limit{}, fmt.Errorf("invalid limit `%s`, expected `<requests>/<duration>`", s)
+	}
+
+	requests, err := strconv.Atoi(requestsStr)
+	if err != nil || requests <= 0 {
+		return Limit{}, fmt.Errorf("invalid number of requests `%s`", requestsStr)
+	}
+
+	duration, err := time.ParseDuration(durationStr)
+	if err != nil || duration <= 0 {
+		return Limit{}, fmt.Errorf("invalid duration `%s`", durationStr)
+	}
+
+	return Limit{
+		Rate:  float64(requests) / duration.Seconds(),
+		Burst: requests,
+	}, nil
+}
+
+func (l Limit) disabled() bool {
+	return l.Rate <= 0 || l.Burst <= 0
+}
+
+// Limiter checks the rate limits per user, per channel and per meeting.
+//
+// A nil Limiter allows everything.
+type Limiter struct {
+	backend Backend
+	user    Limit
+	channel Limit
+	meeting Limit
+}
+
+// New initializes a Limiter.
+func New(backend Backend, user, channel, meeting Limit) *Limiter {
+	return &Limiter{
+		backend: backend,
+		user:    user,
+		channel: channel,
+		meeting: meeting,
+	}
+}
+
+// WithMeetingLimit returns a copy of the Limiter with another limit per
+// meeting.
+func (l *Limiter) WithMeetingLimit(meeting Limit) *Limiter {
+	if l == nil {
+		return nil
+	}
+
+	limiter := *l
+	limiter.meeting = meeting
+	return &limiter
+}
+
+// Allow takes a token from the buckets of the user, the channel and the
+// meeting.
+//
+// The service is part of the bucket key, so each service has its own buckets.
+// The userID, channelID or meetingID can be the zero value to skip the
+// respective bucket.
+//
+// The tokens are only taken, if all buckets have one. Returns an
+// iccerror.RateLimitError, if one of the buckets is empty.
+func (l *Limiter) Allow(service string, userID int, channelID string, meetingID int) error {
+	if l == nil {
+		return nil
+	}
+
+	var keys []string
+	var rates []float64
+	var bursts []int
+	add := func(key string, limit Limit) {
+		if limit.disabled() {
+			return
+		}
+		keys = append(keys, key)
+		rates = append(rates, limit.Rate)
+		bursts = append(bursts, limit.Burst)
+	}
+
+	if userID != 0 {
+		add(fmt.Sprintf("%s:user:%d", service, userID), l.user)
+	}
+
+	if channelID != "" {
+		add(fmt.Sprintf("%s:channel:%s", service, channelID), l.channel)
+	}
+
+	if meetingID != 0 {
+		add(fmt.Sprintf("%s:meeting:%d", service, meetingID), l.meeting)
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	retryAfter, err := l.backend.RateLimitTake(keys, rates, bursts)
+	if err != nil {
+		return fmt.Errorf("checking rate limits %v: %w", keys, err)
+	}
+
+	if retryAfter > 0 {
+		return iccerror.NewRateLimitError(retryAfter)
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package ratelimit_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+	"github.com/OpenSlides/openslides-icc-service/internal/ratelimit"
+)
+
+type backendStub struct {
+	tokens map[string]int
+}
+
+func (b *backendStub) RateLimitTake(keys []string, rates []float64, bursts []int) (time.Duration, error) {
+	if b.tokens == nil {
+		b.tokens = make(map[string]int)
+	}
+
+	for i, key := range keys {
+		if b.tokens[key] >= bursts[i] {
+			return time.Second, nil
+		}
+	}
+
+	for _, key := range keys {
+		b.tokens[key]++
+	}
+	return 0, nil
+}
+
+func TestParseLimit(t *testing.T) {
+	for _, tt := range []struct {
+		value  string
+		expect ratelimit.Limit
+		err    bool
+	}{
+		{"", ratelimit.Limit{}, false},
+		{"0", ratelimit.Limit{}, false},
+		{"20/10s", ratelimit.Limit{Rate: 2, Burst: 20}, false},
+		{"5/1m", ratelimit.Limit{Rate: 5.0 / 60, Burst: 5}, false},
+		{"20", ratelimit.Limit{}, true},
+		{"x/10s", ratelimit.Limit{}, true},
+		{"20/x", ratelimit.Limit{}, true},
+		{"-1/10s", ratelimit.Limit{}, true},
+	} {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ratelimit.ParseLimit(tt.value)
+
+			if tt.err {
+				if err == nil {
+					t.Errorf("ParseLimit returned no error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ParseLimit returned unexpected error: %v", err)
+			}
+
+			if got != tt.expect {
+				t.Errorf("ParseLimit returned %v, expected %v", got, tt.expect)
+			}
+		})
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	t.Run("nil limiter", func(t *testing.T) {
+		var limiter *ratelimit.Limiter
+
+		if err := limiter.Allow("notify", 1, "cid", 1); err != nil {
+			t.Errorf("Allow returned unexpected error: %v", err)
+		}
+	})
+
+	t.Run("user limit", func(t *testing.T) {
+		limiter := ratelimit.New(new(backendStub), ratelimit.Limit{Rate: 1, Burst: 2}, ratelimit.Limit{}, ratelimit.Limit{})
+
+		for i := range 2 {
+			if err := limiter.Allow("notify", 1, "cid", 1); err != nil {
+				t.Fatalf("Allow %d returned unexpected error: %v", i, err)
+			}
+		}
+
+		err := limiter.Allow("notify", 1, "cid", 1)
+
+		if !errors.Is(err, iccerror.ErrTooManyRequests) {
+			t.Errorf("Allow returned `%v`, expected `%v`", err, iccerror.ErrTooManyRequests)
+		}
+
+		var errRateLimit iccerror.RateLimitError
+		if !errors.As(err, &errRateLimit) || errRateLimit.RetryAfter() != time.Second {
+			t.Errorf("Allow returned `%v`, expected a retry after one second", err)
+		}
+
+		if err := limiter.Allow("notify", 2, "cid", 1); err != nil {
+			t.Errorf("Allow for other user returned unexpected error: %v", err)
+		}
+
+		if err := limiter.Allow("applause", 1, "", 1); err != nil {
+			t.Errorf("Allow for other service returned unexpected error: %v", err)
+		}
+	})
+
+	t.Run("meeting limit", func(t *testing.T) {
+		limiter := ratelimit.New(new(backendStub), ratelimit.Limit{}, ratelimit.Limit{}, ratelimit.Limit{Rate: 1, Burst: 1})
+
+		if err := limiter.Allow("notify", 1, "", 1); err != nil {
+			t.Fatalf("Allow returned unexpected error: %v", err)
+		}
+
+		if err := limiter.Allow("notify", 2, "", 1); !errors.Is(err, iccerror.ErrTooManyRequests) {
+			t.Errorf("Allow returned `%v`, expected `%v`", err, iccerror.ErrTooManyRequests)
+		}
+
+		if err := limiter.Allow("notify", 2, "", 0); err != nil {
+			t.Errorf("Allow without meeting returned unexpected error: %v", err)
+		}
+	})
+
+	t.Run("rejected request takes no tokens", func(t *testing.T) {
+		limiter := ratelimit.New(new(backendStub), ratelimit.Limit{Rate: 1, Burst: 2}, ratelimit.Limit{}, ratelimit.Limit{Rate: 1, Burst: 1})
+
+		if err := limiter.Allow("notify", 1, "", 1); err != nil {
+			t.Fatalf("Allow returned unexpected error: %v", err)
+		}
+
+		if err := limiter.Allow("notify", 1, "", 1); !errors.Is(err, iccerror.ErrTooManyRequests) {
+			t.Fatalf("Allow in full meeting returned `%v`, expected `%v`", err, iccerror.ErrTooManyRequests)
+		}
+
+		if err := limiter.Allow("notify", 1, "", 2); err != nil {
+			t.Errorf("Allow in other meeting returned unexpected error: %v", err)
+		}
+	})
+}
@@ -0,0 +1,157 @@
+package reaction
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+	"github.com/OpenSlides/openslides-icc-service/internal/icchttp"
+)
+
+// Sender saves a reaction.
+type Sender interface {
+	Send(ctx context.Context, meetingID, uid int, reactionType string) error
+}
+
+// HandleSend registers the icc/reaction/send route.
+func HandleSend(mux *http.ServeMux, reaction Sender, auth icchttp.Authenticater) {
+	url := icchttp.Path + "/reaction/send"
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		uid := auth.FromContext(r.Context())
+		if uid == 0 {
+			w.WriteHeader(401)
+			icchttp.ErrorNoStatus(w, iccerror.NewMessageError(iccerror.ErrNotAllowed, "Anonymous user can not send reactions."))
+			return
+		}
+
+		meetingID, err := strconv.Atoi(r.URL.Query().Get("meeting_id"))
+		if err != nil {
+			icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrInvalid, "Query meeting_id has to be an int."))
+			return
+		}
+
+		reactionType := r.URL.Query().Get("type")
+		if reactionType == "" {
+			icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrInvalid, "Query type is required."))
+			return
+		}
+
+		if err := reaction.Send(r.Context(), meetingID, uid, reactionType); err != nil {
+			icchttp.Error(w, fmt.Errorf("saving reaction: %w", err))
+			return
+		}
+	})
+
+	mux.Handle(
+		url,
+		icchttp.AuthMiddleware(handler, auth),
+	)
+}
+
+// TypeSetter sets the reaction types of a meeting.
+type TypeSetter interface {
+	SetTypes(ctx context.Context, meetingID, uid int, types []string) error
+}
+
+// HandleSetTypes registers the icc/reaction/types route.
+func HandleSetTypes(mux *http.ServeMux, reaction TypeSetter, auth icchttp.Authenticater) {
+	url := icchttp.Path + "/reaction/types"
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		uid := auth.FromContext(r.Context())
+		if uid == 0 {
+			w.WriteHeader(401)
+			icchttp.ErrorNoStatus(w, iccerror.NewMessageError(iccerror.ErrNotAllowed, "Anonymous user can not set reaction types."))
+			return
+		}
+
+		meetingID, err := strconv.Atoi(r.URL.Query().Get("meeting_id"))
+		if err != nil {
+			icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrInvalid, "Query meeting_id has to be an int."))
+			return
+		}
+
+		var body struct {
+			Types []string `json:"types"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrInvalid, "Body has to be a json object with the field types."))
+			return
+		}
+
+		if err := reaction.SetTypes(r.Context(), meetingID, uid, body.Types); err != nil {
+			icchttp.Error(w, fmt.Errorf("setting reaction types: %w", err))
+			return
+		}
+	})
+
+	mux.Handle(
+		url,
+		icchttp.AuthMiddleware(handler, auth),
+	)
+}
+
+// Receiver gets reaction messages.
+type Receiver interface {
+	Receive(ctx context.Context, tid uint64, meetingID int) (newTID uint64, msg MSG, err error)
+	CanReceive(ctx context.Context, meetingID, userID int) error
+}
+
+// HandleReceive registers the icc/reaction route.
+//
+// The first message contains the heartbeat interval. On an idle stream, the
+// last message is repeated with the field `heartbeat` after the interval.
+func HandleReceive(mux *http.ServeMux, reaction Receiver, auth icchttp.Authenticater, heartbeat time.Duration) {
+	url := icchttp.Path + "/reaction"
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store, max-age=0")
+
+		meetingID, err := strconv.Atoi(r.URL.Query().Get("meeting_id"))
+		if err != nil {
+			icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrInvalid, "Query meeting_id has to be an int."))
+			return
+		}
+
+		heartbeat, err := icchttp.HeartbeatInterval(r, heartbeat)
+		if err != nil {
+			icchttp.Error(w, err)
+			return
+		}
+
+		if err := reaction.CanReceive(r.Context(), meetingID, auth.FromContext(r.Context())); err != nil {
+			icchttp.Error(w, err)
+			return
+		}
+
+		receive := func(ctx context.Context, tid uint64, last MSG) (uint64, MSG, error) {
+			tid, message, err := reaction.Receive(ctx, tid, meetingID)
+
+			// Messages that only change the types keep the last counts.
+			if err == nil && message.Counts == nil {
+				message.Counts = last.Counts
+			}
+			return tid, message, err
+		}
+
+		// The heartbeat only repeats the counts.
+		idle := func(last MSG) MSG {
+			last.Types = nil
+			return last
+		}
+
+		icchttp.Stream(w, r, heartbeat, receive, idle)
+	})
+
+	mux.Handle(
+		url,
+		icchttp.AuthMiddleware(handler, auth),
+	)
+}
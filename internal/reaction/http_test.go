@@ -0,0 +1,143 @@
+package reaction_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+	"github.com/OpenSlides/openslides-icc-service/internal/icctest"
+	"github.com/OpenSlides/openslides-icc-service/internal/reaction"
+)
+
+func TestHandleSend(t *testing.T) {
+	url := "/system/icc/reaction/send?meeting_id=1&type=laugh"
+
+	t.Run("Anonymous", func(t *testing.T) {
+		auther := icctest.AutherStub{}
+		sender := senderStub{}
+		mux := http.NewServeMux()
+		reaction.HandleSend(mux, &sender, &auther)
+		resp := httptest.NewRecorder()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("GET", url, nil))
+
+		if resp.Result().StatusCode != 401 {
+			t.Fatalf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+		}
+
+		if !strings.Contains(resp.Body.String(), iccerror.ErrNotAllowed.Type()) {
+			t.Errorf("handler returned message `%s`, expected to contain `%s`", resp.Body.String(), iccerror.ErrNotAllowed.Type())
+		}
+
+		if sender.called {
+			t.Errorf("handler did call the sender")
+		}
+	})
+
+	t.Run("User", func(t *testing.T) {
+		auther := icctest.AutherStub{
+			UserID: 1,
+		}
+		sender := senderStub{}
+		mux := http.NewServeMux()
+		reaction.HandleSend(mux, &sender, &auther)
+		resp := httptest.NewRecorder()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("GET", url, nil))
+
+		if resp.Result().StatusCode != 200 {
+			t.Fatalf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+		}
+
+		if sender.calledUserID != 1 || sender.calledMeetingID != 1 || sender.calledReactionType != "laugh" {
+			t.Errorf("sender was called with user %d, meeting %d and type %s, expected 1, 1 and laugh", sender.calledUserID, sender.calledMeetingID, sender.calledReactionType)
+		}
+	})
+
+	t.Run("No type", func(t *testing.T) {
+		auther := icctest.AutherStub{
+			UserID: 1,
+		}
+		sender := senderStub{}
+		mux := http.NewServeMux()
+		reaction.HandleSend(mux, &sender, &auther)
+		resp := httptest.NewRecorder()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("GET", "/system/icc/reaction/send?meeting_id=1", nil))
+
+		if resp.Result().StatusCode != 400 {
+			t.Fatalf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+		}
+
+		if sender.called {
+			t.Errorf("handler did call the sender")
+		}
+	})
+
+	t.Run("Internal error", func(t *testing.T) {
+		myError := errors.New("Test error")
+		sender := senderStub{
+			expectedErr: myError,
+		}
+		auther := icctest.AutherStub{
+			UserID: 1,
+		}
+		mux := http.NewServeMux()
+		reaction.HandleSend(mux, &sender, &auther)
+		resp := httptest.NewRecorder()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("GET", url, nil))
+
+		if resp.Result().StatusCode != 500 {
+			t.Fatalf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+		}
+
+		if strings.Contains(resp.Body.String(), myError.Error()) {
+			t.Errorf("handler returned the error message: %s", resp.Body.String())
+		}
+	})
+}
+
+func TestHandleSetTypes(t *testing.T) {
+	url := "/system/icc/reaction/types?meeting_id=1"
+
+	t.Run("Valid", func(t *testing.T) {
+		auther := icctest.AutherStub{
+			UserID: 1,
+		}
+		setter := typeSetterStub{}
+		mux := http.NewServeMux()
+		reaction.HandleSetTypes(mux, &setter, &auther)
+		resp := httptest.NewRecorder()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("POST", url, strings.NewReader(`{"types":["laugh","heart"]}`)))
+
+		if resp.Result().StatusCode != 200 {
+			t.Fatalf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+		}
+
+		if !reflect.DeepEqual(setter.calledTypes, []string{"laugh", "heart"}) {
+			t.Errorf("setter was called with %v, expected [laugh heart]", setter.calledTypes)
+		}
+	})
+
+	t.Run("Invalid body", func(t *testing.T) {
+		auther := icctest.AutherStub{
+			UserID: 1,
+		}
+		setter := typeSetterStub{}
+		mux := http.NewServeMux()
+		reaction.HandleSetTypes(mux, &setter, &auther)
+		resp := httptest.NewRecorder()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("POST", url, strings.NewReader(`["laugh"]`)))
+
+		if resp.Result().StatusCode != 400 {
+			t.Fatalf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+		}
+	})
+}
@@ -0,0 +1,100 @@
+package reaction_test
+
+import (
+	"context"
+	"sync"
+)
+
+type senderStub struct {
+	expectedErr        error
+	called             bool
+	calledUserID       int
+	calledMeetingID    int
+	calledReactionType string
+}
+
+func (s *senderStub) Send(ctx context.Context, meetingID, uid int, reactionType string) error {
+	s.called = true
+	s.calledUserID = uid
+	s.calledMeetingID = meetingID
+	s.calledReactionType = reactionType
+	return s.expectedErr
+}
+
+type typeSetterStub struct {
+	expectedErr error
+	calledTypes []string
+}
+
+func (s *typeSetterStub) SetTypes(ctx context.Context, meetingID, uid int, types []string) error {
+	s.calledTypes = types
+	return s.expectedErr
+}
+
+type backendStub struct {
+	mu           sync.Mutex
+	counts       map[int]map[string]int
+	changed      chan int
+	types        map[int][]string
+	typesChanged chan int
+}
+
+func (b *backendStub) ReactionPublish(meetingID, userID int, reactionType string, time int64) error {
+	return nil
+}
+
+func (b *backendStub) ReactionChanged(ctx context.Context) (int, error) {
+	select {
+	case meetingID := <-b.changed:
+		return meetingID, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+func (b *backendStub) ReactionCount(meetingID int, time int64) (map[string]int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.counts[meetingID], nil
+}
+
+func (b *backendStub) ReactionTypes(meetingID int) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.types[meetingID], nil
+}
+
+func (b *backendStub) ReactionSetTypes(meetingID int, types []string) error {
+	b.mu.Lock()
+	if b.types == nil {
+		b.types = make(map[int][]string)
+	}
+	b.types[meetingID] = types
+	b.mu.Unlock()
+
+	select {
+	case b.typesChanged <- meetingID:
+	default:
+	}
+	return nil
+}
+
+func (b *backendStub) ReactionTypesChanged(ctx context.Context) (int, error) {
+	select {
+	case meetingID := <-b.typesChanged:
+		return meetingID, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// setCounts sets the counts and reports the meetings as changed.
+func (b *backendStub) setCounts(counts map[int]map[string]int) {
+	b.mu.Lock()
+	b.counts = counts
+	b.mu.Unlock()
+
+	for meetingID := range counts {
+		b.changed <- meetingID
+	}
+}
@@ -0,0 +1,431 @@
+// Package reaction lets users react to a meeting with different reaction
+// types like applause, laugh or heart.
+//
+// It is a generalization of the applause service. For each meeting, the
+// receivers get the number of users that used each reaction type in the last
+// seconds.
+package reaction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/OpenSlides/openslides-go/datastore/dsfetch"
+	"github.com/OpenSlides/openslides-go/datastore/flow"
+	"github.com/OpenSlides/openslides-go/perm"
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+	"github.com/OpenSlides/openslides-icc-service/internal/icchttp"
+	"github.com/OpenSlides/openslides-icc-service/internal/meetingtopic"
+	"github.com/OpenSlides/openslides-icc-service/internal/ratelimit"
+	"github.com/ostcar/topic"
+)
+
+const (
+	// interval is the time between two calculations of the reaction counts.
+	interval = time.Second
+
+	// window is the time, a reaction is counted.
+	window = 5 * time.Second
+
+	// pruneTime is the time after that messages are removed from the topic.
+	pruneTime = 10 * time.Minute
+
+	// maxTypes is the maximum number of reaction types in a meeting.
+	maxTypes = 20
+)
+
+// DefaultTypes are the reaction types of a meeting, that has no own types.
+var DefaultTypes = []string{"applause", "laugh", "heart", "question"}
+
+// validType is the format of a reaction type.
+var validType = regexp.MustCompile(`^[a-z0-9-]{1,32}$`)
+
+// Backend stores the reactions.
+type Backend interface {
+	// ReactionPublish adds the reaction of a user to a meeting.
+	//
+	// The function can be called many times. The implementation of the
+	// interface has to make sure, that each reaction type of a user is only
+	// counted once. It also has to remove old reactions of the meeting.
+	ReactionPublish(meetingID, userID int, reactionType string, time int64) error
+
+	// ReactionChanged is a blocking function that returns the id of a meeting,
+	// that got new reactions.
+	//
+	// It does not have to return a meeting for each reaction, but it has to
+	// return it at least once in the reaction window.
+	ReactionChanged(ctx context.Context) (meetingID int, err error)
+
+	// ReactionCount returns the number of reactions of a meeting for each
+	// reaction type since `time`.
+	ReactionCount(meetingID int, time int64) (map[string]int, error)
+
+	// ReactionTypes returns the reaction types of a meeting. Returns nil, if
+	// the meeting has no own types.
+	ReactionTypes(meetingID int) ([]string, error)
+
+	// ReactionSetTypes sets the reaction types of a meeting. An empty list
+	// removes the own types of the meeting.
+	//
+	// The change has to be reported by ReactionTypesChanged on all instances.
+	ReactionSetTypes(meetingID int, types []string) error
+
+	// ReactionTypesChanged is a blocking function that returns the id of a
+	// meeting, where the reaction types changed.
+	ReactionTypesChanged(ctx context.Context) (meetingID int, err error)
+}
+
+// Reaction holds the state of the service.
+type Reaction struct {
+	backend      Backend
+	topic        *meetingtopic.Topic[MSG]
+	datastore    flow.Getter
+	limiter      *ratelimit.Limiter
+	defaultTypes []string
+
+	reactionChanged chan int
+}
+
+// Option configures the reaction service.
+type Option func(*Reaction)
+
+// WithRateLimit sets a rate limiter that is used for each reaction.
+func WithRateLimit(l *ratelimit.Limiter) Option {
+	return func(r *Reaction) {
+		r.limiter = l
+	}
+}
+
+// WithDefaultTypes sets the reaction types of meetings, that have no own
+// types. The default is DefaultTypes.
+func WithDefaultTypes(types []string) Option {
+	return func(r *Reaction) {
+		r.defaultTypes = types
+	}
+}
+
+// New returns an initialized state of the reaction service.
+func New(b Backend, db flow.Getter, options ...Option) (*Reaction, func(context.Context, func(error))) {
+	reaction := Reaction{
+		backend:      b,
+		topic:        meetingtopic.New[MSG](),
+		datastore:    db,
+		defaultTypes: DefaultTypes,
+
+		reactionChanged: make(chan int),
+	}
+
+	for _, o := range options {
+		o(&reaction)
+	}
+
+	background := func(ctx context.Context, errHandler func(error)) {
+		go reaction.listen(ctx, errHandler)
+		go reaction.loop(ctx, errHandler)
+		go reaction.listenTypes(ctx, errHandler)
+		go reaction.pruneOldData(ctx)
+	}
+
+	return &reaction, background
+}
+
+// ValidateTypes returns an error, if the list of reaction types is invalid.
+func ValidateTypes(types []string) error {
+	if len(types) > maxTypes {
+		return iccerror.NewMessageError(iccerror.ErrInvalid, "A meeting can not have more than %d reaction types.", maxTypes)
+	}
+
+	for _, t := range types {
+		if !validType.MatchString(t) {
+			return iccerror.NewMessageError(iccerror.ErrInvalid, "Invalid reaction type `%s`. Only lowercase letters, numbers and `-` are allowed.", t)
+		}
+	}
+	return nil
+}
+
+// MSG contains the number of reactions for each reaction type.
+//
+// Types is only set on the first message of a stream and each time the
+// reaction types of the meeting change.
+type MSG struct {
+	Counts map[string]int `json:"counts"`
+	Types  []string       `json:"types,omitempty"`
+
+	icchttp.StreamHeartbeat
+}
+
+// Send registers, that a user reacted in a meeting.
+func (r *Reaction) Send(ctx context.Context, meetingID, userID int, reactionType string) error {
+	if userID == 0 {
+		return iccerror.NewMessageError(iccerror.ErrNotAllowed, "Anonymous is not allowed to react.")
+	}
+
+	types, err := r.Types(meetingID)
+	if err != nil {
+		return fmt.Errorf("getting reaction types: %w", err)
+	}
+
+	if !slices.Contains(types, reactionType) {
+		return iccerror.NewMessageError(iccerror.ErrInvalid, "Reaction type `%s` is not allowed in meeting %d.", reactionType, meetingID)
+	}
+
+	if err := r.checkPermission(ctx, meetingID, userID, perm.MeetingCanSeeLivestream); err != nil {
+		return err
+	}
+
+	if err := r.limiter.Allow("reaction", userID, "", meetingID); err != nil {
+		return fmt.Errorf("rate limit: %w", err)
+	}
+
+	if err := r.backend.ReactionPublish(meetingID, userID, reactionType, time.Now().Unix()); err != nil {
+		return fmt.Errorf("publish reaction in backend: %w", err)
+	}
+	return nil
+}
+
+// CanReceive returns an error, if the user can not receive reactions.
+func (r *Reaction) CanReceive(ctx context.Context, meetingID, userID int) error {
+	return r.checkPermission(ctx, meetingID, userID, perm.MeetingCanSeeLivestream)
+}
+
+// SetTypes sets the reaction types of a meeting.
+//
+// The user needs the permission to manage the meeting settings. An empty list
+// resets the meeting to the default types.
+func (r *Reaction) SetTypes(ctx context.Context, meetingID, userID int, types []string) error {
+	if err := ValidateTypes(types); err != nil {
+		return err
+	}
+
+	if err := r.checkPermission(ctx, meetingID, userID, perm.MeetingCanManageSettings); err != nil {
+		return err
+	}
+
+	// The receivers get the new types from listenTypes.
+	if err := r.backend.ReactionSetTypes(meetingID, types); err != nil {
+		return fmt.Errorf("saving reaction types: %w", err)
+	}
+	return nil
+}
+
+// Types returns the reaction types of a meeting.
+func (r *Reaction) Types(meetingID int) ([]string, error) {
+	types, err := r.backend.ReactionTypes(meetingID)
+	if err != nil {
+		return nil, fmt.Errorf("fetching reaction types from backend: %w", err)
+	}
+
+	if len(types) == 0 {
+		return r.defaultTypes, nil
+	}
+	return types, nil
+}
+
+func (r *Reaction) checkPermission(ctx context.Context, meetingID, userID int, permission perm.TPermission) error {
+	if userID == 0 {
+		return iccerror.NewMessageError(iccerror.ErrNotAllowed, "Anonymous is not allowed to use reactions.")
+	}
+
+	perms, err := perm.New(ctx, dsfetch.New(r.datastore), userID, meetingID)
+	if err != nil {
+		return fmt.Errorf("getting permissions: %w", err)
+	}
+
+	if !perms.Has(permission) {
+		return iccerror.NewMessageError(iccerror.ErrNotAllowed, "You need the permission %s in meeting %d.", permission, meetingID)
+	}
+	return nil
+}
+
+// Receive returns the reactions for a given meeting.
+//
+// Messages that only change the reaction types have Counts set to nil. The
+// caller should keep the last counts.
+func (r *Reaction) Receive(ctx context.Context, tid uint64, meetingID int) (newTID uint64, msg MSG, err error) {
+	if tid == 0 {
+		tid = r.topic.LastID()
+
+		types, err := r.Types(meetingID)
+		if err != nil {
+			return 0, MSG{}, fmt.Errorf("getting reaction types: %w", err)
+		}
+
+		return tid, MSG{Counts: map[string]int{}, Types: types}, nil
+	}
+
+	for {
+		var messages []MSG
+		tid, messages, err = r.topic.Receive(ctx, tid, meetingID)
+		if err != nil {
+			var errUnknownID topic.UnknownIDError
+			if !errors.As(err, &errUnknownID) {
+				return 0, MSG{}, err
+			}
+
+			// Only the newest counts are relevant, so the pruned messages can
+			// be skipped.
+			tid = r.topic.LastID()
+			continue
+		}
+
+		// Go backwards throw the messages to find the newest counts. If there
+		// are also new types, they are merged.
+		for i := len(messages) - 1; i >= 0; i-- {
+			if msg.Types == nil {
+				msg.Types = messages[i].Types
+			}
+
+			if msg.Counts == nil {
+				msg.Counts = messages[i].Counts
+			}
+
+			if msg.Types != nil && msg.Counts != nil {
+				break
+			}
+		}
+		return tid, msg, nil
+	}
+}
+
+// listen waits for meetings with new reactions and sends them to the loop.
+func (r *Reaction) listen(ctx context.Context, errHandler func(error)) {
+	if errHandler == nil {
+		errHandler = func(error) {}
+	}
+
+	for {
+		meetingID, err := r.backend.ReactionChanged(ctx)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+
+			errHandler(fmt.Errorf("receiving reaction changes from backend: %w", err))
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		select {
+		case r.reactionChanged <- meetingID:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// loop counts the reactions of the meetings with reactions and saves them for
+// the clients to fetch.
+//
+// Only meetings, that got reactions in the window, are counted like in the
+// applause service.
+func (r *Reaction) loop(ctx context.Context, errHandler func(error)) {
+	if errHandler == nil {
+		errHandler = func(error) {}
+	}
+
+	// lastCounts contains the last counts of each meeting with reactions.
+	lastCounts := make(map[int]map[string]int)
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case meetingID := <-r.reactionChanged:
+			if _, ok := lastCounts[meetingID]; !ok {
+				lastCounts[meetingID] = nil
+			}
+			continue
+
+		case <-tick.C:
+		}
+
+		if len(lastCounts) == 0 {
+			continue
+		}
+
+		since := time.Now().Add(-window).Unix()
+		message := make(map[int]MSG)
+		for meetingID, last := range lastCounts {
+			counts, err := r.backend.ReactionCount(meetingID, since)
+			if err != nil {
+				errHandler(fmt.Errorf("counting reactions: %w", err))
+				continue
+			}
+
+			// Forget meetings without reactions. The backend reports them
+			// again, when they get new reactions.
+			lastCounts[meetingID] = counts
+			if len(counts) == 0 {
+				delete(lastCounts, meetingID)
+			}
+
+			if maps.Equal(last, counts) {
+				continue
+			}
+			message[meetingID] = MSG{Counts: counts}
+		}
+
+		if len(message) == 0 {
+			continue
+		}
+
+		if err := r.topic.Publish(message); err != nil {
+			errHandler(err)
+		}
+	}
+}
+
+// listenTypes waits for meetings with changed reaction types and sends the new
+// types to the receivers of the meeting.
+func (r *Reaction) listenTypes(ctx context.Context, errHandler func(error)) {
+	if errHandler == nil {
+		errHandler = func(error) {}
+	}
+
+	for {
+		meetingID, err := r.backend.ReactionTypesChanged(ctx)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+
+			errHandler(fmt.Errorf("receiving reaction type changes from backend: %w", err))
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		types, err := r.Types(meetingID)
+		if err != nil {
+			errHandler(fmt.Errorf("getting reaction types: %w", err))
+			continue
+		}
+
+		if err := r.topic.Publish(map[int]MSG{meetingID: {Types: types}}); err != nil {
+			errHandler(err)
+		}
+	}
+}
+
+// pruneOldData removes old messages.
+func (r *Reaction) pruneOldData(ctx context.Context) {
+	tick := time.NewTicker(5 * time.Minute)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			r.topic.Prune(time.Now().Add(-pruneTime))
+		}
+	}
+}
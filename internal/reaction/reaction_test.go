@@ -0,0 +1,129 @@
+package reaction_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+	"github.com/OpenSlides/openslides-icc-service/internal/reaction"
+)
+
+func TestValidateTypes(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		types []string
+		valid bool
+	}{
+		{"empty", nil, true},
+		{"default", reaction.DefaultTypes, true},
+		{"with dash and number", []string{"thumbs-up", "plus1"}, true},
+		{"upper case", []string{"Laugh"}, false},
+		{"empty type", []string{""}, false},
+		{"too long", []string{"abcdefghijklmnopqrstuvwxyzabcdefg"}, false},
+		{"too many", make([]string, 21), false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := reaction.ValidateTypes(tt.types)
+
+			if tt.valid && err != nil {
+				t.Errorf("ValidateTypes returned unexpected error: %v", err)
+			}
+
+			if !tt.valid && !errors.Is(err, iccerror.ErrInvalid) {
+				t.Errorf("ValidateTypes returned `%v`, expected `%v`", err, iccerror.ErrInvalid)
+			}
+		})
+	}
+}
+
+func TestSendInvalidType(t *testing.T) {
+	backend := &backendStub{types: map[int][]string{1: {"laugh"}}}
+	r, _ := reaction.New(backend, nil)
+
+	err := r.Send(t.Context(), 1, 5, "heart")
+
+	if !errors.Is(err, iccerror.ErrInvalid) {
+		t.Errorf("Send returned `%v`, expected `%v`", err, iccerror.ErrInvalid)
+	}
+}
+
+func TestReceive(t *testing.T) {
+	backend := &backendStub{
+		types:        map[int][]string{2: {"laugh"}},
+		changed:      make(chan int, 1),
+		typesChanged: make(chan int, 1),
+	}
+	r, bg := reaction.New(backend, nil)
+	go bg(t.Context(), nil)
+
+	t.Run("default types", func(t *testing.T) {
+		_, msg, err := r.Receive(t.Context(), 0, 1)
+		if err != nil {
+			t.Fatalf("Receive: %v", err)
+		}
+
+		if !reflect.DeepEqual(msg.Types, reaction.DefaultTypes) {
+			t.Errorf("got types %v, expected %v", msg.Types, reaction.DefaultTypes)
+		}
+	})
+
+	t.Run("meeting types", func(t *testing.T) {
+		_, msg, err := r.Receive(t.Context(), 0, 2)
+		if err != nil {
+			t.Fatalf("Receive: %v", err)
+		}
+
+		if !reflect.DeepEqual(msg.Types, []string{"laugh"}) {
+			t.Errorf("got types %v, expected [laugh]", msg.Types)
+		}
+	})
+
+	t.Run("types changed on another instance", func(t *testing.T) {
+		tid, _, err := r.Receive(t.Context(), 0, 3)
+		if err != nil {
+			t.Fatalf("Receive: %v", err)
+		}
+
+		// The other instance only saves the types in the backend.
+		if err := backend.ReactionSetTypes(3, []string{"heart"}); err != nil {
+			t.Fatalf("ReactionSetTypes: %v", err)
+		}
+
+		ctx, cancel := context.WithTimeout(t.Context(), time.Second)
+		defer cancel()
+
+		_, msg, err := r.Receive(ctx, tid, 3)
+		if err != nil {
+			t.Fatalf("Receive: %v", err)
+		}
+
+		if !reflect.DeepEqual(msg.Types, []string{"heart"}) {
+			t.Errorf("got types %v, expected [heart]", msg.Types)
+		}
+	})
+
+	t.Run("counts", func(t *testing.T) {
+		tid, _, err := r.Receive(t.Context(), 0, 1)
+		if err != nil {
+			t.Fatalf("Receive: %v", err)
+		}
+
+		backend.setCounts(map[int]map[string]int{1: {"laugh": 3, "heart": 1}})
+
+		ctx, cancel := context.WithTimeout(t.Context(), 3*time.Second)
+		defer cancel()
+
+		_, msg, err := r.Receive(ctx, tid, 1)
+		if err != nil {
+			t.Fatalf("Receive: %v", err)
+		}
+
+		expect := map[string]int{"laugh": 3, "heart": 1}
+		if !reflect.DeepEqual(msg.Counts, expect) {
+			t.Errorf("got counts %v, expected %v", msg.Counts, expect)
+		}
+	})
+}
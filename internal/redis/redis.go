@@ -1,8 +1,12 @@
 package redis
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/OpenSlides/openslides-go/oslog"
@@ -13,16 +17,684 @@ const (
 	// notifyKey is the name of the icc stream name.
 	notifyKey = "icc-notify"
 
-	// applauseKey is the name of the redis key for applause.
-	applauseKey = "applause"
+	// notifyMaxLen is the approximated maximum number of messages in the
+	// notify stream. It is higher than the limit of the other streams, since
+	// the notify stream contains the messages and not only ids.
+	notifyMaxLen = 10000
+
+	// notifyRetainedPrefix is the prefix of the redis hashes for the retained
+	// notify messages. There is one hash for each meeting. The values are the
+	// messages with their id in the notify stream as prefix like
+	// `1700000000000-0:message`.
+	notifyRetainedPrefix = "icc-notify-retained:"
+
+	// notifyRetainedTTL is the time, the retained messages of a meeting are
+	// kept after the last retained message.
+	notifyRetainedTTL = 24 * time.Hour
+
+	// notifyScheduledKey is the name of the redis sorted set for scheduled
+	// notify messages. The score is the delivery time. The members are the
+	// messages with a unique number as prefix like `42:message`, so the same
+	// message can be scheduled more than once.
+	notifyScheduledKey = "icc-notify-scheduled"
+
+	// notifyScheduledCounterKey is the name of the redis key for the unique
+	// numbers of the scheduled notify messages.
+	notifyScheduledCounterKey = "icc-notify-scheduled-counter"
+
+	// notifyMessageIDPrefix is the prefix of the redis keys to remember the
+	// message ids of notify messages.
+	notifyMessageIDPrefix = "icc-notify-message-id:"
+
+	// notifyDeadLetterKey is the name of the redis stream for invalid notify
+	// messages.
+	notifyDeadLetterKey = "icc-notify-dead-letter"
+
+	// notifyDeadLetterMaxLen is the approximated maximum number of messages in
+	// the dead letter stream.
+	notifyDeadLetterMaxLen = 1000
+
+	// applausePrefix is the prefix of the redis sorted sets for applause.
+	// There is one set for each meeting. The members are the user ids and the
+	// score is the time.
+	applausePrefix = "icc-applause:"
+
+	// applauseNotifiedPrefix is the prefix of the redis keys, that remember
+	// that a meeting got a change notification.
+	applauseNotifiedPrefix = "icc-applause-notified:"
+
+	// applauseChangedKey is the name of the redis stream for meetings with
+	// new applause.
+	applauseChangedKey = "icc-applause-changed"
+
+	// applauseChangedMaxLen is the approximated maximum number of messages in
+	// the applause stream.
+	applauseChangedMaxLen = 1000
+
+	// applauseNotifyTTL is the time, a meeting gets at most one change
+	// notification. It has to be shorter than the applause window.
+	applauseNotifyTTL = 500 * time.Millisecond
+
+	// applauseRetention is the time, applause is kept in redis.
+	applauseRetention = time.Hour
+
+	// applauseHoldPrefix is the prefix of the redis hashes for held applause.
+	// There is one hash for each meeting. The fields are the user ids and the
+	// values the start times.
+	applauseHoldPrefix = "icc-applause-hold:"
+
+	// applauseHoldExpirePrefix is the prefix of the redis sorted sets for the
+	// expiration of held applause. The members are the user ids and the
+	// score is the expiration time.
+	applauseHoldExpirePrefix = "icc-applause-hold-expire:"
+
+	// applauseParticlePrefix is the prefix of the redis hashes for the
+	// particle counts. There is one hash for each meeting and second. The
+	// fields are the particle ids and the values the counts.
+	applauseParticlePrefix = "icc-applause-particles:"
+
+	// applauseParticleRetention is the time, the particle counts of a second
+	// are kept.
+	applauseParticleRetention = time.Minute
+
+	// applauseHistoryPrefix is the prefix of the redis hashes for the applause
+	// history. There is one hash for each meeting. The fields are the times of
+	// the entries.
+	applauseHistoryPrefix = "icc-applause-history:"
+
+	// applauseHistoryLastPrefix is the prefix of the redis hashes, that
+	// contain the time and the state of the last entry in the applause history
+	// of a meeting.
+	applauseHistoryLastPrefix = "icc-applause-history-last:"
+
+	// applauseHistoryRetention is the time, the applause history of a meeting
+	// is kept after its last entry.
+	applauseHistoryRetention = 30 * 24 * time.Hour
+
+	// applauseThresholdsKey is the name of the redis hash for the applause
+	// thresholds of the meetings.
+	applauseThresholdsKey = "icc-applause-thresholds"
+
+	// applauseThresholdsChangedKey is the name of the redis stream for
+	// meetings with changed applause thresholds.
+	applauseThresholdsChangedKey = "icc-applause-thresholds-changed"
+
+	// applauseEpisodePrefix is the prefix of the redis hashes for the
+	// current applause episode of a meeting. The hashes contain the start of
+	// the episode and if the peak event was reported.
+	applauseEpisodePrefix = "icc-applause-episode:"
+
+	// handPrefix is the prefix of the redis sorted sets for the raised hands.
+	// There is one set for each meeting. The members are the user ids and the
+	// score is the time in milliseconds.
+	handPrefix = "icc-hand:"
+
+	// handChangedKey is the name of the redis stream for meetings with a
+	// changed queue of raised hands.
+	handChangedKey = "icc-hand-changed"
+
+	// handChangedMaxLen is the approximated maximum number of messages in the
+	// hand stream.
+	handChangedMaxLen = 1000
+
+	// handRetention is the time, a queue of raised hands is kept after its
+	// last change.
+	handRetention = 24 * time.Hour
+
+	// pollPrefix is the prefix of the redis keys for the quick polls. There is
+	// one key for each meeting with the encoded poll.
+	pollPrefix = "icc-poll:"
+
+	// pollOpenPrefix is the prefix of the redis keys, that exist while a
+	// meeting has an open quick poll.
+	pollOpenPrefix = "icc-poll-open:"
+
+	// pollVotesPrefix is the prefix of the redis hashes for the votes of the
+	// quick polls. The keys are `prefix:meetingID:pollID`, the fields the user
+	// ids and the values the options.
+	pollVotesPrefix = "icc-poll-votes:"
+
+	// pollCountsPrefix is the prefix of the redis hashes for the number of
+	// votes of each option of the quick polls. The keys are
+	// `prefix:meetingID:pollID`.
+	pollCountsPrefix = "icc-poll-counts:"
+
+	// pollClosedPrefix is the prefix of the redis keys, that mark a quick poll
+	// as closed. The keys are `prefix:meetingID:pollID`.
+	pollClosedPrefix = "icc-poll-closed:"
+
+	// pollNotifiedPrefix is the prefix of the redis keys, that remember that
+	// a meeting got a change notification for a new vote.
+	pollNotifiedPrefix = "icc-poll-notified:"
+
+	// pollChangedKey is the name of the redis stream for meetings with a new
+	// quick poll or new votes.
+	pollChangedKey = "icc-poll-changed"
+
+	// pollChangedMaxLen is the approximated maximum number of messages in the
+	// poll stream.
+	pollChangedMaxLen = 1000
+
+	// pollNotifyTTL is the time, a meeting gets at most one change
+	// notification for new votes.
+	pollNotifyTTL = 500 * time.Millisecond
+
+	// pollRetention is the time, the votes of a quick poll are kept. It has
+	// to be longer than the maximum duration of a poll.
+	pollRetention = 2 * time.Hour
+
+	// statePrefix is the prefix of the redis hashes for the shared state.
+	// There is one hash for each meeting. The fields are the keys and the
+	// values the encoded entries.
+	statePrefix = "icc-state:"
+
+	// stateMetaPrefix is the prefix of the redis hashes for the versions and
+	// owners of the keys. The values are `version:owner`.
+	stateMetaPrefix = "icc-state-meta:"
+
+	// stateVersionPrefix is the prefix of the redis keys for the versions of
+	// the meetings.
+	stateVersionPrefix = "icc-state-version:"
+
+	// stateOwnerPrefix is the prefix of the redis sets for the keys of a
+	// channel. The members are `meetingID:key`. A key can still be in the set
+	// of a channel, after another channel took it over.
+	stateOwnerPrefix = "icc-state-owner:"
+
+	// stateExpireKey is the name of the redis sorted set for the channels,
+	// that own keys. The score is the time in milliseconds, until the
+	// channel is kept.
+	stateExpireKey = "icc-state-expire"
+
+	// stateChangedKey is the name of the redis stream for the changes of the
+	// shared state.
+	stateChangedKey = "icc-state-changed"
+
+	// stateChangedMaxLen is the approximated maximum number of messages in the
+	// state stream.
+	stateChangedMaxLen = 1000
+
+	// reactionPrefix is the prefix of the redis sorted sets for reactions.
+	// There is one set for each meeting. The members are `userID:type` and the
+	// score is the time.
+	reactionPrefix = "icc-reaction:"
+
+	// reactionNotifiedPrefix is the prefix of the redis keys, that remember
+	// that a meeting got a change notification.
+	reactionNotifiedPrefix = "icc-reaction-notified:"
+
+	// reactionChangedKey is the name of the redis stream for meetings with
+	// new reactions.
+	reactionChangedKey = "icc-reaction-changed"
+
+	// reactionNotifyTTL is the time, a meeting gets at most one change
+	// notification. It has to be shorter than the reaction window.
+	reactionNotifyTTL = 500 * time.Millisecond
+
+	// reactionRetention is the time, reactions are kept in redis.
+	reactionRetention = time.Minute
+
+	// reactionTypesKey is the name of the redis hash for the reaction types of
+	// the meetings.
+	reactionTypesKey = "icc-reaction-types"
+
+	// reactionTypesChangedKey is the name of the redis stream for meetings
+	// with changed reaction types.
+	reactionTypesChangedKey = "icc-reaction-types-changed"
+
+	// reactionChangedMaxLen is the approximated maximum number of messages in
+	// the reaction streams.
+	reactionChangedMaxLen = 1000
+
+	// rateLimitPrefix is the prefix of the redis keys for the rate limit
+	// buckets.
+	rateLimitPrefix = "icc-ratelimit:"
 )
 
+// scheduleScript adds the message ARGV[2] with the delivery time ARGV[1] to
+// the sorted set KEYS[1]. The member gets a unique number from the counter
+// KEYS[2] as prefix.
+var scheduleScript = redis.NewScript(2, `
+local number = redis.call("INCR", KEYS[2])
+redis.call("ZADD", KEYS[1], ARGV[1], number .. ":" .. ARGV[2])
+`)
+
+// notifyRetainScript adds the message ARGV[1] to the notify stream KEYS[1]
+// and saves it with its stream id as the message with the name ARGV[2] in the
+// hash KEYS[2]. If ARGV[3] is 1, the name is removed from the hash instead.
+//
+// The hash expires after ARGV[4] milliseconds. The stream is trimmed to about
+// ARGV[5] messages.
+var notifyRetainScript = redis.NewScript(2, `
+local id = redis.call("XADD", KEYS[1], "MAXLEN", "~", ARGV[5], "*", "content", ARGV[1])
+if ARGV[3] == "1" then
+	redis.call("HDEL", KEYS[2], ARGV[2])
+else
+	redis.call("HSET", KEYS[2], ARGV[2], id .. ":" .. ARGV[1])
+end
+redis.call("PEXPIRE", KEYS[2], ARGV[4])
+return id
+`)
+
+// publishDueScript moves all scheduled notify messages with a delivery time
+// before or at ARGV[1] into the notify stream, that is trimmed to about
+// ARGV[2] messages. The unique number of each member is removed before the
+// message is published.
+//
+// Since lua scripts are executed atomically, each message is only published
+// once, even when many instances call the script at the same time.
+var publishDueScript = redis.NewScript(2, `
+local due = redis.call("ZRANGE", KEYS[1], "-inf", ARGV[1], "BYSCORE")
+for _, member in ipairs(due) do
+	local message = string.sub(member, string.find(member, ":", 1, true) + 1)
+	redis.call("XADD", KEYS[2], "MAXLEN", "~", ARGV[2], "*", "content", message)
+	redis.call("ZREM", KEYS[1], member)
+end
+return #due
+`)
+
+// handRaiseScript adds a user to the queue KEYS[1], if the user is not
+// already in it. Each change of the queue is added to the hand stream KEYS[2].
+var handRaiseScript = redis.NewScript(2, `
+local added = redis.call("ZADD", KEYS[1], "NX", ARGV[2], ARGV[1])
+redis.call("PEXPIRE", KEYS[1], ARGV[5])
+if added == 1 then
+	redis.call("XADD", KEYS[2], "MAXLEN", "~", ARGV[4], "*", "content", ARGV[3])
+end
+return added
+`)
+
+// handLowerScript removes the user ARGV[1] from the queue KEYS[1] and adds the
+// change to the hand stream KEYS[2]. If ARGV[1] is empty, all users are
+// removed.
+var handLowerScript = redis.NewScript(2, `
+local removed
+if ARGV[1] == "" then
+	removed = redis.call("DEL", KEYS[1])
+else
+	removed = redis.call("ZREM", KEYS[1], ARGV[1])
+end
+if removed == 1 then
+	redis.call("XADD", KEYS[2], "MAXLEN", "~", ARGV[3], "*", "content", ARGV[2])
+end
+return removed
+`)
+
+// pollOpenScript saves the poll ARGV[1] in KEYS[1] until the unix time
+// ARGV[2] and adds the meeting to the poll stream KEYS[2].
+//
+// KEYS[3] marks the poll as open until the unix time ARGV[5]. If the key
+// already exists, the meeting has an open poll and the script returns 0.
+var pollOpenScript = redis.NewScript(3, `
+if not redis.call("SET", KEYS[3], 1, "NX", "EXAT", ARGV[5]) then
+	return 0
+end
+redis.call("SET", KEYS[1], ARGV[1], "EXAT", ARGV[2])
+redis.call("XADD", KEYS[2], "MAXLEN", "~", ARGV[4], "*", "content", ARGV[3])
+return 1
+`)
+
+// pollVoteScript saves the vote ARGV[2] of the user ARGV[1] in the hash
+// KEYS[1] and counts it in KEYS[2], if the user did not already vote.
+//
+// It adds the meeting to the poll stream KEYS[4], if the meeting did not get
+// a notification in the last ARGV[4] milliseconds.
+var pollVoteScript = redis.NewScript(4, `
+if redis.call("HSETNX", KEYS[1], ARGV[1], ARGV[2]) == 0 then
+	return 0
+end
+redis.call("HINCRBY", KEYS[2], ARGV[2], 1)
+redis.call("EXPIRE", KEYS[1], ARGV[3])
+redis.call("EXPIRE", KEYS[2], ARGV[3])
+
+if redis.call("SET", KEYS[3], 1, "NX", "PX", ARGV[4]) then
+	redis.call("XADD", KEYS[4], "MAXLEN", "~", ARGV[6], "*", "content", ARGV[5])
+end
+return 1
+`)
+
+// stateLua contains the lua functions, that are used by the state scripts of
+// a meeting.
+//
+// The scripts get the keys of the meeting in the same order: KEYS[1] is the
+// hash of the entries, KEYS[2] the hash of the versions and owners, KEYS[3]
+// the version of the meeting and KEYS[4] the state stream. ARGV[1] is the
+// meeting id.
+//
+// The changes are encoded as json in lua. The values are valid json, since
+// they are validated by the state service.
+var stateLua = `
+local function publish(key, version, entry)
+	local change = '{"meeting_id":' .. ARGV[1] .. ',"key":' .. cjson.encode(key) .. ',"version":' .. version .. ',"entry":' .. entry .. '}'
+	redis.call("XADD", KEYS[4], "MAXLEN", "~", ` + strconv.Itoa(stateChangedMaxLen) + `, "*", "content", change)
+end
+
+local function meta(key)
+	local value = redis.call("HGET", KEYS[2], key)
+	if not value then
+		return 0, ""
+	end
+	local version, owner = string.match(value, "^(%d+):(.*)$")
+	return tonumber(version), owner
+end
+
+local function remove(key)
+	local version = redis.call("INCR", KEYS[3])
+	redis.call("HDEL", KEYS[1], key)
+	redis.call("HDEL", KEYS[2], key)
+	publish(key, version, "null")
+end
+`
+
+// stateSetScript saves the value ARGV[3] of the key ARGV[2] for the owner
+// ARGV[4], if the key has the version ARGV[5]. A negative ARGV[5] skips the
+// comparison. The key is added to the set KEYS[5] of the owner and the owner
+// is kept in KEYS[6] at least until ARGV[6].
+//
+// If the key had another owner, it stays in the set of the old owner. The
+// release scripts only remove keys, that still belong to the owner.
+//
+// Returns 1 and the new version or 0 and the current version.
+var stateSetScript = redis.NewScript(6, stateLua+`
+local key, value, owner, expected = ARGV[2], ARGV[3], ARGV[4], tonumber(ARGV[5])
+local current = meta(key)
+if expected >= 0 and current ~= expected then
+	return {0, current}
+end
+
+local version = redis.call("INCR", KEYS[3])
+local entry = '{"value":' .. value .. ',"version":' .. version .. ',"owner":' .. cjson.encode(owner) .. '}'
+redis.call("HSET", KEYS[1], key, entry)
+redis.call("HSET", KEYS[2], key, version .. ":" .. owner)
+redis.call("SADD", KEYS[5], ARGV[1] .. ":" .. key)
+redis.call("ZADD", KEYS[6], "GT", ARGV[6], owner)
+publish(key, version, entry)
+return {1, version}
+`)
+
+// stateDeleteScript removes the key ARGV[2], if it has the version ARGV[3]. A
+// negative ARGV[3] skips the comparison.
+var stateDeleteScript = redis.NewScript(4, stateLua+`
+local current = meta(ARGV[2])
+local expected = tonumber(ARGV[3])
+if expected >= 0 and current ~= expected then
+	return 0
+end
+
+if current ~= 0 then
+	remove(ARGV[2])
+end
+return 1
+`)
+
+// stateReleaseScript removes the keys ARGV[4...] of a meeting, that belong to
+// the owner ARGV[2].
+//
+// If ARGV[3] is not empty, the keys are only removed, if the owner expired
+// before ARGV[3] in the sorted set KEYS[5].
+var stateReleaseScript = redis.NewScript(5, stateLua+`
+if ARGV[3] ~= "" then
+	local expiresAt = redis.call("ZSCORE", KEYS[5], ARGV[2])
+	if expiresAt and tonumber(expiresAt) > tonumber(ARGV[3]) then
+		return 0
+	end
+end
+
+for i = 4, #ARGV do
+	local _, owner = meta(ARGV[i])
+	if owner == ARGV[2] then
+		remove(ARGV[i])
+	end
+end
+return 1
+`)
+
+// stateForgetOwnerScript removes the released members ARGV[3...] from the set
+// KEYS[1] of the owner ARGV[1]. If the set is empty afterwards, the owner is
+// removed from the sorted set KEYS[2].
+//
+// ARGV[2] is like ARGV[3] in stateReleaseScript.
+var stateForgetOwnerScript = redis.NewScript(2, `
+if ARGV[2] ~= "" then
+	local expiresAt = redis.call("ZSCORE", KEYS[2], ARGV[1])
+	if expiresAt and tonumber(expiresAt) > tonumber(ARGV[2]) then
+		return 0
+	end
+end
+
+for i = 3, #ARGV do
+	redis.call("SREM", KEYS[1], ARGV[i])
+end
+
+if redis.call("SCARD", KEYS[1]) == 0 then
+	redis.call("ZREM", KEYS[2], ARGV[1])
+end
+return 1
+`)
+
+// stateSnapshotScript returns the version KEYS[2] and all entries of the hash
+// KEYS[1].
+var stateSnapshotScript = redis.NewScript(2, `
+local version = redis.call("GET", KEYS[2]) or 0
+return {tonumber(version), redis.call("HGETALL", KEYS[1])}
+`)
+
+// reactionPublishScript saves the reaction of a user in the sorted set of the
+// meeting and removes old reactions of the meeting. It notifies about the
+// meeting like applausePublishScript.
+var reactionPublishScript = redis.NewScript(3, `
+redis.call("ZADD", KEYS[1], ARGV[2], ARGV[1])
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", tonumber(ARGV[2]) - tonumber(ARGV[3]))
+redis.call("EXPIRE", KEYS[1], ARGV[3])
+
+if redis.call("SET", KEYS[2], 1, "NX", "PX", ARGV[4]) then
+	redis.call("XADD", KEYS[3], "MAXLEN", "~", ARGV[6], "*", "content", ARGV[5])
+end
+return 0
+`)
+
+// reactionSetTypesScript saves the encoded reaction types ARGV[2] of the
+// meeting ARGV[1] in the hash KEYS[1]. If ARGV[2] is empty, the types of the
+// meeting are removed. Each change is added to the stream KEYS[2].
+var reactionSetTypesScript = redis.NewScript(2, `
+if ARGV[2] == "" then
+	redis.call("HDEL", KEYS[1], ARGV[1])
+else
+	redis.call("HSET", KEYS[1], ARGV[1], ARGV[2])
+end
+redis.call("XADD", KEYS[2], "MAXLEN", "~", ARGV[3], "*", "content", ARGV[1])
+return 0
+`)
+
+// applausePublishScript saves the applause of a user in the sorted set of the
+// meeting and removes old applause of the meeting.
+//
+// It adds the meeting id to the applause stream, if the meeting did not get a
+// notification in the last ARGV[4] milliseconds. So the stream only gets a
+// few messages, even when thousands of users applause at the same time.
+//
+// If ARGV[7] is not empty, the particle is counted in the hash KEYS[4] of the
+// current second.
+var applausePublishScript = redis.NewScript(4, `
+redis.call("ZADD", KEYS[1], ARGV[2], ARGV[1])
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", tonumber(ARGV[2]) - tonumber(ARGV[3]))
+redis.call("EXPIRE", KEYS[1], ARGV[3])
+
+if ARGV[7] ~= "" then
+	redis.call("HINCRBY", KEYS[4], ARGV[7], 1)
+	redis.call("EXPIRE", KEYS[4], ARGV[8])
+end
+
+if redis.call("SET", KEYS[2], 1, "NX", "PX", ARGV[4]) then
+	redis.call("XADD", KEYS[3], "MAXLEN", "~", ARGV[6], "*", "content", ARGV[5])
+end
+return 0
+`)
+
+// applauseStartScript saves, that a user holds the applause button until
+// ARGV[3]. The start time ARGV[2] is only saved, if the user did not already
+// hold the button.
+//
+// It notifies about the meeting like applausePublishScript.
+var applauseStartScript = redis.NewScript(4, `
+redis.call("HSETNX", KEYS[1], ARGV[1], ARGV[2])
+redis.call("ZADD", KEYS[2], ARGV[3], ARGV[1])
+redis.call("EXPIRE", KEYS[1], ARGV[7])
+redis.call("EXPIRE", KEYS[2], ARGV[7])
+
+if redis.call("SET", KEYS[3], 1, "NX", "PX", ARGV[4]) then
+	redis.call("XADD", KEYS[4], "MAXLEN", "~", ARGV[6], "*", "content", ARGV[5])
+end
+return 0
+`)
+
+// applauseHoldersScript removes the held applause, that expired at ARGV[1] and
+// returns the start times of the others.
+var applauseHoldersScript = redis.NewScript(2, `
+local expired = redis.call("ZRANGE", KEYS[2], "-inf", ARGV[1], "BYSCORE")
+for _, userID in ipairs(expired) do
+	redis.call("ZREM", KEYS[2], userID)
+	redis.call("HDEL", KEYS[1], userID)
+end
+return redis.call("HVALS", KEYS[1])
+`)
+
+// applauseSetThresholdsScript saves the encoded thresholds ARGV[2] of the
+// meeting ARGV[1] in the hash KEYS[1] and adds the meeting to the stream
+// KEYS[2].
+var applauseSetThresholdsScript = redis.NewScript(2, `
+redis.call("HSET", KEYS[1], ARGV[1], ARGV[2])
+redis.call("XADD", KEYS[2], "MAXLEN", "~", ARGV[3], "*", "content", ARGV[1])
+return 0
+`)
+
+// applauseRecordScript saves the entry ARGV[3] with the time ARGV[1] in the
+// applause history KEYS[1], if the state ARGV[2] is different to the state of
+// the last entry in the hash KEYS[2]. Entries, that are older than the last
+// entry, are ignored. Both keys expire after ARGV[4] seconds.
+//
+// Returns 1, if the entry was saved.
+var applauseRecordScript = redis.NewScript(2, `
+	local last = redis.call("HMGET", KEYS[2], "time", "state")
+	if last[1] and tonumber(last[1]) > tonumber(ARGV[1]) then
+		return 0
+	end
+	if last[2] == ARGV[2] then
+		return 0
+	end
+
+	redis.call("HSET", KEYS[2], "time", ARGV[1], "state", ARGV[2])
+	redis.call("EXPIRE", KEYS[2], ARGV[4])
+	redis.call("HSET", KEYS[1], ARGV[1], ARGV[3])
+	redis.call("EXPIRE", KEYS[1], ARGV[4])
+	return 1
+`)
+
+// applauseEventScript saves the event ARGV[1] of the applause episode in the
+// hash KEYS[1]. ARGV[2] is the start of the episode and ARGV[3] the time in
+// seconds, the episode is kept.
+//
+// Returns the start of the current episode and 1, if the event was not saved
+// before.
+var applauseEventScript = redis.NewScript(1, `
+	local start = redis.call("HGET", KEYS[1], "start")
+	if ARGV[1] == "applause-started" then
+		if start then
+			return {tonumber(start), 0}
+		end
+		redis.call("HSET", KEYS[1], "start", ARGV[2])
+		redis.call("EXPIRE", KEYS[1], ARGV[3])
+		return {tonumber(ARGV[2]), 1}
+	end
+
+	if start ~= ARGV[2] then
+		return {tonumber(start or 0), 0}
+	end
+
+	if ARGV[1] == "applause-peak" then
+		redis.call("EXPIRE", KEYS[1], ARGV[3])
+		return {tonumber(start), redis.call("HSETNX", KEYS[1], "peaked", 1)}
+	end
+
+	redis.call("DEL", KEYS[1])
+	return {tonumber(start), 1}
+`)
+
+// applauseCountScript counts the users in the sorted set KEYS[1], that
+// applaused since ARGV[1]. Users, that hold the applause button in the hash
+// KEYS[2], are not counted, since they are counted as holders.
+var applauseCountScript = redis.NewScript(2, `
+local count = redis.call("ZCOUNT", KEYS[1], ARGV[1], "+inf")
+for _, userID in ipairs(redis.call("HKEYS", KEYS[2])) do
+	local time = redis.call("ZSCORE", KEYS[1], userID)
+	if time and tonumber(time) >= tonumber(ARGV[1]) then
+		count = count - 1
+	end
+end
+return count
+`)
+
+// rateLimitScript implements token buckets. It takes one token from each
+// bucket in KEYS, but only if all of them have a token. The buckets are
+// configured with the pairs of rate and burst in ARGV.
+//
+// It uses the time of the redis server, so the buckets work, even when the
+// clocks of the icc instances differ. Returns the milliseconds until all
+// buckets have a token or 0, if the tokens were taken.
+//
+// The number of keys is passed to Do as first argument.
+var rateLimitScript = redis.NewScript(-1, `
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local tokens = {}
+local wait = 0
+for i, key in ipairs(KEYS) do
+	local rate = tonumber(ARGV[i * 2 - 1])
+	local burst = tonumber(ARGV[i * 2])
+
+	local bucket = redis.call("HMGET", key, "tokens", "ts")
+	local current = tonumber(bucket[1])
+	local ts = tonumber(bucket[2])
+	if current == nil or ts == nil then
+		current = burst
+		ts = now
+	end
+
+	tokens[i] = math.min(burst, current + (now - ts) / 1000 * rate)
+	if tokens[i] < 1 then
+		wait = math.max(wait, math.ceil((1 - tokens[i]) / rate * 1000))
+	end
+end
+
+for i, key in ipairs(KEYS) do
+	local rate = tonumber(ARGV[i * 2 - 1])
+	local burst = tonumber(ARGV[i * 2])
+
+	if wait == 0 then
+		tokens[i] = tokens[i] - 1
+	end
+
+	redis.call("HSET", key, "tokens", tostring(tokens[i]), "ts", now)
+	redis.call("PEXPIRE", key, math.ceil(burst / rate * 1000) + 1000)
+end
+return wait
+`)
+
 // Redis implements the icc backend by saving the data to redis.
 //
 // Has to be created with redis.New().
 type Redis struct {
-	pool         *redis.Pool
-	lastNotifyID string
+	pool           *redis.Pool
+	lastNotifyID   string
+	lastApplauseID string
+	lastHandID     string
+	lastPollID     string
+	lastStateID    string
+
+	lastApplauseThresholdsID string
+	lastReactionID           string
+	lastReactionTypesID      string
 }
 
 // New creates a new initializes redis instance.
@@ -59,7 +731,7 @@ func (r *Redis) NotifyPublish(message []byte) error {
 	conn := r.pool.Get()
 	defer conn.Close()
 
-	_, err := conn.Do("XADD", notifyKey, "*", "content", message)
+	_, err := conn.Do("XADD", notifyKey, "MAXLEN", "~", notifyMaxLen, "*", "content", message)
 	if err != nil {
 		return fmt.Errorf("xadd: %w", err)
 	}
@@ -72,9 +744,235 @@ func (r *Redis) NotifyPublish(message []byte) error {
 // so on. If there are no more messages to read, the function blocks until there
 // is or the context ist canceled.
 //
+// The returned id is the id of the message in the redis stream. If the entry in
+// the stream is invalid, the id and the raw entry are returned with the error.
+// The next call returns the next message.
+//
+// It is expected, that only one goroutine is calling this function.
+func (r *Redis) NotifyReceive(ctx context.Context) (string, []byte, error) {
+	id, data, err := r.readEntry(ctx, notifyKey, &r.lastNotifyID)
+	if err != nil {
+		return id, data, fmt.Errorf("read notify message from redis: %w", err)
+	}
+
+	return id, data, nil
+}
+
+// NotifyRetain publishes a message and saves it as the last message with the
+// given name in a meeting. If clear is true, the retained message is removed
+// instead.
+func (r *Redis) NotifyRetain(meetingID int, name string, message []byte, clear bool) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	_, err := notifyRetainScript.Do(
+		conn,
+		notifyKey,
+		fmt.Sprintf("%s%d", notifyRetainedPrefix, meetingID),
+		message,
+		name,
+		clear,
+		notifyRetainedTTL.Milliseconds(),
+		notifyMaxLen,
+	)
+	if err != nil {
+		return fmt.Errorf("running notify retain script: %w", err)
+	}
+	return nil
+}
+
+// NotifyRetained returns all retained messages of a meeting with their ids in
+// the notify stream.
+func (r *Redis) NotifyRetained(meetingID int) ([]string, [][]byte, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	values, err := redis.ByteSlices(conn.Do("HVALS", fmt.Sprintf("%s%d", notifyRetainedPrefix, meetingID)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("hvals: %w", err)
+	}
+
+	ids := make([]string, 0, len(values))
+	messages := make([][]byte, 0, len(values))
+	for _, value := range values {
+		id, message, ok := bytes.Cut(value, []byte(":"))
+		if !ok {
+			oslog.Error("Skipping retained notify message without id: %s", value)
+			continue
+		}
+
+		ids = append(ids, string(id))
+		messages = append(messages, message)
+	}
+	return ids, messages, nil
+}
+
+// NotifySchedule saves a message that should be published at the given time
+// as unix time stamp.
+func (r *Redis) NotifySchedule(message []byte, deliverAt int64) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	if _, err := scheduleScript.Do(conn, notifyScheduledKey, notifyScheduledCounterKey, deliverAt, message); err != nil {
+		return fmt.Errorf("running schedule script: %w", err)
+	}
+	return nil
+}
+
+// NotifyPublishDue publishes all scheduled messages with a delivery time
+// before or at `now`.
+func (r *Redis) NotifyPublishDue(now int64) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	count, err := redis.Int(publishDueScript.Do(conn, notifyScheduledKey, notifyKey, now, notifyMaxLen))
+	if err != nil {
+		return fmt.Errorf("running publish due script: %w", err)
+	}
+
+	if count > 0 {
+		oslog.Debug("Published %d scheduled notify messages", count)
+	}
+	return nil
+}
+
+// NotifyFirstSeen returns true, if the message id was not seen for the user
+// in the given duration.
+func (r *Redis) NotifyFirstSeen(userID int, messageID string, window time.Duration) (bool, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	key := fmt.Sprintf("%s%d:%s", notifyMessageIDPrefix, userID, messageID)
+	reply, err := conn.Do("SET", key, 1, "NX", "PX", window.Milliseconds())
+	if err != nil {
+		return false, fmt.Errorf("set: %w", err)
+	}
+
+	// SET with NX returns nil, if the key already exists.
+	return reply != nil, nil
+}
+
+// NotifyForget removes a message id of a user, that was remembered with
+// NotifyFirstSeen.
+func (r *Redis) NotifyForget(userID int, messageID string) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	key := fmt.Sprintf("%s%d:%s", notifyMessageIDPrefix, userID, messageID)
+	if _, err := conn.Do("DEL", key); err != nil {
+		return fmt.Errorf("del: %w", err)
+	}
+	return nil
+}
+
+// NotifyDeadLetter saves an invalid message with the reason why it is invalid.
+func (r *Redis) NotifyDeadLetter(id string, message []byte, reason string) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("XADD", notifyDeadLetterKey, "MAXLEN", "~", notifyDeadLetterMaxLen, "*", "id", id, "content", message, "reason", reason)
+	if err != nil {
+		return fmt.Errorf("xadd: %w", err)
+	}
+	return nil
+}
+
+// ApplausePublish saves an applause for the user at a given time as unix time
+// stamp.
+//
+// The applause is saved in a sorted set of the meeting. If the meeting did not
+// get a change notification in the last applauseNotifyTTL, a notification is
+// added to the applause stream. If particle is not empty, it is counted for
+// the second of the applause.
+func (r *Redis) ApplausePublish(meetingID, userID int, time int64, particle string) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	_, err := applausePublishScript.Do(
+		conn,
+		fmt.Sprintf("%s%d", applausePrefix, meetingID),
+		fmt.Sprintf("%s%d", applauseNotifiedPrefix, meetingID),
+		applauseChangedKey,
+		applauseParticleKey(meetingID, time),
+		userID,
+		time,
+		int64(applauseRetention.Seconds()),
+		applauseNotifyTTL.Milliseconds(),
+		meetingID,
+		applauseChangedMaxLen,
+		particle,
+		int64(applauseParticleRetention.Seconds()),
+	)
+	if err != nil {
+		return fmt.Errorf("running applause publish script: %w", err)
+	}
+
+	return nil
+}
+
+// ApplauseChanged is a blocking function that returns the id of a meeting,
+// that got new applause.
+//
+// The first call returns the first meeting after the call, the next call the
+// next one and so on. A meeting is not returned for each applause but only
+// once in applauseNotifyTTL.
+//
 // It is expected, that only one goroutine is calling this function.
-func (r *Redis) NotifyReceive(ctx context.Context) ([]byte, error) {
-	id := r.lastNotifyID
+func (r *Redis) ApplauseChanged(ctx context.Context) (int, error) {
+	meetingID, err := r.readMeetingStream(ctx, applauseChangedKey, &r.lastApplauseID)
+	if err != nil {
+		return 0, fmt.Errorf("read applause change: %w", err)
+	}
+	return meetingID, nil
+}
+
+// readMeetingStream is a blocking function that returns the next meeting id
+// from a stream with the meeting ids in the field content.
+//
+// lastID is like in readStream.
+func (r *Redis) readMeetingStream(ctx context.Context, key string, lastID *string) (int, error) {
+	data, err := r.readStream(ctx, key, lastID)
+	if err != nil {
+		return 0, err
+	}
+
+	meetingID, err := strconv.Atoi(string(data))
+	if err != nil {
+		return 0, fmt.Errorf("invalid meeting id in stream %s: %s", key, data)
+	}
+
+	return meetingID, nil
+}
+
+// readStream is a blocking function that returns the field content of the
+// next message from a stream.
+//
+// lastID is the id of the last read message. It is updated with the id of the
+// returned message. If it is empty, the first message after the call is
+// returned.
+//
+// Invalid entries are skipped.
+func (r *Redis) readStream(ctx context.Context, key string, lastID *string) ([]byte, error) {
+	for {
+		id, data, err := r.readEntry(ctx, key, lastID)
+		if err != nil {
+			if id != "" {
+				oslog.Error("Skipping invalid entry %s in stream %s: %v", id, key, err)
+				continue
+			}
+			return nil, err
+		}
+
+		return data, nil
+	}
+}
+
+// readEntry is a blocking function that returns the next entry of a stream.
+//
+// lastID is updated with the id of the entry, even if the entry is invalid. In
+// this case, the id and the raw entry are returned with the error.
+func (r *Redis) readEntry(ctx context.Context, key string, lastID *string) (string, []byte, error) {
+	id := *lastID
 	if id == "" {
 		id = "$"
 	}
@@ -85,13 +983,13 @@ func (r *Redis) NotifyReceive(ctx context.Context) ([]byte, error) {
 		err  error
 	}
 
-	streamFinished := make(chan streamReturn)
+	streamFinished := make(chan streamReturn, 1)
 
 	go func() {
 		conn := r.pool.Get()
 		defer conn.Close()
 
-		id, data, err := stream(conn.Do("XREAD", "COUNT", 1, "BLOCK", "0", "STREAMS", notifyKey, id))
+		id, data, err := stream(conn.Do("XREAD", "COUNT", 1, "BLOCK", "0", "STREAMS", key, id))
 		streamFinished <- streamReturn{id, data, err}
 	}()
 
@@ -99,63 +997,770 @@ func (r *Redis) NotifyReceive(ctx context.Context) ([]byte, error) {
 	select {
 	case received = <-streamFinished:
 	case <-ctx.Done():
-		return nil, ctx.Err()
+		return "", nil, ctx.Err()
 	}
 
 	if received.id != "" {
-		r.lastNotifyID = id
+		*lastID = received.id
 	}
 
 	if err := received.err; err != nil {
-		return nil, fmt.Errorf("read notify message from redis: %w", err)
+		return received.id, received.data, fmt.Errorf("read stream %s from redis: %w", key, err)
 	}
 
-	return received.data, nil
+	return received.id, received.data, nil
 }
 
-// ApplausePublish saves an applause for the user at a given time as unix time
-// stamp.
-func (r *Redis) ApplausePublish(meetingID, userID int, time int64) error {
+// ApplauseCount returns the number of users, that applaused in a meeting since
+// a given time as unix time stamp. Users, that hold the applause button, are
+// not counted.
+func (r *Redis) ApplauseCount(meetingID int, since int64) (int, error) {
 	conn := r.pool.Get()
 	defer conn.Close()
 
-	meetingUser := fmt.Sprintf("%d-%d", meetingID, userID)
-	if _, err := conn.Do("ZADD", applauseKey, time, meetingUser); err != nil {
-		return fmt.Errorf("adding applause in redis: %w", err)
+	count, err := redis.Int(applauseCountScript.Do(
+		conn,
+		fmt.Sprintf("%s%d", applausePrefix, meetingID),
+		fmt.Sprintf("%s%d", applauseHoldPrefix, meetingID),
+		since,
+	))
+	if err != nil {
+		return 0, fmt.Errorf("counting applause in redis: %w", err)
 	}
 
-	return nil
+	return count, nil
+}
+
+// ApplauseParticles returns the number of each particle in a meeting, that
+// were sent in the seconds from `from` to `to`, excluding `to`.
+func (r *Redis) ApplauseParticles(meetingID int, from, to int64) (map[string]int, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	particles := make(map[string]int)
+	for second := max(from, to-int64(applauseParticleRetention.Seconds())); second < to; second++ {
+		counts, err := redis.IntMap(conn.Do("HGETALL", applauseParticleKey(meetingID, second)))
+		if err != nil {
+			return nil, fmt.Errorf("hgetall: %w", err)
+		}
+
+		for particle, count := range counts {
+			particles[particle] += count
+		}
+	}
+	return particles, nil
+}
+
+func applauseParticleKey(meetingID int, second int64) string {
+	return fmt.Sprintf("%s%d:%d", applauseParticlePrefix, meetingID, second)
 }
 
-// ApplauseSince returned all applause since a given time as unix time stamp.
-func (r *Redis) ApplauseSince(time int64) (map[int]int, error) {
+// ApplauseStart saves, that a user holds the applause button in a meeting
+// until `expire` as unix time stamp.
+func (r *Redis) ApplauseStart(meetingID, userID int, time, expire int64) error {
 	conn := r.pool.Get()
 	defer conn.Close()
 
-	meetingUsers, err := redis.Strings(conn.Do("ZRANGE", applauseKey, time, "+inf", "BYSCORE"))
+	_, err := applauseStartScript.Do(
+		conn,
+		fmt.Sprintf("%s%d", applauseHoldPrefix, meetingID),
+		fmt.Sprintf("%s%d", applauseHoldExpirePrefix, meetingID),
+		fmt.Sprintf("%s%d", applauseNotifiedPrefix, meetingID),
+		applauseChangedKey,
+		userID,
+		time,
+		expire,
+		applauseNotifyTTL.Milliseconds(),
+		meetingID,
+		applauseChangedMaxLen,
+		int64(applauseRetention.Seconds()),
+	)
 	if err != nil {
-		return nil, fmt.Errorf("getting applause from redis: %w", err)
+		return fmt.Errorf("running applause start script: %w", err)
 	}
+	return nil
+}
 
-	out := make(map[int]int)
-	for _, meetingUser := range meetingUsers {
-		var meetingID int
-		if _, err := fmt.Sscanf(meetingUser, "%d-", &meetingID); err != nil {
-			return nil, fmt.Errorf("invalid value in redis %s: %w", meetingUser, err)
-		}
-		out[meetingID]++
+// ApplauseStop removes the held applause of a user.
+func (r *Redis) ApplauseStop(meetingID, userID int) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	if _, err := conn.Do("ZREM", fmt.Sprintf("%s%d", applauseHoldExpirePrefix, meetingID), userID); err != nil {
+		return fmt.Errorf("zrem: %w", err)
+	}
+
+	if _, err := conn.Do("HDEL", fmt.Sprintf("%s%d", applauseHoldPrefix, meetingID), userID); err != nil {
+		return fmt.Errorf("hdel: %w", err)
 	}
+	return nil
+}
+
+// ApplauseHolders returns the start times of the users, that hold the
+// applause button in a meeting and did not expire at `time`.
+func (r *Redis) ApplauseHolders(meetingID int, time int64) ([]int64, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
 
-	return out, nil
+	starts, err := redis.Int64s(applauseHoldersScript.Do(
+		conn,
+		fmt.Sprintf("%s%d", applauseHoldPrefix, meetingID),
+		fmt.Sprintf("%s%d", applauseHoldExpirePrefix, meetingID),
+		time,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("running applause holders script: %w", err)
+	}
+	return starts, nil
 }
 
-// ApplauseCleanOld removes applause that is older then a given time.
-func (r *Redis) ApplauseCleanOld(olderThen int64) error {
+// ApplauseRecord saves an entry of the applause history of a meeting, if its
+// state is different to the state of the last entry.
+//
+// The check is done in redis, so many instances can record the same meeting.
+func (r *Redis) ApplauseRecord(meetingID int, time int64, state string, entry []byte) error {
 	conn := r.pool.Get()
 	defer conn.Close()
 
-	if _, err := conn.Do("ZREMRANGEBYSCORE", applauseKey, 0, olderThen-1); err != nil {
-		return fmt.Errorf("removing old applause from redis: %w", err)
+	_, err := applauseRecordScript.Do(
+		conn,
+		fmt.Sprintf("%s%d", applauseHistoryPrefix, meetingID),
+		fmt.Sprintf("%s%d", applauseHistoryLastPrefix, meetingID),
+		time,
+		state,
+		entry,
+		int64(applauseHistoryRetention.Seconds()),
+	)
+	if err != nil {
+		return fmt.Errorf("running applause record script: %w", err)
 	}
 	return nil
 }
+
+// ApplauseHistory returns all entries of the applause history of a meeting.
+func (r *Redis) ApplauseHistory(meetingID int) (map[int64][]byte, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	values, err := redis.StringMap(conn.Do("HGETALL", fmt.Sprintf("%s%d", applauseHistoryPrefix, meetingID)))
+	if err != nil {
+		return nil, fmt.Errorf("hgetall: %w", err)
+	}
+
+	history := make(map[int64][]byte, len(values))
+	for field, entry := range values {
+		entryTime, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time in applause history: %s", field)
+		}
+		history[entryTime] = []byte(entry)
+	}
+	return history, nil
+}
+
+// ApplauseThresholds returns the encoded applause thresholds of a meeting.
+// Returns nil, if the meeting has no own thresholds.
+func (r *Redis) ApplauseThresholds(meetingID int) ([]byte, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	encoded, err := redis.Bytes(conn.Do("HGET", applauseThresholdsKey, meetingID))
+	if err != nil {
+		if err == redis.ErrNil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("getting applause thresholds from redis: %w", err)
+	}
+	return encoded, nil
+}
+
+// ApplauseSetThresholds saves the encoded applause thresholds of a meeting.
+func (r *Redis) ApplauseSetThresholds(meetingID int, thresholds []byte) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	_, err := applauseSetThresholdsScript.Do(
+		conn,
+		applauseThresholdsKey,
+		applauseThresholdsChangedKey,
+		meetingID,
+		thresholds,
+		applauseChangedMaxLen,
+	)
+	if err != nil {
+		return fmt.Errorf("running applause set thresholds script: %w", err)
+	}
+	return nil
+}
+
+// ApplauseThresholdsChanged is a blocking function that returns the id of a
+// meeting, where the applause thresholds changed.
+//
+// It is expected, that only one goroutine is calling this function.
+func (r *Redis) ApplauseThresholdsChanged(ctx context.Context) (int, error) {
+	meetingID, err := r.readMeetingStream(ctx, applauseThresholdsChangedKey, &r.lastApplauseThresholdsID)
+	if err != nil {
+		return 0, fmt.Errorf("read applause thresholds change: %w", err)
+	}
+	return meetingID, nil
+}
+
+// ApplauseEventFirst saves an event of the applause episode of a meeting. It
+// returns the start of the current episode and true, if the event was not
+// saved before.
+//
+// The episode is kept for applauseRetention.
+func (r *Redis) ApplauseEventFirst(meetingID int, event string, start int64) (int64, bool, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	reply, err := redis.Int64s(applauseEventScript.Do(
+		conn,
+		fmt.Sprintf("%s%d", applauseEpisodePrefix, meetingID),
+		event,
+		start,
+		int64(applauseRetention.Seconds()),
+	))
+	if err != nil {
+		return 0, false, fmt.Errorf("running applause event script: %w", err)
+	}
+
+	if len(reply) != 2 {
+		return 0, false, fmt.Errorf("invalid reply from applause event script: %v", reply)
+	}
+	return reply[0], reply[1] == 1, nil
+}
+
+// HandRaise adds a user to the queue of raised hands of a meeting.
+func (r *Redis) HandRaise(meetingID, userID int, time int64) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	_, err := handRaiseScript.Do(
+		conn,
+		fmt.Sprintf("%s%d", handPrefix, meetingID),
+		handChangedKey,
+		userID,
+		time,
+		meetingID,
+		handChangedMaxLen,
+		handRetention.Milliseconds(),
+	)
+	if err != nil {
+		return fmt.Errorf("running hand raise script: %w", err)
+	}
+	return nil
+}
+
+// HandLower removes a user from the queue of raised hands of a meeting.
+func (r *Redis) HandLower(meetingID, userID int) error {
+	return r.handLower(meetingID, strconv.Itoa(userID))
+}
+
+// HandClear removes all users from the queue of raised hands of a meeting.
+func (r *Redis) HandClear(meetingID int) error {
+	return r.handLower(meetingID, "")
+}
+
+func (r *Redis) handLower(meetingID int, member string) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	_, err := handLowerScript.Do(
+		conn,
+		fmt.Sprintf("%s%d", handPrefix, meetingID),
+		handChangedKey,
+		member,
+		meetingID,
+		handChangedMaxLen,
+	)
+	if err != nil {
+		return fmt.Errorf("running hand lower script: %w", err)
+	}
+	return nil
+}
+
+// HandQueue returns the user ids of the queue of raised hands of a meeting and
+// the times, they raised their hands.
+func (r *Redis) HandQueue(meetingID int) ([]int, []int64, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	values, err := redis.Strings(conn.Do("ZRANGE", fmt.Sprintf("%s%d", handPrefix, meetingID), 0, -1, "WITHSCORES"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("zrange: %w", err)
+	}
+
+	userIDs := make([]int, 0, len(values)/2)
+	raisedAt := make([]int64, 0, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		userID, err := strconv.Atoi(values[i])
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid user id in hand queue: %s", values[i])
+		}
+
+		// Redis can return the score in exponent notation.
+		score, err := strconv.ParseFloat(values[i+1], 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid time in hand queue: %s", values[i+1])
+		}
+
+		userIDs = append(userIDs, userID)
+		raisedAt = append(raisedAt, int64(score))
+	}
+	return userIDs, raisedAt, nil
+}
+
+// HandChanged is a blocking function that returns the id of a meeting, where
+// the queue of raised hands changed.
+//
+// It is expected, that only one goroutine is calling this function.
+func (r *Redis) HandChanged(ctx context.Context) (int, error) {
+	meetingID, err := r.readMeetingStream(ctx, handChangedKey, &r.lastHandID)
+	if err != nil {
+		return 0, fmt.Errorf("read hand change: %w", err)
+	}
+	return meetingID, nil
+}
+
+// PollOpen saves the encoded quick poll of a meeting until the unix time
+// `until` and notifies all instances.
+//
+// The poll is open until the unix time `closesAt`. It returns false and does
+// not save the poll, if the meeting already has an open poll.
+func (r *Redis) PollOpen(meetingID int, poll []byte, closesAt, until int64) (bool, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	opened, err := redis.Bool(pollOpenScript.Do(
+		conn,
+		fmt.Sprintf("%s%d", pollPrefix, meetingID),
+		pollChangedKey,
+		fmt.Sprintf("%s%d", pollOpenPrefix, meetingID),
+		poll,
+		until,
+		meetingID,
+		pollChangedMaxLen,
+		closesAt,
+	))
+	if err != nil {
+		return false, fmt.Errorf("running poll open script: %w", err)
+	}
+	return opened, nil
+}
+
+// PollCurrent returns the encoded quick poll of a meeting. Returns nil, if the
+// meeting has no poll.
+func (r *Redis) PollCurrent(meetingID int) ([]byte, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	encoded, err := redis.Bytes(conn.Do("GET", fmt.Sprintf("%s%d", pollPrefix, meetingID)))
+	if err != nil {
+		if err == redis.ErrNil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("getting poll from redis: %w", err)
+	}
+	return encoded, nil
+}
+
+// PollVote saves the vote of a user, if the user did not already vote in the
+// poll.
+func (r *Redis) PollVote(meetingID int, pollID string, userID int, option string) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	_, err := pollVoteScript.Do(
+		conn,
+		fmt.Sprintf("%s%d:%s", pollVotesPrefix, meetingID, pollID),
+		fmt.Sprintf("%s%d:%s", pollCountsPrefix, meetingID, pollID),
+		fmt.Sprintf("%s%d", pollNotifiedPrefix, meetingID),
+		pollChangedKey,
+		userID,
+		option,
+		int64(pollRetention.Seconds()),
+		pollNotifyTTL.Milliseconds(),
+		meetingID,
+		pollChangedMaxLen,
+	)
+	if err != nil {
+		return fmt.Errorf("running poll vote script: %w", err)
+	}
+	return nil
+}
+
+// PollCounts returns the number of votes for each option of a quick poll.
+func (r *Redis) PollCounts(meetingID int, pollID string) (map[string]int, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	counts, err := redis.IntMap(conn.Do("HGETALL", fmt.Sprintf("%s%d:%s", pollCountsPrefix, meetingID, pollID)))
+	if err != nil {
+		return nil, fmt.Errorf("hgetall: %w", err)
+	}
+	return counts, nil
+}
+
+// PollChanged is a blocking function that returns the id of a meeting with a
+// new quick poll or new votes.
+//
+// It is expected, that only one goroutine is calling this function.
+func (r *Redis) PollChanged(ctx context.Context) (int, error) {
+	meetingID, err := r.readMeetingStream(ctx, pollChangedKey, &r.lastPollID)
+	if err != nil {
+		return 0, fmt.Errorf("read poll change: %w", err)
+	}
+	return meetingID, nil
+}
+
+// PollClose marks a quick poll as closed. It returns true for the first call
+// of a poll.
+func (r *Redis) PollClose(meetingID int, pollID string) (bool, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	key := fmt.Sprintf("%s%d:%s", pollClosedPrefix, meetingID, pollID)
+	reply, err := conn.Do("SET", key, 1, "NX", "EX", int64(pollRetention.Seconds()))
+	if err != nil {
+		return false, fmt.Errorf("set: %w", err)
+	}
+	return reply != nil, nil
+}
+
+// stateKeys returns the redis keys of a meeting in the order of stateLua.
+func stateKeys(meetingID int) []any {
+	return []any{
+		fmt.Sprintf("%s%d", statePrefix, meetingID),
+		fmt.Sprintf("%s%d", stateMetaPrefix, meetingID),
+		fmt.Sprintf("%s%d", stateVersionPrefix, meetingID),
+		stateChangedKey,
+	}
+}
+
+// StateSet saves the value of a key in a meeting for an owner, if the key has
+// the expected version. A negative version skips the comparison.
+func (r *Redis) StateSet(meetingID int, key string, value []byte, owner string, expected, expiresAt int64) (int64, bool, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	args := append(
+		stateKeys(meetingID),
+		stateOwnerPrefix+owner,
+		stateExpireKey,
+		meetingID,
+		key,
+		value,
+		owner,
+		expected,
+		expiresAt,
+	)
+
+	reply, err := redis.Int64s(stateSetScript.Do(conn, args...))
+	if err != nil {
+		return 0, false, fmt.Errorf("running state set script: %w", err)
+	}
+
+	if len(reply) != 2 {
+		return 0, false, fmt.Errorf("invalid reply from state set script: %v", reply)
+	}
+	return reply[1], reply[0] == 1, nil
+}
+
+// StateDelete removes a key of a meeting, if the key has the expected version.
+// A negative version skips the comparison.
+func (r *Redis) StateDelete(meetingID int, key string, expected int64) (bool, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	args := append(stateKeys(meetingID), meetingID, key, expected)
+
+	ok, err := redis.Bool(stateDeleteScript.Do(conn, args...))
+	if err != nil {
+		return false, fmt.Errorf("running state delete script: %w", err)
+	}
+	return ok, nil
+}
+
+// StateSnapshot returns the version of a meeting and its encoded entries.
+func (r *Redis) StateSnapshot(meetingID int) (int64, map[string][]byte, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	reply, err := redis.Values(stateSnapshotScript.Do(
+		conn,
+		fmt.Sprintf("%s%d", statePrefix, meetingID),
+		fmt.Sprintf("%s%d", stateVersionPrefix, meetingID),
+	))
+	if err != nil {
+		return 0, nil, fmt.Errorf("running state snapshot script: %w", err)
+	}
+
+	if len(reply) != 2 {
+		return 0, nil, fmt.Errorf("invalid reply from state snapshot script: %v", reply)
+	}
+
+	version, err := redis.Int64(reply[0], nil)
+	if err != nil {
+		return 0, nil, fmt.Errorf("parsing state version: %w", err)
+	}
+
+	values, err := redis.ByteSlices(reply[1], nil)
+	if err != nil {
+		return 0, nil, fmt.Errorf("parsing state entries: %w", err)
+	}
+
+	entries := make(map[string][]byte, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		entries[string(values[i])] = values[i+1]
+	}
+	return version, entries, nil
+}
+
+// StateTouch keeps the keys of an owner until expiresAt. It does nothing, if
+// the owner has no keys.
+func (r *Redis) StateTouch(owner string, expiresAt int64) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	if _, err := conn.Do("ZADD", stateExpireKey, "XX", "GT", expiresAt, owner); err != nil {
+		return fmt.Errorf("zadd: %w", err)
+	}
+	return nil
+}
+
+// StateRelease removes all keys of an owner.
+func (r *Redis) StateRelease(owner string) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	if err := stateRelease(conn, owner, ""); err != nil {
+		return fmt.Errorf("releasing %s: %w", owner, err)
+	}
+	return nil
+}
+
+// StateExpire removes the keys of all owners, that expired before now.
+func (r *Redis) StateExpire(now int64) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	owners, err := redis.Strings(conn.Do("ZRANGE", stateExpireKey, "-inf", now, "BYSCORE"))
+	if err != nil {
+		return fmt.Errorf("getting expired owners: %w", err)
+	}
+
+	for _, owner := range owners {
+		if err := stateRelease(conn, owner, strconv.FormatInt(now, 10)); err != nil {
+			return fmt.Errorf("releasing %s: %w", owner, err)
+		}
+	}
+	return nil
+}
+
+// stateRelease removes the keys of an owner with one script call for each
+// meeting, so each script only uses the keys of one meeting.
+//
+// If expiredAt is not empty, the keys are only removed, if the owner was not
+// kept after this time in the meantime.
+func stateRelease(conn redis.Conn, owner string, expiredAt string) error {
+	members, err := redis.Strings(conn.Do("SMEMBERS", stateOwnerPrefix+owner))
+	if err != nil {
+		return fmt.Errorf("getting keys of owner: %w", err)
+	}
+
+	keys := make(map[int][]any)
+	for _, member := range members {
+		rawMeetingID, key, _ := strings.Cut(member, ":")
+		meetingID, err := strconv.Atoi(rawMeetingID)
+		if err != nil {
+			return fmt.Errorf("invalid member of owner: %s", member)
+		}
+		keys[meetingID] = append(keys[meetingID], key)
+	}
+
+	for meetingID, meetingKeys := range keys {
+		args := append(stateKeys(meetingID), stateExpireKey, meetingID, owner, expiredAt)
+		if _, err := stateReleaseScript.Do(conn, append(args, meetingKeys...)...); err != nil {
+			return fmt.Errorf("running state release script for meeting %d: %w", meetingID, err)
+		}
+	}
+
+	args := []any{stateOwnerPrefix + owner, stateExpireKey, owner, expiredAt}
+	for _, member := range members {
+		args = append(args, member)
+	}
+
+	if _, err := stateForgetOwnerScript.Do(conn, args...); err != nil {
+		return fmt.Errorf("running state forget owner script: %w", err)
+	}
+	return nil
+}
+
+// StateChanged is a blocking function that returns the next encoded change of
+// the shared state.
+//
+// It is expected, that only one goroutine is calling this function.
+func (r *Redis) StateChanged(ctx context.Context) ([]byte, error) {
+	change, err := r.readStream(ctx, stateChangedKey, &r.lastStateID)
+	if err != nil {
+		return nil, fmt.Errorf("read state change: %w", err)
+	}
+	return change, nil
+}
+
+// ReactionPublish saves the reaction of a user at a given time as unix time
+// stamp.
+//
+// The reaction is saved in a sorted set of the meeting. If the meeting did not
+// get a change notification in the last reactionNotifyTTL, a notification is
+// added to the reaction stream.
+func (r *Redis) ReactionPublish(meetingID, userID int, reactionType string, time int64) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	_, err := reactionPublishScript.Do(
+		conn,
+		fmt.Sprintf("%s%d", reactionPrefix, meetingID),
+		fmt.Sprintf("%s%d", reactionNotifiedPrefix, meetingID),
+		reactionChangedKey,
+		fmt.Sprintf("%d:%s", userID, reactionType),
+		time,
+		int64(reactionRetention.Seconds()),
+		reactionNotifyTTL.Milliseconds(),
+		meetingID,
+		reactionChangedMaxLen,
+	)
+	if err != nil {
+		return fmt.Errorf("running reaction publish script: %w", err)
+	}
+
+	return nil
+}
+
+// ReactionChanged is a blocking function that returns the id of a meeting,
+// that got new reactions.
+//
+// A meeting is not returned for each reaction but only once in
+// reactionNotifyTTL.
+//
+// It is expected, that only one goroutine is calling this function.
+func (r *Redis) ReactionChanged(ctx context.Context) (int, error) {
+	meetingID, err := r.readMeetingStream(ctx, reactionChangedKey, &r.lastReactionID)
+	if err != nil {
+		return 0, fmt.Errorf("read reaction change: %w", err)
+	}
+	return meetingID, nil
+}
+
+// ReactionCount returns the number of users for each reaction type, that
+// reacted in a meeting since a given time as unix time stamp.
+func (r *Redis) ReactionCount(meetingID int, since int64) (map[string]int, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	members, err := redis.Strings(conn.Do("ZRANGE", fmt.Sprintf("%s%d", reactionPrefix, meetingID), since, "+inf", "BYSCORE"))
+	if err != nil {
+		return nil, fmt.Errorf("getting reactions from redis: %w", err)
+	}
+
+	counts := make(map[string]int)
+	for _, member := range members {
+		_, reactionType, ok := strings.Cut(member, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid reaction in redis: %s", member)
+		}
+		counts[reactionType]++
+	}
+
+	return counts, nil
+}
+
+// ReactionTypes returns the reaction types of a meeting. Returns nil, if the
+// meeting has no own types.
+func (r *Redis) ReactionTypes(meetingID int) ([]string, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	encoded, err := redis.Bytes(conn.Do("HGET", reactionTypesKey, meetingID))
+	if err != nil {
+		if err == redis.ErrNil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("getting reaction types from redis: %w", err)
+	}
+
+	var types []string
+	if err := json.Unmarshal(encoded, &types); err != nil {
+		return nil, fmt.Errorf("decoding reaction types: %w", err)
+	}
+	return types, nil
+}
+
+// ReactionSetTypes sets the reaction types of a meeting. An empty list removes
+// the types of the meeting.
+//
+// The meeting id is added to the reaction types stream.
+func (r *Redis) ReactionSetTypes(meetingID int, types []string) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	var encoded []byte
+	if len(types) > 0 {
+		var err error
+		encoded, err = json.Marshal(types)
+		if err != nil {
+			return fmt.Errorf("encoding reaction types: %w", err)
+		}
+	}
+
+	_, err := reactionSetTypesScript.Do(
+		conn,
+		reactionTypesKey,
+		reactionTypesChangedKey,
+		meetingID,
+		encoded,
+		reactionChangedMaxLen,
+	)
+	if err != nil {
+		return fmt.Errorf("running reaction set types script: %w", err)
+	}
+	return nil
+}
+
+// ReactionTypesChanged is a blocking function that returns the id of a
+// meeting, where the reaction types changed.
+//
+// It is expected, that only one goroutine is calling this function.
+func (r *Redis) ReactionTypesChanged(ctx context.Context) (int, error) {
+	meetingID, err := r.readMeetingStream(ctx, reactionTypesChangedKey, &r.lastReactionTypesID)
+	if err != nil {
+		return 0, fmt.Errorf("read reaction types change: %w", err)
+	}
+	return meetingID, nil
+}
+
+// RateLimitTake takes one token from each bucket with the given keys.
+//
+// Returns 0, if the tokens were taken. If one of the buckets is empty, no token
+// is taken and it returns the duration until all buckets have a token.
+func (r *Redis) RateLimitTake(keys []string, rates []float64, bursts []int) (time.Duration, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	args := make([]any, 0, 1+len(keys)*3)
+	args = append(args, len(keys))
+	for _, key := range keys {
+		args = append(args, rateLimitPrefix+key)
+	}
+	for i := range keys {
+		args = append(args, rates[i], bursts[i])
+	}
+
+	wait, err := redis.Int64(rateLimitScript.Do(conn, args...))
+	if err != nil {
+		return 0, fmt.Errorf("running rate limit script: %w", err)
+	}
+
+	return time.Duration(wait) * time.Millisecond, nil
+}
@@ -3,14 +3,17 @@ package redis_test
 import (
 	"context"
 	"errors"
+	"reflect"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/OpenSlides/openslides-icc-service/internal/redis"
+	redigo "github.com/gomodule/redigo/redis"
 	"github.com/ory/dockertest/v3"
 )
 
-func startRedis(t *testing.T) (string, func()) {
+func startRedis(t testing.TB) (string, func()) {
 	t.Helper()
 
 	pool, err := dockertest.NewPool("")
@@ -40,7 +43,7 @@ func TestICC(t *testing.T) {
 	t.Run("Receive blocks", func(t *testing.T) {
 		done := make(chan error)
 		go func() {
-			_, err := redisConn.NotifyReceive(t.Context())
+			_, _, err := redisConn.NotifyReceive(t.Context())
 			done <- err
 		}()
 
@@ -59,7 +62,7 @@ func TestICC(t *testing.T) {
 
 		done := make(chan error)
 		go func() {
-			_, err := redisConn.NotifyReceive(ctx)
+			_, _, err := redisConn.NotifyReceive(ctx)
 			done <- err
 		}()
 
@@ -85,7 +88,7 @@ func TestICC(t *testing.T) {
 
 		done := make(chan receiveReturn)
 		go func() {
-			message, err := redisConn.NotifyReceive(t.Context())
+			_, message, err := redisConn.NotifyReceive(t.Context())
 			done <- receiveReturn{message, err}
 		}()
 
@@ -112,163 +115,1013 @@ func TestICC(t *testing.T) {
 		}
 	})
 
-	t.Run("Receive empty applause", func(t *testing.T) {
-		applause, err := redisConn.ApplauseSince(1000)
+	t.Run("Receive skips malformed entries", func(t *testing.T) {
+		conn, err := redigo.Dial("tcp", "localhost:"+port)
+		if err != nil {
+			t.Fatalf("connecting to redis: %v", err)
+		}
+		defer conn.Close()
+
+		type receiveReturn struct {
+			id      string
+			message []byte
+			err     error
+		}
+
+		done := make(chan receiveReturn, 2)
+		go func() {
+			for range 2 {
+				id, message, err := redisConn.NotifyReceive(t.Context())
+				done <- receiveReturn{id, message, err}
+			}
+		}()
+
+		// Wait for NotifyReceive to be called.
+		time.Sleep(10 * time.Millisecond)
 
+		if _, err := conn.Do("XADD", "icc-notify", "*", "unknown", "value"); err != nil {
+			t.Fatalf("adding malformed entry: %v", err)
+		}
+
+		if err := redisConn.NotifyPublish([]byte("valid message")); err != nil {
+			t.Fatalf("NotifyPublish: %v", err)
+		}
+
+		for i, expectErr := range []bool{true, false} {
+			select {
+			case data := <-done:
+				if data.id == "" {
+					t.Errorf("NotifyReceive call %d returned no id", i)
+				}
+
+				if (data.err != nil) != expectErr {
+					t.Errorf("NotifyReceive call %d returned error `%v`, expected error: %t", i, data.err, expectErr)
+				}
+
+				if !expectErr && string(data.message) != "valid message" {
+					t.Errorf("NotifyReceive call %d returned message `%s`, expected `valid message`", i, data.message)
+				}
+
+			case <-time.After(50 * time.Millisecond):
+				t.Fatalf("NotifyReceive call %d did not return", i)
+			}
+		}
+
+		// The change streams skip malformed entries.
+		changed := make(chan int, 1)
+		go func() {
+			meetingID, err := redisConn.HandChanged(t.Context())
+			if err != nil {
+				t.Errorf("HandChanged returned unexpected error: %v", err)
+			}
+			changed <- meetingID
+		}()
+
+		time.Sleep(10 * time.Millisecond)
+
+		if _, err := conn.Do("XADD", "icc-hand-changed", "*", "unknown", "value"); err != nil {
+			t.Fatalf("adding malformed entry: %v", err)
+		}
+
+		if err := redisConn.HandRaise(7, 1, 1); err != nil {
+			t.Fatalf("HandRaise: %v", err)
+		}
+
+		select {
+		case meetingID := <-changed:
+			if meetingID != 7 {
+				t.Errorf("HandChanged returned meeting %d, expected 7", meetingID)
+			}
+
+		case <-time.After(50 * time.Millisecond):
+			t.Fatalf("HandChanged did not return after a malformed entry")
+		}
+	})
+
+	t.Run("Count empty applause", func(t *testing.T) {
+		count, err := redisConn.ApplauseCount(1, 0)
 		if err != nil {
-			t.Fatalf("receiveApplause returned unexpected error: %v", err)
+			t.Fatalf("ApplauseCount returned unexpected error: %v", err)
 		}
 
-		if len(applause) != 0 {
-			t.Errorf("receiveApplause returned %d, expected 0", applause)
+		if count != 0 {
+			t.Errorf("ApplauseCount returned %d, expected 0", count)
 		}
 	})
 
-	t.Run("Delete applause", func(t *testing.T) {
-		if err := redisConn.ApplausePublish(1, 1, 10); err != nil {
+	t.Run("Count applause", func(t *testing.T) {
+		for _, applause := range [][2]int{{1, 100}, {2, 100}, {1, 105}} {
+			if err := redisConn.ApplausePublish(10, applause[0], int64(applause[1]), ""); err != nil {
+				t.Fatalf("sending applause: %v", err)
+			}
+		}
+
+		for _, tt := range []struct {
+			since  int64
+			expect int
+		}{
+			{100, 2},
+			{101, 1},
+			{106, 0},
+		} {
+			count, err := redisConn.ApplauseCount(10, tt.since)
+			if err != nil {
+				t.Fatalf("ApplauseCount returned unexpected error: %v", err)
+			}
+
+			if count != tt.expect {
+				t.Errorf("ApplauseCount since %d returned %d, expected %d", tt.since, count, tt.expect)
+			}
+		}
+	})
+
+	t.Run("Count applause in two meetings", func(t *testing.T) {
+		if err := redisConn.ApplausePublish(11, 1, 100, ""); err != nil {
+			t.Fatalf("sending applause: %v", err)
+		}
+
+		if err := redisConn.ApplausePublish(12, 1, 100, ""); err != nil {
 			t.Fatalf("sending applause: %v", err)
 		}
 
-		if err := redisConn.ApplauseCleanOld(100); err != nil {
-			t.Fatalf("deleting old applause: %v", err)
+		for _, meetingID := range []int{11, 12} {
+			count, err := redisConn.ApplauseCount(meetingID, 100)
+			if err != nil {
+				t.Fatalf("ApplauseCount returned unexpected error: %v", err)
+			}
+
+			if count != 1 {
+				t.Errorf("ApplauseCount for meeting %d returned %d, expected 1", meetingID, count)
+			}
 		}
+	})
 
-		applause, err := redisConn.ApplauseSince(10)
+	t.Run("Remove old applause", func(t *testing.T) {
+		if err := redisConn.ApplausePublish(13, 1, 100, ""); err != nil {
+			t.Fatalf("sending applause: %v", err)
+		}
 
+		if err := redisConn.ApplausePublish(13, 2, 100+int64(time.Hour.Seconds())+1, ""); err != nil {
+			t.Fatalf("sending applause: %v", err)
+		}
+
+		count, err := redisConn.ApplauseCount(13, 0)
 		if err != nil {
-			t.Fatalf("receiveApplause returned unexpected error: %v", err)
+			t.Fatalf("ApplauseCount returned unexpected error: %v", err)
 		}
 
-		if len(applause) != 0 {
-			t.Errorf("receiveApplause returned %d, expected 0", applause)
+		if count != 1 {
+			t.Errorf("ApplauseCount returned %d, expected 1", count)
 		}
 	})
 
-	t.Run("Delete not new applause", func(t *testing.T) {
-		defer redisConn.ApplauseCleanOld(1000)
+	t.Run("Applause changed", func(t *testing.T) {
+		type changedReturn struct {
+			meetingID int
+			err       error
+		}
 
-		if err := redisConn.ApplausePublish(1, 1, 10); err != nil {
-			t.Fatalf("sending applause: %v", err)
+		done := make(chan changedReturn, 1)
+		go func() {
+			meetingID, err := redisConn.ApplauseChanged(t.Context())
+			done <- changedReturn{meetingID, err}
+		}()
+
+		// Wait for ApplauseChanged to be called.
+		time.Sleep(10 * time.Millisecond)
+
+		for userID := range 3 {
+			if err := redisConn.ApplausePublish(14, userID+1, 100, ""); err != nil {
+				t.Fatalf("sending applause: %v", err)
+			}
 		}
 
-		if err := redisConn.ApplauseCleanOld(10); err != nil {
-			t.Fatalf("deleting old applause: %v", err)
+		timer := time.NewTimer(50 * time.Millisecond)
+		defer timer.Stop()
+
+		select {
+		case data := <-done:
+			if data.err != nil {
+				t.Fatalf("ApplauseChanged returned unexpected error: %v", data.err)
+			}
+
+			if data.meetingID != 14 {
+				t.Errorf("ApplauseChanged returned meeting %d, expected 14", data.meetingID)
+			}
+
+		case <-timer.C:
+			t.Fatalf("ApplauseChanged did not unblock after applause was send.")
 		}
 
-		applause, err := redisConn.ApplauseSince(10)
+		// The other applause in the same meeting does not create a
+		// notification.
+		ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+		defer cancel()
 
+		if _, err := redisConn.ApplauseChanged(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("ApplauseChanged returned %v, expected context.DeadlineExceeded", err)
+		}
+	})
+
+	t.Run("Applause particles", func(t *testing.T) {
+		for _, applause := range []struct {
+			time     int64
+			particle string
+		}{
+			{100, "heart"},
+			{100, "heart"},
+			{100, ""},
+			{101, "star"},
+			{102, "heart"},
+		} {
+			if err := redisConn.ApplausePublish(30, 1, applause.time, applause.particle); err != nil {
+				t.Fatalf("sending applause: %v", err)
+			}
+		}
+
+		particles, err := redisConn.ApplauseParticles(30, 100, 102)
 		if err != nil {
-			t.Fatalf("receiveApplause returned unexpected error: %v", err)
+			t.Fatalf("ApplauseParticles returned unexpected error: %v", err)
 		}
 
-		if len(applause) != 1 {
-			t.Errorf("receiveApplause returned %d, expected 1", applause)
+		expect := map[string]int{"heart": 2, "star": 1}
+		if !reflect.DeepEqual(particles, expect) {
+			t.Errorf("ApplauseParticles returned %v, expected %v", particles, expect)
 		}
 	})
 
-	t.Run("Receive applause for one user", func(t *testing.T) {
-		defer redisConn.ApplauseCleanOld(1000)
+	t.Run("Held applause", func(t *testing.T) {
+		if err := redisConn.ApplauseStart(20, 1, 100, 110); err != nil {
+			t.Fatalf("ApplauseStart returned unexpected error: %v", err)
+		}
 
-		if err := redisConn.ApplausePublish(1, 1, 10); err != nil {
-			t.Fatalf("sending applause: %v", err)
+		// Starting again keeps the start time.
+		if err := redisConn.ApplauseStart(20, 1, 105, 115); err != nil {
+			t.Fatalf("ApplauseStart returned unexpected error: %v", err)
 		}
 
-		applause, err := redisConn.ApplauseSince(10)
+		if err := redisConn.ApplauseStart(20, 2, 101, 111); err != nil {
+			t.Fatalf("ApplauseStart returned unexpected error: %v", err)
+		}
+
+		if err := redisConn.ApplauseStart(20, 3, 102, 112); err != nil {
+			t.Fatalf("ApplauseStart returned unexpected error: %v", err)
+		}
 
+		if err := redisConn.ApplauseStop(20, 3); err != nil {
+			t.Fatalf("ApplauseStop returned unexpected error: %v", err)
+		}
+
+		starts, err := redisConn.ApplauseHolders(20, 111)
+		if err != nil {
+			t.Fatalf("ApplauseHolders returned unexpected error: %v", err)
+		}
+
+		if !reflect.DeepEqual(starts, []int64{100}) {
+			t.Errorf("ApplauseHolders returned %v, expected [100]", starts)
+		}
+
+		// User 1 holds the button, so only the applause of user 2 is counted.
+		for _, userID := range []int{1, 2} {
+			if err := redisConn.ApplausePublish(20, userID, 111, ""); err != nil {
+				t.Fatalf("ApplausePublish returned unexpected error: %v", err)
+			}
+		}
+
+		count, err := redisConn.ApplauseCount(20, 111)
 		if err != nil {
-			t.Fatalf("receiveApplause returned unexpected error: %v", err)
+			t.Fatalf("ApplauseCount returned unexpected error: %v", err)
 		}
 
-		if applause[1] != 1 {
-			t.Errorf("receiveApplause returned %d, expected 1", applause)
+		if count != 1 {
+			t.Errorf("ApplauseCount returned %d, expected 1", count)
 		}
 	})
 
-	t.Run("Receive applause for one user twice", func(t *testing.T) {
-		defer redisConn.ApplauseCleanOld(1000)
+	t.Run("Applause history", func(t *testing.T) {
+		for _, entry := range []struct {
+			time  int64
+			state string
+			entry string
+		}{
+			{100, "a", "first"},
+			{101, "b", "second"},
+			{101, "c", "replaced"},
+			{102, "c", "unchanged"},
+			{99, "d", "too old"},
+		} {
+			if err := redisConn.ApplauseRecord(1, entry.time, entry.state, []byte(entry.entry)); err != nil {
+				t.Fatalf("ApplauseRecord returned unexpected error: %v", err)
+			}
+		}
 
-		if err := redisConn.ApplausePublish(1, 1, 10); err != nil {
-			t.Fatalf("sending applause: %v", err)
+		history, err := redisConn.ApplauseHistory(1)
+		if err != nil {
+			t.Fatalf("ApplauseHistory returned unexpected error: %v", err)
 		}
 
-		if err := redisConn.ApplausePublish(1, 1, 11); err != nil {
-			t.Fatalf("sending applause: %v", err)
+		expect := map[int64][]byte{100: []byte("first"), 101: []byte("replaced")}
+		if !reflect.DeepEqual(history, expect) {
+			t.Errorf("ApplauseHistory returned %v, expected %v", history, expect)
+		}
+	})
+
+	t.Run("Applause thresholds", func(t *testing.T) {
+		thresholds, err := redisConn.ApplauseThresholds(1)
+		if err != nil {
+			t.Fatalf("ApplauseThresholds returned unexpected error: %v", err)
+		}
+
+		if thresholds != nil {
+			t.Errorf("ApplauseThresholds returned %s for a meeting without thresholds, expected nil", thresholds)
+		}
+
+		changed := make(chan int, 1)
+		go func() {
+			meetingID, err := redisConn.ApplauseThresholdsChanged(t.Context())
+			if err != nil {
+				t.Errorf("ApplauseThresholdsChanged returned unexpected error: %v", err)
+			}
+			changed <- meetingID
+		}()
+
+		// Wait for ApplauseThresholdsChanged to be called.
+		time.Sleep(10 * time.Millisecond)
+
+		if err := redisConn.ApplauseSetThresholds(1, []byte(`{"start":20,"peak":60}`)); err != nil {
+			t.Fatalf("ApplauseSetThresholds returned unexpected error: %v", err)
 		}
 
-		applause, err := redisConn.ApplauseSince(10)
+		select {
+		case meetingID := <-changed:
+			if meetingID != 1 {
+				t.Errorf("ApplauseThresholdsChanged returned meeting %d, expected 1", meetingID)
+			}
 
+		case <-time.After(50 * time.Millisecond):
+			t.Fatalf("ApplauseThresholdsChanged did not return after the thresholds changed")
+		}
+
+		thresholds, err = redisConn.ApplauseThresholds(1)
 		if err != nil {
-			t.Fatalf("receiveApplause returned unexpected error: %v", err)
+			t.Fatalf("ApplauseThresholds returned unexpected error: %v", err)
 		}
 
-		if applause[1] != 1 {
-			t.Errorf("receiveApplause returned %d, expected 1", applause)
+		if got := string(thresholds); got != `{"start":20,"peak":60}` {
+			t.Errorf("ApplauseThresholds returned %s", got)
 		}
 	})
 
-	t.Run("Receive applause for one user to old", func(t *testing.T) {
-		defer redisConn.ApplauseCleanOld(1000)
+	t.Run("Applause event first", func(t *testing.T) {
+		for i, tt := range []struct {
+			event       string
+			start       int64
+			expectStart int64
+			expectFirst bool
+		}{
+			{"applause-started", 100, 100, true},
+			{"applause-started", 101, 100, false},
+			{"applause-peak", 100, 100, true},
+			{"applause-peak", 100, 100, false},
+			{"applause-ended", 99, 100, false},
+			{"applause-ended", 100, 100, true},
+			{"applause-ended", 100, 0, false},
+			{"applause-started", 110, 110, true},
+		} {
+			start, first, err := redisConn.ApplauseEventFirst(1, tt.event, tt.start)
+			if err != nil {
+				t.Fatalf("ApplauseEventFirst returned unexpected error: %v", err)
+			}
 
-		if err := redisConn.ApplausePublish(1, 1, 9); err != nil {
-			t.Fatalf("sending applause: %v", err)
+			if start != tt.expectStart || first != tt.expectFirst {
+				t.Errorf("ApplauseEventFirst call %d returned (%d, %t), expected (%d, %t)", i, start, first, tt.expectStart, tt.expectFirst)
+			}
 		}
+	})
 
-		applause, err := redisConn.ApplauseSince(10)
+	t.Run("Hand queue", func(t *testing.T) {
+		type changedReturn struct {
+			meetingID int
+			err       error
+		}
+
+		done := make(chan changedReturn, 1)
+		go func() {
+			meetingID, err := redisConn.HandChanged(t.Context())
+			done <- changedReturn{meetingID, err}
+		}()
+
+		// Wait for HandChanged to be called.
+		time.Sleep(10 * time.Millisecond)
+
+		for _, raise := range []struct {
+			userID int
+			time   int64
+		}{
+			{10, 1700000000001},
+			{9, 1700000000002},
+			{10, 1700000000003},
+			{11, 1700000000004},
+		} {
+			if err := redisConn.HandRaise(1, raise.userID, raise.time); err != nil {
+				t.Fatalf("HandRaise returned unexpected error: %v", err)
+			}
+		}
+
+		select {
+		case data := <-done:
+			if data.err != nil {
+				t.Fatalf("HandChanged returned unexpected error: %v", data.err)
+			}
+
+			if data.meetingID != 1 {
+				t.Errorf("HandChanged returned meeting %d, expected 1", data.meetingID)
+			}
+
+		case <-time.After(50 * time.Millisecond):
+			t.Fatalf("HandChanged did not unblock after a hand was raised.")
+		}
+
+		if err := redisConn.HandLower(1, 9); err != nil {
+			t.Fatalf("HandLower returned unexpected error: %v", err)
+		}
+
+		userIDs, raisedAt, err := redisConn.HandQueue(1)
+		if err != nil {
+			t.Fatalf("HandQueue returned unexpected error: %v", err)
+		}
+
+		if !reflect.DeepEqual(userIDs, []int{10, 11}) || !reflect.DeepEqual(raisedAt, []int64{1700000000001, 1700000000004}) {
+			t.Errorf("HandQueue returned %v and %v, expected [10 11] and [1700000000001 1700000000004]", userIDs, raisedAt)
+		}
+
+		if err := redisConn.HandClear(1); err != nil {
+			t.Fatalf("HandClear returned unexpected error: %v", err)
+		}
 
+		userIDs, _, err = redisConn.HandQueue(1)
 		if err != nil {
-			t.Fatalf("receiveApplause returned unexpected error: %v", err)
+			t.Fatalf("HandQueue returned unexpected error: %v", err)
 		}
 
-		if applause[1] != 0 {
-			t.Errorf("receiveApplause returned %d, expected 0", applause)
+		if len(userIDs) != 0 {
+			t.Errorf("HandQueue returned %v after clear, expected an empty queue", userIDs)
 		}
 	})
 
-	t.Run("Receive applause for two users", func(t *testing.T) {
-		defer redisConn.ApplauseCleanOld(1000)
+	t.Run("Quick poll", func(t *testing.T) {
+		poll, err := redisConn.PollCurrent(1)
+		if err != nil {
+			t.Fatalf("PollCurrent returned unexpected error: %v", err)
+		}
 
-		if err := redisConn.ApplausePublish(1, 1, 10); err != nil {
-			t.Fatalf("sending applause: %v", err)
+		if poll != nil {
+			t.Errorf("PollCurrent returned %s for a meeting without poll, expected nil", poll)
 		}
 
-		if err := redisConn.ApplausePublish(1, 2, 10); err != nil {
-			t.Fatalf("sending applause: %v", err)
+		type changedReturn struct {
+			meetingID int
+			err       error
+		}
+
+		done := make(chan changedReturn, 1)
+		go func() {
+			meetingID, err := redisConn.PollChanged(t.Context())
+			done <- changedReturn{meetingID, err}
+		}()
+
+		// Wait for PollChanged to be called.
+		time.Sleep(10 * time.Millisecond)
+
+		closesAt := time.Now().Add(time.Minute).Unix()
+		opened, err := redisConn.PollOpen(1, []byte(`{"id":"abc"}`), closesAt, closesAt)
+		if err != nil {
+			t.Fatalf("PollOpen returned unexpected error: %v", err)
+		}
+
+		if !opened {
+			t.Errorf("PollOpen returned false for a meeting without a poll")
+		}
+
+		select {
+		case data := <-done:
+			if data.err != nil {
+				t.Fatalf("PollChanged returned unexpected error: %v", data.err)
+			}
+
+			if data.meetingID != 1 {
+				t.Errorf("PollChanged returned meeting %d, expected 1", data.meetingID)
+			}
+
+		case <-time.After(50 * time.Millisecond):
+			t.Fatalf("PollChanged did not unblock after a poll was opened.")
+		}
+
+		poll, err = redisConn.PollCurrent(1)
+		if err != nil {
+			t.Fatalf("PollCurrent returned unexpected error: %v", err)
 		}
 
-		applause, err := redisConn.ApplauseSince(10)
+		if string(poll) != `{"id":"abc"}` {
+			t.Errorf("PollCurrent returned %s", poll)
+		}
 
+		opened, err = redisConn.PollOpen(1, []byte(`{"id":"def"}`), closesAt, closesAt)
 		if err != nil {
-			t.Fatalf("receiveApplause returned unexpected error: %v", err)
+			t.Fatalf("PollOpen returned unexpected error: %v", err)
+		}
+
+		if opened {
+			t.Errorf("PollOpen returned true for a meeting with an open poll")
 		}
 
-		if applause[1] != 2 {
-			t.Errorf("receiveApplause returned %d, expected 2", applause)
+		for _, vote := range []struct {
+			userID int
+			option string
+		}{
+			{1, "yes"},
+			{2, "no"},
+			{1, "no"},
+			{3, "yes"},
+		} {
+			if err := redisConn.PollVote(1, "abc", vote.userID, vote.option); err != nil {
+				t.Fatalf("PollVote returned unexpected error: %v", err)
+			}
+		}
+
+		counts, err := redisConn.PollCounts(1, "abc")
+		if err != nil {
+			t.Fatalf("PollCounts returned unexpected error: %v", err)
+		}
+
+		if expect := map[string]int{"yes": 2, "no": 1}; !reflect.DeepEqual(counts, expect) {
+			t.Errorf("PollCounts returned %v, expected %v", counts, expect)
+		}
+
+		for i, expect := range []bool{true, false} {
+			first, err := redisConn.PollClose(1, "abc")
+			if err != nil {
+				t.Fatalf("PollClose returned unexpected error: %v", err)
+			}
+
+			if first != expect {
+				t.Errorf("PollClose call %d returned %t, expected %t", i, first, expect)
+			}
 		}
 	})
 
-	t.Run("Receive applause for one user in two meetings", func(t *testing.T) {
-		defer redisConn.ApplauseCleanOld(1000)
+	t.Run("Shared state", func(t *testing.T) {
+		done := make(chan []byte, 1)
+		go func() {
+			change, err := redisConn.StateChanged(t.Context())
+			if err != nil {
+				t.Errorf("StateChanged returned unexpected error: %v", err)
+			}
+			done <- change
+		}()
 
-		if err := redisConn.ApplausePublish(1, 1, 10); err != nil {
-			t.Fatalf("sending applause: %v", err)
+		// Wait for StateChanged to be called.
+		time.Sleep(10 * time.Millisecond)
+
+		expiresAt := time.Now().Add(time.Minute).UnixMilli()
+		version, ok, err := redisConn.StateSet(1, "presenter", []byte(`{"slide":3}`), "server:1:1", 0, expiresAt)
+		if err != nil {
+			t.Fatalf("StateSet returned unexpected error: %v", err)
 		}
 
-		if err := redisConn.ApplausePublish(2, 2, 10); err != nil {
-			t.Fatalf("sending applause: %v", err)
+		if !ok || version != 1 {
+			t.Errorf("StateSet returned version %d and %t, expected 1 and true", version, ok)
+		}
+
+		select {
+		case change := <-done:
+			expect := `{"meeting_id":1,"key":"presenter","version":1,"entry":{"value":{"slide":3},"version":1,"owner":"server:1:1"}}`
+			if string(change) != expect {
+				t.Errorf("StateChanged returned %s, expected %s", change, expect)
+			}
+
+		case <-time.After(50 * time.Millisecond):
+			t.Fatalf("StateChanged did not unblock after a key was set.")
+		}
+
+		// The key exists, so version 0 is a conflict.
+		version, ok, err = redisConn.StateSet(1, "presenter", []byte(`4`), "server:1:2", 0, expiresAt)
+		if err != nil {
+			t.Fatalf("StateSet returned unexpected error: %v", err)
+		}
+
+		if ok || version != 1 {
+			t.Errorf("StateSet with wrong version returned version %d and %t, expected 1 and false", version, ok)
+		}
+
+		if _, _, err := redisConn.StateSet(1, "editing", []byte(`true`), "server:1:2", -1, expiresAt); err != nil {
+			t.Fatalf("StateSet returned unexpected error: %v", err)
+		}
+
+		version, entries, err := redisConn.StateSnapshot(1)
+		if err != nil {
+			t.Fatalf("StateSnapshot returned unexpected error: %v", err)
+		}
+
+		if version != 2 || len(entries) != 2 {
+			t.Errorf("StateSnapshot returned version %d with %d entries, expected 2 with 2 entries", version, len(entries))
+		}
+
+		if ok, err := redisConn.StateDelete(1, "editing", 1); err != nil || ok {
+			t.Errorf("StateDelete with wrong version returned %t, %v, expected false", ok, err)
+		}
+
+		if err := redisConn.StateRelease("server:1:1"); err != nil {
+			t.Fatalf("StateRelease returned unexpected error: %v", err)
+		}
+
+		if err := redisConn.StateExpire(expiresAt + 1); err != nil {
+			t.Fatalf("StateExpire returned unexpected error: %v", err)
+		}
+
+		version, entries, err = redisConn.StateSnapshot(1)
+		if err != nil {
+			t.Fatalf("StateSnapshot returned unexpected error: %v", err)
+		}
+
+		if version != 4 || len(entries) != 0 {
+			t.Errorf("StateSnapshot after release returned version %d with %v, expected 4 without entries", version, entries)
+		}
+	})
+
+	t.Run("Rate limit", func(t *testing.T) {
+		take := func(keys ...string) time.Duration {
+			t.Helper()
+
+			rates := make([]float64, len(keys))
+			bursts := make([]int, len(keys))
+			for i := range keys {
+				rates[i] = 1
+				bursts[i] = 2
+			}
+
+			wait, err := redisConn.RateLimitTake(keys, rates, bursts)
+			if err != nil {
+				t.Fatalf("RateLimitTake returned unexpected error: %v", err)
+			}
+			return wait
+		}
+
+		for i := range 2 {
+			if wait := take("test"); wait != 0 {
+				t.Fatalf("RateLimitTake %d returned %s, expected 0", i, wait)
+			}
+		}
+
+		if wait := take("test"); wait <= 0 || wait > time.Second {
+			t.Errorf("RateLimitTake returned %s, expected a duration up to one second", wait)
+		}
+
+		if wait := take("other", "test"); wait <= 0 {
+			t.Errorf("RateLimitTake with an empty bucket returned %s, expected a duration", wait)
+		}
+
+		for i := range 2 {
+			if wait := take("other"); wait != 0 {
+				t.Errorf("RateLimitTake %d for other key returned %s, expected 0", i, wait)
+			}
+		}
+	})
+	t.Run("Retain notify message", func(t *testing.T) {
+		if err := redisConn.NotifyRetain(1, "screen", []byte("first"), false); err != nil {
+			t.Fatalf("NotifyRetain returned unexpected error: %v", err)
+		}
+
+		if err := redisConn.NotifyRetain(1, "screen", []byte("second"), false); err != nil {
+			t.Fatalf("NotifyRetain returned unexpected error: %v", err)
+		}
+
+		ids, retained, err := redisConn.NotifyRetained(1)
+		if err != nil {
+			t.Fatalf("NotifyRetained returned unexpected error: %v", err)
+		}
+
+		if len(retained) != 1 || string(retained[0]) != "second" {
+			t.Errorf("NotifyRetained returned %q, expected [second]", retained)
 		}
 
-		applause, err := redisConn.ApplauseSince(10)
+		conn, err := redigo.Dial("tcp", "localhost:"+port)
+		if err != nil {
+			t.Fatalf("connecting to redis: %v", err)
+		}
+		defer conn.Close()
+
+		entries, err := redigo.Values(conn.Do("XREVRANGE", "icc-notify", "+", "-", "COUNT", 1))
+		if err != nil || len(entries) != 1 {
+			t.Fatalf("reading the last notify message: %v", err)
+		}
 
+		lastID, err := redigo.String(entries[0].([]any)[0], nil)
 		if err != nil {
-			t.Fatalf("receiveApplause returned unexpected error: %v", err)
+			t.Fatalf("reading the id of the last notify message: %v", err)
+		}
+
+		if len(ids) != 1 || ids[0] != lastID {
+			t.Errorf("NotifyRetained returned the ids %v, expected [%s]", ids, lastID)
+		}
+
+		ttl, err := redigo.Int(conn.Do("PTTL", "icc-notify-retained:1"))
+		if err != nil {
+			t.Fatalf("PTTL returned unexpected error: %v", err)
+		}
+
+		if ttl <= 0 {
+			t.Errorf("retained messages have the ttl %d, expected an expire time", ttl)
+		}
+
+		if err := redisConn.NotifyRetain(1, "screen", []byte("clear"), true); err != nil {
+			t.Fatalf("NotifyRetain returned unexpected error: %v", err)
 		}
 
-		if applause[1] != 1 {
-			t.Errorf("receiveApplause returned %d, expected 2", applause)
+		_, retained, err = redisConn.NotifyRetained(1)
+		if err != nil {
+			t.Fatalf("NotifyRetained returned unexpected error: %v", err)
 		}
 
-		if applause[2] != 1 {
-			t.Errorf("receiveApplause returned %d, expected 2", applause)
+		if len(retained) != 0 {
+			t.Errorf("NotifyRetained returned %q, expected nothing", retained)
 		}
 	})
+	t.Run("Publish scheduled notify message", func(t *testing.T) {
+		if err := redisConn.NotifySchedule([]byte("scheduled message"), 100); err != nil {
+			t.Fatalf("NotifySchedule returned unexpected error: %v", err)
+		}
+
+		type receiveReturn struct {
+			message []byte
+			err     error
+		}
+
+		done := make(chan receiveReturn)
+		go func() {
+			_, message, err := redisConn.NotifyReceive(t.Context())
+			done <- receiveReturn{message, err}
+		}()
+
+		// Wait for NotifyReceive to be called.
+		time.Sleep(10 * time.Millisecond)
+
+		if err := redisConn.NotifyPublishDue(99); err != nil {
+			t.Fatalf("NotifyPublishDue returned unexpected error: %v", err)
+		}
+
+		select {
+		case <-done:
+			t.Fatalf("NotifyReceive returned before the message was due")
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		if err := redisConn.NotifyPublishDue(100); err != nil {
+			t.Fatalf("NotifyPublishDue returned unexpected error: %v", err)
+		}
+
+		select {
+		case data := <-done:
+			if err := data.err; err != nil {
+				t.Errorf("NotifyReceive returned unexpected error: %v", err)
+			}
+
+			if string(data.message) != "scheduled message" {
+				t.Errorf("NotifyReceive returned message `%s`, expected `scheduled message`", data.message)
+			}
+
+		case <-time.After(50 * time.Millisecond):
+			t.Errorf("NotifyReceive did not unblock after the message was due.")
+		}
+	})
+	t.Run("Publish same scheduled notify message twice", func(t *testing.T) {
+		for range 2 {
+			if err := redisConn.NotifySchedule([]byte("same message"), 100); err != nil {
+				t.Fatalf("NotifySchedule returned unexpected error: %v", err)
+			}
+		}
+
+		if err := redisConn.NotifyPublishDue(100); err != nil {
+			t.Fatalf("NotifyPublishDue returned unexpected error: %v", err)
+		}
+
+		ctx, cancel := context.WithTimeout(t.Context(), time.Second)
+		defer cancel()
+
+		for i := range 2 {
+			_, message, err := redisConn.NotifyReceive(ctx)
+			if err != nil {
+				t.Fatalf("NotifyReceive %d returned unexpected error: %v", i, err)
+			}
+
+			if string(message) != "same message" {
+				t.Errorf("NotifyReceive %d returned message `%s`, expected `same message`", i, message)
+			}
+		}
+	})
+	t.Run("Notify message id", func(t *testing.T) {
+		first, err := redisConn.NotifyFirstSeen(1, "abc", time.Minute)
+		if err != nil {
+			t.Fatalf("NotifyFirstSeen returned unexpected error: %v", err)
+		}
+
+		if !first {
+			t.Errorf("NotifyFirstSeen returned false for a new message id")
+		}
+
+		first, err = redisConn.NotifyFirstSeen(1, "abc", time.Minute)
+		if err != nil {
+			t.Fatalf("NotifyFirstSeen returned unexpected error: %v", err)
+		}
+
+		if first {
+			t.Errorf("NotifyFirstSeen returned true for a known message id")
+		}
+
+		first, err = redisConn.NotifyFirstSeen(2, "abc", time.Minute)
+		if err != nil {
+			t.Fatalf("NotifyFirstSeen returned unexpected error: %v", err)
+		}
+
+		if !first {
+			t.Errorf("NotifyFirstSeen returned false for a message id of another user")
+		}
+
+		if err := redisConn.NotifyForget(1, "abc"); err != nil {
+			t.Fatalf("NotifyForget returned unexpected error: %v", err)
+		}
+
+		first, err = redisConn.NotifyFirstSeen(1, "abc", time.Minute)
+		if err != nil {
+			t.Fatalf("NotifyFirstSeen returned unexpected error: %v", err)
+		}
+
+		if !first {
+			t.Errorf("NotifyFirstSeen returned false for a forgotten message id")
+		}
+	})
+	t.Run("Notify dead letter", func(t *testing.T) {
+		if err := redisConn.NotifyDeadLetter("1-0", []byte("invalid"), "decoding message"); err != nil {
+			t.Errorf("NotifyDeadLetter returned unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Reactions", func(t *testing.T) {
+		changed := make(chan int, 1)
+		go func() {
+			meetingID, err := redisConn.ReactionChanged(t.Context())
+			if err != nil {
+				t.Errorf("ReactionChanged returned unexpected error: %v", err)
+			}
+			changed <- meetingID
+		}()
+
+		// Wait for ReactionChanged to be called.
+		time.Sleep(10 * time.Millisecond)
+
+		for _, r := range []struct {
+			meetingID    int
+			userID       int
+			reactionType string
+			time         int64
+		}{
+			{1, 1, "laugh", 10},
+			{1, 1, "laugh", 11},
+			{1, 2, "laugh", 10},
+			{1, 1, "heart", 10},
+			{2, 1, "laugh", 10},
+			{1, 3, "laugh", 9},
+		} {
+			if err := redisConn.ReactionPublish(r.meetingID, r.userID, r.reactionType, r.time); err != nil {
+				t.Fatalf("ReactionPublish returned unexpected error: %v", err)
+			}
+		}
+
+		select {
+		case meetingID := <-changed:
+			if meetingID != 1 {
+				t.Errorf("ReactionChanged returned meeting %d, expected 1", meetingID)
+			}
+
+		case <-time.After(50 * time.Millisecond):
+			t.Fatalf("ReactionChanged did not return after a reaction")
+		}
+
+		for meetingID, expect := range map[int]map[string]int{
+			1: {"laugh": 2, "heart": 1},
+			2: {"laugh": 1},
+			3: {},
+		} {
+			counts, err := redisConn.ReactionCount(meetingID, 10)
+			if err != nil {
+				t.Fatalf("ReactionCount returned unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(counts, expect) {
+				t.Errorf("ReactionCount for meeting %d returned %v, expected %v", meetingID, counts, expect)
+			}
+		}
+	})
+	t.Run("Reaction types", func(t *testing.T) {
+		types, err := redisConn.ReactionTypes(1)
+		if err != nil {
+			t.Fatalf("ReactionTypes returned unexpected error: %v", err)
+		}
+
+		if types != nil {
+			t.Errorf("ReactionTypes returned %v for a meeting without types", types)
+		}
+
+		changed := make(chan int, 1)
+		go func() {
+			meetingID, err := redisConn.ReactionTypesChanged(t.Context())
+			if err != nil {
+				t.Errorf("ReactionTypesChanged returned unexpected error: %v", err)
+			}
+			changed <- meetingID
+		}()
+
+		// Wait for ReactionTypesChanged to be called.
+		time.Sleep(10 * time.Millisecond)
+
+		if err := redisConn.ReactionSetTypes(1, []string{"laugh", "heart"}); err != nil {
+			t.Fatalf("ReactionSetTypes returned unexpected error: %v", err)
+		}
+
+		select {
+		case meetingID := <-changed:
+			if meetingID != 1 {
+				t.Errorf("ReactionTypesChanged returned meeting %d, expected 1", meetingID)
+			}
+
+		case <-time.After(50 * time.Millisecond):
+			t.Fatalf("ReactionTypesChanged did not return after the types changed")
+		}
+
+		types, err = redisConn.ReactionTypes(1)
+		if err != nil {
+			t.Fatalf("ReactionTypes returned unexpected error: %v", err)
+		}
+
+		if !reflect.DeepEqual(types, []string{"laugh", "heart"}) {
+			t.Errorf("ReactionTypes returned %v, expected [laugh heart]", types)
+		}
+	})
+}
+
+// clappers is the number of users, that applause at the same time in the
+// benchmarks.
+const clappers = 10_000
+
+func BenchmarkApplausePublish(b *testing.B) {
+	port, stopRedis := startRedis(b)
+	defer stopRedis()
+
+	redisConn := redis.New("localhost:" + port)
+	redisConn.Wait(context.Background())
+
+	var userID atomic.Int64
+	now := time.Now().Unix()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			uid := int(userID.Add(1)%clappers) + 1
+			if err := redisConn.ApplausePublish(1, uid, now, ""); err != nil {
+				b.Errorf("sending applause: %v", err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkApplauseCount(b *testing.B) {
+	port, stopRedis := startRedis(b)
+	defer stopRedis()
+
+	redisConn := redis.New("localhost:" + port)
+	redisConn.Wait(context.Background())
+
+	now := time.Now().Unix()
+	for uid := range clappers {
+		if err := redisConn.ApplausePublish(1, uid+1, now, ""); err != nil {
+			b.Fatalf("sending applause: %v", err)
+		}
+	}
+
+	// An idle meeting in the same redis does not change the result.
+	if err := redisConn.ApplausePublish(2, 1, now, ""); err != nil {
+		b.Fatalf("sending applause: %v", err)
+	}
+
+	for b.Loop() {
+		count, err := redisConn.ApplauseCount(1, now-5)
+		if err != nil {
+			b.Fatalf("ApplauseCount: %v", err)
+		}
+
+		if count != clappers {
+			b.Fatalf("ApplauseCount returned %d, expected %d", count, clappers)
+		}
+	}
 }
@@ -1,6 +1,7 @@
 package redis
 
 import (
+	"encoding/json"
 	"fmt"
 )
 
@@ -47,29 +48,61 @@ func stream(reply any, err error) (string, []byte, error) {
 	if !ok {
 		return "", nil, fmt.Errorf("invalid input. Stream ID has to be a string, got %T", element[0])
 	}
+
+	// From here on, the entry id is known. It is returned with each error, so
+	// the caller can skip the invalid entry. In this case, the data is the raw
+	// entry.
 	kv, ok := element[1].([]any)
 	if !ok {
-		return "", nil, fmt.Errorf("invalid input. Key values has to be a list of strings, got %T", element[1])
+		return string(id), rawEntry(element[1]), fmt.Errorf("invalid input. Key values has to be a list of strings, got %T", element[1])
 	}
 	if len(kv)%2 != 0 {
-		return "", nil, fmt.Errorf("invalid input. Odd number of key value pairs")
+		return string(id), rawEntry(kv), fmt.Errorf("invalid input. Odd number of key value pairs")
 	}
 
+	var content []byte
 	for i := 0; i < len(kv)-1; i += 2 {
 		key, ok := kv[i].([]byte)
 		if !ok {
-			return "", nil, fmt.Errorf("invalid input. Key has to be a string, got %T", kv[i])
+			return string(id), rawEntry(kv), fmt.Errorf("invalid input. Key has to be a string, got %T", kv[i])
 		}
 		value, ok := kv[i+1].([]byte)
 		if !ok {
-			return "", nil, fmt.Errorf("invalid input. Values has to be a []byte, got %T", kv[i+1])
+			return string(id), rawEntry(kv), fmt.Errorf("invalid input. Values has to be a []byte, got %T", kv[i+1])
 		}
 		switch string(key) {
 		case "content":
-			return string(id), value, nil
+			content = value
 		default:
-			return "", nil, fmt.Errorf("invalid input. Unknown key \"%s\"", key)
+			return string(id), rawEntry(kv), fmt.Errorf("invalid input. Unknown key \"%s\"", key)
 		}
 	}
-	return "", nil, fmt.Errorf("invalid input. `content` not in response")
+
+	if content == nil {
+		return string(id), rawEntry(kv), fmt.Errorf("invalid input. `content` not in response")
+	}
+	return string(id), content, nil
+}
+
+// rawEntry encodes an invalid stream entry, so it can be saved for debugging.
+func rawEntry(entry any) []byte {
+	values, ok := entry.([]any)
+	if !ok {
+		return fmt.Appendf(nil, "%v", entry)
+	}
+
+	strs := make([]string, len(values))
+	for i, v := range values {
+		if b, ok := v.([]byte); ok {
+			strs[i] = string(b)
+			continue
+		}
+		strs[i] = fmt.Sprintf("%v", v)
+	}
+
+	encoded, err := json.Marshal(strs)
+	if err != nil {
+		return fmt.Appendf(nil, "%v", strs)
+	}
+	return encoded
 }
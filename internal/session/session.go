@@ -0,0 +1,387 @@
+// Package session closes the requests of an auth session, when the session is
+// logged out or its user is deactivated or deleted.
+//
+// The Tracker is the only reader of the logout events from the message bus. It
+// closes the requests of the logged out sessions and forwards the events to the
+// auth service.
+package session
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/OpenSlides/openslides-go/datastore/dskey"
+	"github.com/OpenSlides/openslides-go/datastore/flow"
+	"github.com/OpenSlides/openslides-icc-service/internal/dschange"
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+	"github.com/OpenSlides/openslides-icc-service/internal/icchttp"
+	"github.com/ostcar/topic"
+)
+
+// pruneTime is the time, logout events are kept for the auth service.
+const pruneTime = 10 * time.Minute
+
+// LogoutEventer returns the session ids of logged out sessions.
+//
+// The LogoutEvent function blocks until there is a logout event.
+type LogoutEventer interface {
+	LogoutEvent(context.Context) ([]string, error)
+}
+
+// Tracker knows the open requests of each session and each user.
+//
+// Has to be created with New().
+type Tracker struct {
+	messageBus LogoutEventer
+	changes    *dschange.Watcher
+	datastore  flow.Getter
+
+	mu           sync.Mutex
+	requests     map[string]map[*request]struct{}
+	userRequests map[int]map[*request]struct{}
+
+	logouts   *topic.Topic[string]
+	logoutTID uint64
+}
+
+type request struct {
+	cancel context.CancelCauseFunc
+}
+
+// Option configures the Tracker.
+type Option func(*Tracker)
+
+// WithChanges sets the watcher for datastore changes and the datastore. They
+// are used to close the requests of users, that are deactivated or deleted.
+func WithChanges(w *dschange.Watcher, datastore flow.Getter) Option {
+	return func(t *Tracker) {
+		t.changes = w
+		t.datastore = datastore
+	}
+}
+
+// New initializes a Tracker.
+//
+// The returned function has to be run in the background to receive the logout
+// events.
+func New(messageBus LogoutEventer, options ...Option) (*Tracker, func(context.Context, func(error))) {
+	t := Tracker{
+		messageBus:   messageBus,
+		requests:     make(map[string]map[*request]struct{}),
+		userRequests: make(map[int]map[*request]struct{}),
+		logouts:      topic.New[string](),
+	}
+
+	for _, o := range options {
+		o(&t)
+	}
+
+	var changeID uint64
+	if t.changes != nil {
+		changeID = t.changes.LastID()
+	}
+
+	background := func(ctx context.Context, errHandler func(error)) {
+		go t.listen(ctx, errHandler)
+		go t.pruneOldData(ctx)
+
+		if t.changes != nil {
+			go t.watchUsers(ctx, changeID, errHandler)
+		}
+	}
+
+	return &t, background
+}
+
+// listen receives the logout events from the message bus.
+func (t *Tracker) listen(ctx context.Context, errHandler func(error)) {
+	if errHandler == nil {
+		errHandler = func(error) {}
+	}
+
+	for {
+		sessionIDs, err := t.messageBus.LogoutEvent(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			errHandler(fmt.Errorf("receiving logout event: %w", err))
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		// Close the requests before the auth service gets the event. The
+		// auth service cancels the request context without a message.
+		t.logout(sessionIDs)
+		t.logouts.Publish(sessionIDs...)
+	}
+}
+
+// logout closes all requests of the given sessions.
+func (t *Tracker) logout(sessionIDs []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	err := iccerror.NewMessageError(iccerror.ErrLoggedOut, "Your session was logged out.")
+	for _, sessionID := range sessionIDs {
+		for r := range t.requests[sessionID] {
+			r.cancel(err)
+		}
+		delete(t.requests, sessionID)
+	}
+}
+
+// watchUsers closes the requests of users, that are deactivated or deleted
+// after changeID.
+func (t *Tracker) watchUsers(ctx context.Context, changeID uint64, errHandler func(error)) {
+	if errHandler == nil {
+		errHandler = func(error) {}
+	}
+
+	for {
+		var keys []dskey.Key
+		var err error
+		changeID, keys, err = t.changes.WaitFunc(ctx, changeID, isUserKey)
+		if err != nil {
+			return
+		}
+
+		userIDs := t.trackedUsers(keys)
+		if len(userIDs) == 0 {
+			continue
+		}
+
+		removed, err := removedUsers(ctx, t.datastore, userIDs)
+		if err != nil {
+			errHandler(fmt.Errorf("checking changed users: %w", err))
+			continue
+		}
+
+		t.closeUsers(removed)
+	}
+}
+
+// isUserKey returns true, if the key is changed, when a user is deactivated
+// or deleted.
+func isUserKey(key dskey.Key) bool {
+	return key.Collection() == "user" && (key.Field() == "is_active" || key.Field() == "id")
+}
+
+// trackedUsers returns the ids of the users of the keys, that have requests.
+// If keys is nil, it returns all users with requests.
+func (t *Tracker) trackedUsers(keys []dskey.Key) []int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var userIDs []int
+	if keys == nil {
+		// The changes were pruned, so it is unknown what changed.
+		for userID := range t.userRequests {
+			userIDs = append(userIDs, userID)
+		}
+		return userIDs
+	}
+
+	for _, key := range keys {
+		if _, ok := t.userRequests[key.ID()]; ok && !slices.Contains(userIDs, key.ID()) {
+			userIDs = append(userIDs, key.ID())
+		}
+	}
+	return userIDs
+}
+
+// removedUsers returns the users, that are deactivated or deleted.
+func removedUsers(ctx context.Context, getter flow.Getter, userIDs []int) ([]int, error) {
+	idKeys := make([]dskey.Key, len(userIDs))
+	activeKeys := make([]dskey.Key, len(userIDs))
+	for i, userID := range userIDs {
+		var err error
+		if idKeys[i], err = dskey.FromParts("user", userID, "id"); err != nil {
+			return nil, fmt.Errorf("building key: %w", err)
+		}
+
+		if activeKeys[i], err = dskey.FromParts("user", userID, "is_active"); err != nil {
+			return nil, fmt.Errorf("building key: %w", err)
+		}
+	}
+
+	values, err := getter.Get(ctx, append(idKeys, activeKeys...)...)
+	if err != nil {
+		return nil, fmt.Errorf("fetching users: %w", err)
+	}
+
+	var removed []int
+	for i, userID := range userIDs {
+		if values[idKeys[i]] == nil || string(values[activeKeys[i]]) == "false" {
+			removed = append(removed, userID)
+		}
+	}
+	return removed, nil
+}
+
+// closeUsers closes all requests of the given users.
+func (t *Tracker) closeUsers(userIDs []int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	err := iccerror.NewMessageError(iccerror.ErrLoggedOut, "Your account was deactivated or deleted.")
+	for _, userID := range userIDs {
+		for r := range t.userRequests[userID] {
+			r.cancel(err)
+		}
+		delete(t.userRequests, userID)
+	}
+}
+
+// pruneOldData removes old logout events.
+func (t *Tracker) pruneOldData(ctx context.Context) {
+	tick := time.NewTicker(time.Minute)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			t.logouts.Prune(time.Now().Add(-pruneTime))
+		}
+	}
+}
+
+// LogoutEvent implements the LogoutEventer interface for the auth service.
+//
+// It returns the same events as the message bus. It can only be used by one
+// caller.
+func (t *Tracker) LogoutEvent(ctx context.Context) ([]string, error) {
+	tid, sessionIDs, err := t.logouts.ReceiveSince(ctx, t.logoutTID)
+	if err != nil {
+		var errUnknownID topic.UnknownIDError
+		if !errors.As(err, &errUnknownID) {
+			return nil, err
+		}
+
+		tid, sessionIDs = t.logouts.ReceiveAll()
+	}
+
+	t.logoutTID = tid
+	return sessionIDs, nil
+}
+
+// track registers a request of a session and a user. The returned context is
+// canceled, when the session is logged out or the user is removed.
+//
+// An empty sessionID or a userID of 0 is not tracked.
+func (t *Tracker) track(ctx context.Context, sessionID string, userID int) context.Context {
+	ctx, cancel := context.WithCancelCause(ctx)
+	r := &request{cancel: cancel}
+
+	t.mu.Lock()
+	if sessionID != "" {
+		addRequest(t.requests, sessionID, r)
+	}
+	if userID != 0 {
+		addRequest(t.userRequests, userID, r)
+	}
+	t.mu.Unlock()
+
+	context.AfterFunc(ctx, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		removeRequest(t.requests, sessionID, r)
+		removeRequest(t.userRequests, userID, r)
+	})
+
+	return ctx
+}
+
+func addRequest[K comparable](requests map[K]map[*request]struct{}, key K, r *request) {
+	if requests[key] == nil {
+		requests[key] = make(map[*request]struct{})
+	}
+	requests[key][r] = struct{}{}
+}
+
+func removeRequest[K comparable](requests map[K]map[*request]struct{}, key K, r *request) {
+	delete(requests[key], r)
+	if len(requests[key]) == 0 {
+		delete(requests, key)
+	}
+}
+
+// Wrap returns an Authenticater that tracks the requests of each session.
+//
+// The context of a request is canceled with an error of the type
+// iccerror.ErrLoggedOut, when its session is logged out. If the Tracker was
+// created with WithChanges(), it is also canceled, when the user is
+// deactivated or deleted.
+func (t *Tracker) Wrap(auth icchttp.Authenticater) icchttp.Authenticater {
+	return &authenticater{
+		Authenticater: auth,
+		tracker:       t,
+	}
+}
+
+type authenticater struct {
+	icchttp.Authenticater
+	tracker *Tracker
+}
+
+func (a *authenticater) Authenticate(w http.ResponseWriter, r *http.Request) (context.Context, error) {
+	ctx, err := a.Authenticater.Authenticate(w, r)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionID := sessionIDFromRequest(w, r)
+	userID := a.Authenticater.FromContext(ctx)
+	if sessionID == "" && userID == 0 {
+		return ctx, nil
+	}
+
+	return a.tracker.track(ctx, sessionID, userID), nil
+}
+
+// sessionIDFromRequest returns the session id from the access token.
+//
+// The token is not validated, since this is done by the auth service. If the
+// token was renewed by the auth service, it is in the response header.
+func sessionIDFromRequest(w http.ResponseWriter, r *http.Request) string {
+	header := w.Header().Get("Authentication")
+	if header == "" {
+		header = r.Header.Get("Authentication")
+	}
+
+	_, token, found := strings.Cut(header, " ")
+	if !found {
+		return ""
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+
+	var claims struct {
+		SessionID string `json:"sessionId"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return ""
+	}
+
+	return claims.SessionID
+}
@@ -0,0 +1,215 @@
+package session_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OpenSlides/openslides-go/datastore/dskey"
+	"github.com/OpenSlides/openslides-go/datastore/dsmock"
+	"github.com/OpenSlides/openslides-icc-service/internal/dschange"
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+	"github.com/OpenSlides/openslides-icc-service/internal/icchttp"
+	"github.com/OpenSlides/openslides-icc-service/internal/icctest"
+	"github.com/OpenSlides/openslides-icc-service/internal/session"
+)
+
+type messageBusStub struct {
+	events chan []string
+}
+
+func newMessageBusStub() *messageBusStub {
+	return &messageBusStub{events: make(chan []string)}
+}
+
+func (m *messageBusStub) LogoutEvent(ctx context.Context) ([]string, error) {
+	select {
+	case sessionIDs := <-m.events:
+		return sessionIDs, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// token returns a fake access token for a session.
+func token(sessionID string) string {
+	payload := base64.RawURLEncoding.EncodeToString(fmt.Appendf(nil, `{"userId":1,"sessionId":"%s"}`, sessionID))
+	return "bearer header." + payload + ".signature"
+}
+
+func authenticate(t *testing.T, auth icchttp.Authenticater, sessionID string) context.Context {
+	t.Helper()
+
+	r := httptest.NewRequest("GET", "/", nil).WithContext(t.Context())
+	if sessionID != "" {
+		r.Header.Set("Authentication", token(sessionID))
+	}
+
+	ctx, err := auth.Authenticate(httptest.NewRecorder(), r)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	return ctx
+}
+
+func isDone(ctx context.Context) bool {
+	select {
+	case <-ctx.Done():
+		return true
+	case <-time.After(10 * time.Millisecond):
+		return false
+	}
+}
+
+func TestLogout(t *testing.T) {
+	bus := newMessageBusStub()
+	tracker, bg := session.New(bus)
+	go bg(t.Context(), nil)
+
+	auth := tracker.Wrap(&icctest.AutherStub{UserID: 1})
+
+	loggedOut := authenticate(t, auth, "session1")
+	loggedOut2 := authenticate(t, auth, "session1")
+	otherSession := authenticate(t, auth, "session2")
+	noSession := authenticate(t, auth, "")
+
+	bus.events <- []string{"session1"}
+
+	for i, ctx := range []context.Context{loggedOut, loggedOut2} {
+		if !isDone(ctx) {
+			t.Fatalf("request %d of the logged out session was not closed", i)
+		}
+
+		if err := context.Cause(ctx); !errors.Is(err, iccerror.ErrLoggedOut) {
+			t.Errorf("request %d was closed with `%v`, expected `%v`", i, err, iccerror.ErrLoggedOut)
+		}
+	}
+
+	if isDone(otherSession) {
+		t.Errorf("request of another session was closed")
+	}
+
+	if isDone(noSession) {
+		t.Errorf("request without a session was closed")
+	}
+}
+
+func TestRemovedUser(t *testing.T) {
+	bus := newMessageBusStub()
+	changes, changesBG := dschange.New()
+	go changesBG(t.Context(), nil)
+
+	ds := dsmock.Stub(dsmock.YAMLData(`---
+	user/1:
+		id: 1
+		is_active: false
+	user/3:
+		id: 3
+		is_active: true
+	`))
+	tracker, bg := session.New(bus, session.WithChanges(changes, ds))
+	go bg(t.Context(), nil)
+
+	deactivated := authenticate(t, tracker.Wrap(&icctest.AutherStub{UserID: 1}), "session1")
+	deleted := authenticate(t, tracker.Wrap(&icctest.AutherStub{UserID: 2}), "session2")
+	active := authenticate(t, tracker.Wrap(&icctest.AutherStub{UserID: 3}), "session3")
+
+	changes.Update(map[dskey.Key][]byte{
+		dskey.MustKey("user/1/is_active"): []byte("false"),
+		dskey.MustKey("user/2/id"):        nil,
+		dskey.MustKey("user/3/is_active"): []byte("true"),
+	}, nil)
+
+	for name, ctx := range map[string]context.Context{"deactivated": deactivated, "deleted": deleted} {
+		if !isDone(ctx) {
+			t.Fatalf("request of the %s user was not closed", name)
+		}
+
+		if err := context.Cause(ctx); !errors.Is(err, iccerror.ErrLoggedOut) {
+			t.Errorf("request of the %s user was closed with `%v`, expected `%v`", name, err, iccerror.ErrLoggedOut)
+		}
+	}
+
+	if isDone(active) {
+		t.Errorf("request of an active user was closed")
+	}
+}
+
+func TestLogoutEventIsForwarded(t *testing.T) {
+	bus := newMessageBusStub()
+	tracker, bg := session.New(bus)
+	go bg(t.Context(), nil)
+
+	bus.events <- []string{"session1", "session2"}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+	defer cancel()
+
+	sessionIDs, err := tracker.LogoutEvent(ctx)
+	if err != nil {
+		t.Fatalf("LogoutEvent: %v", err)
+	}
+
+	if got := strings.Join(sessionIDs, ","); got != "session1,session2" {
+		t.Errorf("LogoutEvent returned %s, expected session1,session2", got)
+	}
+}
+
+func TestRenewedToken(t *testing.T) {
+	bus := newMessageBusStub()
+	tracker, bg := session.New(bus)
+	go bg(t.Context(), nil)
+
+	auth := tracker.Wrap(&icctest.AutherStub{UserID: 1})
+
+	// The auth service writes a renewed token into the response header.
+	w := httptest.NewRecorder()
+	w.Header().Set("Authentication", token("renewed"))
+	r := httptest.NewRequest("GET", "/", nil).WithContext(t.Context())
+	r.Header.Set("Authentication", token("expired"))
+
+	ctx, err := auth.Authenticate(w, r)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+
+	bus.events <- []string{"renewed"}
+
+	if !isDone(ctx) {
+		t.Errorf("request with renewed token was not closed")
+	}
+}
+
+func TestLoggedOutMessage(t *testing.T) {
+	bus := newMessageBusStub()
+	tracker, bg := session.New(bus)
+	go bg(t.Context(), nil)
+
+	mux := http.NewServeMux()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		icchttp.ErrorNoStatus(w, context.Cause(r.Context()))
+	})
+	mux.Handle("/", icchttp.AuthMiddleware(handler, tracker.Wrap(&icctest.AutherStub{UserID: 1})))
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		bus.events <- []string{"session1"}
+	}()
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authentication", token("session1"))
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, r)
+
+	expect := `{"error":"logged-out","msg":"Your session was logged out."}`
+	if resp.Body.String() != expect {
+		t.Errorf("got body %q, expected %q", resp.Body.String(), expect)
+	}
+}
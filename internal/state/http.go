@@ -0,0 +1,152 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+	"github.com/OpenSlides/openslides-icc-service/internal/icchttp"
+)
+
+// Changer changes the state of a meeting.
+type Changer interface {
+	Set(ctx context.Context, meetingID, uid int, channelID, key string, value json.RawMessage, expected int64) (int64, error)
+	Delete(ctx context.Context, meetingID, uid int, key string, expected int64) error
+}
+
+// changeBody is the body of the set and delete routes.
+//
+// Version is the expected version of the key. If it is not set, the key is
+// changed without comparing its version.
+type changeBody struct {
+	ChannelID string          `json:"channel_id"`
+	Key       string          `json:"key"`
+	Value     json.RawMessage `json:"value"`
+	Version   *int64          `json:"version"`
+}
+
+func (b changeBody) expected() int64 {
+	if b.Version == nil {
+		return AnyVersion
+	}
+	return *b.Version
+}
+
+// HandleChange registers the icc/state/set and icc/state/delete routes.
+//
+// The set route returns the new version of the key. If the key does not have
+// the expected version, the routes return the status code 409.
+func HandleChange(mux *http.ServeMux, state Changer, auth icchttp.Authenticater) {
+	for action, call := range map[string]func(ctx context.Context, meetingID, uid int, body changeBody) (any, error){
+		"set": func(ctx context.Context, meetingID, uid int, body changeBody) (any, error) {
+			version, err := state.Set(ctx, meetingID, uid, body.ChannelID, body.Key, body.Value, body.expected())
+			if err != nil {
+				return nil, err
+			}
+			return map[string]int64{"version": version}, nil
+		},
+		"delete": func(ctx context.Context, meetingID, uid int, body changeBody) (any, error) {
+			return nil, state.Delete(ctx, meetingID, uid, body.Key, body.expected())
+		},
+	} {
+		url := icchttp.Path + "/state/" + action
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+
+			uid := auth.FromContext(r.Context())
+			if uid == 0 {
+				w.WriteHeader(401)
+				icchttp.ErrorNoStatus(w, iccerror.NewMessageError(iccerror.ErrNotAllowed, "Anonymous user can not change the state."))
+				return
+			}
+
+			meetingID, err := strconv.Atoi(r.URL.Query().Get("meeting_id"))
+			if err != nil {
+				icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrInvalid, "Query meeting_id has to be an int."))
+				return
+			}
+
+			var body changeBody
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrInvalid, "Body has to be a json object with the fields channel_id, key, value and version."))
+				return
+			}
+
+			response, err := call(r.Context(), meetingID, uid, body)
+			if err != nil {
+				icchttp.Error(w, fmt.Errorf("%s key: %w", action, err))
+				return
+			}
+
+			if response == nil {
+				return
+			}
+
+			if err := json.NewEncoder(w).Encode(response); err != nil {
+				icchttp.ErrorNoStatus(w, fmt.Errorf("writing response: %w", err))
+			}
+		})
+
+		mux.Handle(
+			url,
+			icchttp.AuthMiddleware(handler, auth),
+		)
+	}
+}
+
+// Receiver gets the state.
+type Receiver interface {
+	Receive(ctx context.Context, tid uint64, version int64, meetingID int) (newTID uint64, msg MSG, err error)
+	CanReceive(ctx context.Context, meetingID, userID int) error
+}
+
+// HandleReceive registers the icc/state route.
+//
+// The first message contains a snapshot of the state and the heartbeat
+// interval. The next messages contain the changed keys. On an idle stream, a
+// message with the current version and the field `heartbeat` is sent after the
+// interval.
+func HandleReceive(mux *http.ServeMux, state Receiver, auth icchttp.Authenticater, heartbeat time.Duration) {
+	url := icchttp.Path + "/state"
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store, max-age=0")
+
+		meetingID, err := strconv.Atoi(r.URL.Query().Get("meeting_id"))
+		if err != nil {
+			icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrInvalid, "Query meeting_id has to be an int."))
+			return
+		}
+
+		heartbeat, err := icchttp.HeartbeatInterval(r, heartbeat)
+		if err != nil {
+			icchttp.Error(w, err)
+			return
+		}
+
+		if err := state.CanReceive(r.Context(), meetingID, auth.FromContext(r.Context())); err != nil {
+			icchttp.Error(w, err)
+			return
+		}
+
+		receive := func(ctx context.Context, tid uint64, last MSG) (uint64, MSG, error) {
+			return state.Receive(ctx, tid, last.Version, meetingID)
+		}
+
+		// The heartbeat only contains the current version.
+		idle := func(last MSG) MSG {
+			return MSG{Version: last.Version}
+		}
+
+		icchttp.Stream(w, r, heartbeat, receive, idle)
+	})
+
+	mux.Handle(
+		url,
+		icchttp.AuthMiddleware(handler, auth),
+	)
+}
@@ -0,0 +1,102 @@
+package state_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+	"github.com/OpenSlides/openslides-icc-service/internal/icctest"
+	"github.com/OpenSlides/openslides-icc-service/internal/state"
+)
+
+func TestHandleChange(t *testing.T) {
+	t.Run("Anonymous", func(t *testing.T) {
+		changer := changerStub{}
+		mux := http.NewServeMux()
+		state.HandleChange(mux, &changer, &icctest.AutherStub{})
+		resp := httptest.NewRecorder()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("POST", "/system/icc/state/set?meeting_id=1", strings.NewReader(`{"key":"k","value":1}`)))
+
+		if resp.Result().StatusCode != 401 {
+			t.Fatalf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+		}
+
+		if !strings.Contains(resp.Body.String(), iccerror.ErrNotAllowed.Type()) {
+			t.Errorf("handler returned message `%s`, expected to contain `%s`", resp.Body.String(), iccerror.ErrNotAllowed.Type())
+		}
+
+		if changer.called != "" {
+			t.Errorf("handler did call %s", changer.called)
+		}
+	})
+
+	t.Run("set", func(t *testing.T) {
+		changer := changerStub{}
+		mux := http.NewServeMux()
+		state.HandleChange(mux, &changer, &icctest.AutherStub{UserID: 1})
+		resp := httptest.NewRecorder()
+
+		body := `{"channel_id":"server:1:1","key":"presenter","value":{"slide":3}}`
+		mux.ServeHTTP(resp, httptest.NewRequest("POST", "/system/icc/state/set?meeting_id=1", strings.NewReader(body)))
+
+		if resp.Result().StatusCode != 200 {
+			t.Fatalf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+		}
+
+		if changer.called != "set" || changer.calledChannel != "server:1:1" || changer.calledKey != "presenter" || changer.calledValue != `{"slide":3}` || changer.calledExpected != state.AnyVersion {
+			t.Errorf("handler called %s with channel %s, key %s, value %s and version %d", changer.called, changer.calledChannel, changer.calledKey, changer.calledValue, changer.calledExpected)
+		}
+
+		if got := strings.TrimSpace(resp.Body.String()); got != `{"version":5}` {
+			t.Errorf("handler returned %s, expected {\"version\":5}", got)
+		}
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		changer := changerStub{}
+		mux := http.NewServeMux()
+		state.HandleChange(mux, &changer, &icctest.AutherStub{UserID: 1})
+		resp := httptest.NewRecorder()
+
+		body := `{"channel_id":"server:1:1","key":"presenter","value":1,"version":99}`
+		mux.ServeHTTP(resp, httptest.NewRequest("POST", "/system/icc/state/set?meeting_id=1", strings.NewReader(body)))
+
+		if resp.Result().StatusCode != 409 {
+			t.Errorf("handler returned status %s, expected 409", resp.Result().Status)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		changer := changerStub{}
+		mux := http.NewServeMux()
+		state.HandleChange(mux, &changer, &icctest.AutherStub{UserID: 1})
+		resp := httptest.NewRecorder()
+
+		body := `{"key":"presenter","version":0}`
+		mux.ServeHTTP(resp, httptest.NewRequest("POST", "/system/icc/state/delete?meeting_id=1", strings.NewReader(body)))
+
+		if resp.Result().StatusCode != 200 {
+			t.Fatalf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+		}
+
+		if changer.called != "delete" || changer.calledKey != "presenter" || changer.calledExpected != 0 {
+			t.Errorf("handler called %s with key %s and version %d, expected delete, presenter and 0", changer.called, changer.calledKey, changer.calledExpected)
+		}
+	})
+
+	t.Run("invalid body", func(t *testing.T) {
+		changer := changerStub{}
+		mux := http.NewServeMux()
+		state.HandleChange(mux, &changer, &icctest.AutherStub{UserID: 1})
+		resp := httptest.NewRecorder()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("POST", "/system/icc/state/set?meeting_id=1", strings.NewReader("no json")))
+
+		if resp.Result().StatusCode != 400 {
+			t.Errorf("handler returned status %s, expected 400", resp.Result().Status)
+		}
+	})
+}
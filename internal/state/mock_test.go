@@ -0,0 +1,147 @@
+package state_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+	"github.com/OpenSlides/openslides-icc-service/internal/state"
+)
+
+type backendStub struct {
+	mu       sync.Mutex
+	versions map[int]int64
+	entries  map[int]map[string]state.Entry
+	touched  map[string]int64
+	released []string
+	changed  chan []byte
+}
+
+func newBackendStub() *backendStub {
+	return &backendStub{
+		versions: make(map[int]int64),
+		entries:  make(map[int]map[string]state.Entry),
+		touched:  make(map[string]int64),
+		changed:  make(chan []byte, 10),
+	}
+}
+
+func (b *backendStub) StateSet(meetingID int, key string, value []byte, owner string, expected, expiresAt int64) (int64, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	current := b.entries[meetingID][key].Version
+	if expected != state.AnyVersion && current != expected {
+		return current, false, nil
+	}
+
+	b.versions[meetingID]++
+	entry := state.Entry{Value: value, Version: b.versions[meetingID], Owner: owner}
+	if b.entries[meetingID] == nil {
+		b.entries[meetingID] = make(map[string]state.Entry)
+	}
+	b.entries[meetingID][key] = entry
+
+	return entry.Version, true, b.publish(state.Change{MeetingID: meetingID, Key: key, Version: entry.Version, Entry: &entry})
+}
+
+func (b *backendStub) StateDelete(meetingID int, key string, expected int64) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entry, exists := b.entries[meetingID][key]
+	if expected != state.AnyVersion && entry.Version != expected {
+		return false, nil
+	}
+
+	if !exists {
+		return true, nil
+	}
+
+	delete(b.entries[meetingID], key)
+	b.versions[meetingID]++
+	return true, b.publish(state.Change{MeetingID: meetingID, Key: key, Version: b.versions[meetingID]})
+}
+
+func (b *backendStub) publish(change state.Change) error {
+	encoded, err := json.Marshal(change)
+	if err != nil {
+		return fmt.Errorf("encoding change: %w", err)
+	}
+	b.changed <- encoded
+	return nil
+}
+
+func (b *backendStub) StateSnapshot(meetingID int) (int64, map[string][]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entries := make(map[string][]byte)
+	for key, entry := range b.entries[meetingID] {
+		encoded, err := json.Marshal(entry)
+		if err != nil {
+			return 0, nil, fmt.Errorf("encoding entry: %w", err)
+		}
+		entries[key] = encoded
+	}
+	return b.versions[meetingID], entries, nil
+}
+
+func (b *backendStub) StateTouch(owner string, expiresAt int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.touched[owner] = expiresAt
+	return nil
+}
+
+func (b *backendStub) StateRelease(owner string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.released = append(b.released, owner)
+	return nil
+}
+
+func (b *backendStub) StateExpire(now int64) error {
+	return nil
+}
+
+func (b *backendStub) StateChanged(ctx context.Context) ([]byte, error) {
+	select {
+	case change := <-b.changed:
+		return change, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type changerStub struct {
+	called         string
+	calledKey      string
+	calledValue    string
+	calledChannel  string
+	calledExpected int64
+}
+
+func (s *changerStub) Set(ctx context.Context, meetingID, uid int, channelID, key string, value json.RawMessage, expected int64) (int64, error) {
+	s.called = "set"
+	s.calledChannel = channelID
+	s.calledKey = key
+	s.calledValue = string(value)
+	s.calledExpected = expected
+
+	if expected == 99 {
+		return 0, iccerror.NewMessageError(iccerror.ErrConflict, "wrong version")
+	}
+	return 5, nil
+}
+
+func (s *changerStub) Delete(ctx context.Context, meetingID, uid int, key string, expected int64) error {
+	s.called = "delete"
+	s.calledKey = key
+	s.calledExpected = expected
+	return nil
+}
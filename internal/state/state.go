@@ -0,0 +1,415 @@
+// Package state holds a shared key-value state for each meeting.
+//
+// Clients can save ephemeral data like "who is editing what" in the state of a
+// meeting. Each change of a meeting increases the version of the meeting. The
+// receivers of a meeting get a snapshot of all keys first and afterwards only
+// the changed keys.
+//
+// Each key belongs to the notify channel, that saved it last. The key is
+// removed, when the channel is closed.
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/OpenSlides/openslides-go/datastore/dsfetch"
+	"github.com/OpenSlides/openslides-go/datastore/flow"
+	"github.com/OpenSlides/openslides-go/perm"
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+	"github.com/OpenSlides/openslides-icc-service/internal/icchttp"
+	"github.com/OpenSlides/openslides-icc-service/internal/meetingtopic"
+	"github.com/OpenSlides/openslides-icc-service/internal/notify"
+	"github.com/OpenSlides/openslides-icc-service/internal/ratelimit"
+	"github.com/ostcar/topic"
+)
+
+const (
+	// pruneTime is the time after that messages are removed from the topic.
+	pruneTime = 10 * time.Minute
+
+	// ownerTimeout is the time, the keys of a channel are kept after the last
+	// sign of life of the channel. It has to be longer than the keep alive
+	// interval of the notify service.
+	ownerTimeout = 30 * time.Second
+
+	// expireInterval is the time between two checks for expired channels.
+	expireInterval = time.Second
+
+	// maxValueSize is the maximum size of a value in bytes.
+	maxValueSize = 16 << 10
+)
+
+// AnyVersion can be used as expected version to change a key without
+// comparing its version.
+const AnyVersion int64 = -1
+
+// validKey is the format of a key.
+var validKey = regexp.MustCompile(`^[a-zA-Z0-9._:/-]{1,128}$`)
+
+// Backend stores the state of the meetings.
+type Backend interface {
+	// StateSet saves the value of a key in a meeting for the channel `owner`.
+	//
+	// The key is only saved, if its version is `expected`. A version of 0
+	// means, that the key does not exist. AnyVersion skips the comparison.
+	//
+	// Returns the new version of the key and true. If the version did not
+	// match, it returns the current version and false.
+	//
+	// The owner has to be kept at least until `expiresAt` as unix time in
+	// milliseconds.
+	StateSet(meetingID int, key string, value []byte, owner string, expected, expiresAt int64) (version int64, ok bool, err error)
+
+	// StateDelete removes a key of a meeting, if its version is `expected`.
+	// It returns false, if the version did not match.
+	StateDelete(meetingID int, key string, expected int64) (bool, error)
+
+	// StateSnapshot returns the current version of a meeting and its encoded
+	// entries.
+	StateSnapshot(meetingID int) (version int64, entries map[string][]byte, err error)
+
+	// StateTouch keeps the keys of an owner until `expiresAt`. It does
+	// nothing, if the owner has no keys.
+	StateTouch(owner string, expiresAt int64) error
+
+	// StateRelease removes all keys of an owner.
+	StateRelease(owner string) error
+
+	// StateExpire removes the keys of all owners, that expired before `now`.
+	//
+	// The function is called from many instances at the same time.
+	StateExpire(now int64) error
+
+	// StateChanged is a blocking function that returns the next encoded
+	// Change.
+	//
+	// It is expected, that only one goroutine is calling this function.
+	StateChanged(ctx context.Context) ([]byte, error)
+}
+
+// State holds the state of the service.
+type State struct {
+	backend   Backend
+	topic     *meetingtopic.Topic[Change]
+	datastore flow.Getter
+	limiter   *ratelimit.Limiter
+}
+
+// Option configures the state service.
+type Option func(*State)
+
+// WithRateLimit sets a rate limiter that is used for each change.
+func WithRateLimit(l *ratelimit.Limiter) Option {
+	return func(s *State) {
+		s.limiter = l
+	}
+}
+
+// New returns an initialized state of the state service.
+func New(b Backend, db flow.Getter, options ...Option) (*State, func(context.Context, func(error))) {
+	state := State{
+		backend:   b,
+		topic:     meetingtopic.New[Change](),
+		datastore: db,
+	}
+
+	for _, o := range options {
+		o(&state)
+	}
+
+	background := func(ctx context.Context, errHandler func(error)) {
+		go state.listen(ctx, errHandler)
+		go state.expire(ctx, errHandler)
+		go state.pruneOldData(ctx)
+	}
+
+	return &state, background
+}
+
+// Entry is the value of a key.
+//
+// Owner is the id of the channel, that saved the value.
+type Entry struct {
+	Value   json.RawMessage `json:"value"`
+	Version int64           `json:"version"`
+	Owner   string          `json:"owner"`
+}
+
+// Change is a changed key of a meeting. The entry is nil, if the key was
+// removed.
+type Change struct {
+	MeetingID int    `json:"meeting_id"`
+	Key       string `json:"key"`
+	Version   int64  `json:"version"`
+	Entry     *Entry `json:"entry"`
+}
+
+// MSG contains changed keys of a meeting.
+//
+// If Snapshot is true, the entries are all keys of the meeting. Else they are
+// the keys, that changed since the last message. A removed key has the value
+// null. Version is the version of the meeting after the changes.
+type MSG struct {
+	Snapshot bool              `json:"snapshot,omitempty"`
+	Version  int64             `json:"version"`
+	Entries  map[string]*Entry `json:"entries,omitempty"`
+
+	icchttp.StreamHeartbeat
+}
+
+// Set saves the value of a key for a channel of the user.
+//
+// The key is only saved, if it has the expected version. Returns the new
+// version of the key.
+func (s *State) Set(ctx context.Context, meetingID, userID int, channelID, key string, value json.RawMessage, expected int64) (int64, error) {
+	if err := s.checkPermission(ctx, meetingID, userID); err != nil {
+		return 0, err
+	}
+
+	if notify.ChannelUserID(channelID) != userID {
+		return 0, iccerror.NewMessageError(iccerror.ErrNotAllowed, "The channel %s does not belong to you.", channelID)
+	}
+
+	if err := validateKey(key); err != nil {
+		return 0, err
+	}
+
+	if len(value) > maxValueSize {
+		return 0, iccerror.NewMessageError(iccerror.ErrInvalid, "The value is bigger than %d bytes.", maxValueSize)
+	}
+
+	if len(value) == 0 || !json.Valid(value) {
+		return 0, iccerror.NewMessageError(iccerror.ErrInvalid, "The value has to be valid json.")
+	}
+
+	if err := s.limiter.Allow("state", userID, "", meetingID); err != nil {
+		return 0, fmt.Errorf("rate limit: %w", err)
+	}
+
+	expiresAt := time.Now().Add(ownerTimeout).UnixMilli()
+	version, ok, err := s.backend.StateSet(meetingID, key, value, channelID, expected, expiresAt)
+	if err != nil {
+		return 0, fmt.Errorf("saving key in backend: %w", err)
+	}
+
+	if !ok {
+		return 0, iccerror.NewMessageError(iccerror.ErrConflict, "The key %s has the version %d, not %d.", key, version, expected)
+	}
+
+	return version, nil
+}
+
+// Delete removes a key, if it has the expected version.
+func (s *State) Delete(ctx context.Context, meetingID, userID int, key string, expected int64) error {
+	if err := s.checkPermission(ctx, meetingID, userID); err != nil {
+		return err
+	}
+
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	if err := s.limiter.Allow("state", userID, "", meetingID); err != nil {
+		return fmt.Errorf("rate limit: %w", err)
+	}
+
+	ok, err := s.backend.StateDelete(meetingID, key, expected)
+	if err != nil {
+		return fmt.Errorf("deleting key in backend: %w", err)
+	}
+
+	if !ok {
+		return iccerror.NewMessageError(iccerror.ErrConflict, "The key %s does not have the version %d.", key, expected)
+	}
+
+	return nil
+}
+
+// CanReceive returns an error, if the user can not receive the state.
+func (s *State) CanReceive(ctx context.Context, meetingID, userID int) error {
+	return s.checkPermission(ctx, meetingID, userID)
+}
+
+func (s *State) checkPermission(ctx context.Context, meetingID, userID int) error {
+	if userID == 0 {
+		return iccerror.NewMessageError(iccerror.ErrNotAllowed, "Anonymous is not allowed to use the shared state.")
+	}
+
+	perms, err := perm.New(ctx, dsfetch.New(s.datastore), userID, meetingID)
+	if err != nil {
+		return fmt.Errorf("getting permissions: %w", err)
+	}
+
+	if !perms.Has(perm.MeetingCanSeeFrontpage) {
+		return iccerror.NewMessageError(iccerror.ErrNotAllowed, "You are not part of meeting %d.", meetingID)
+	}
+	return nil
+}
+
+func validateKey(key string) error {
+	if !validKey.MatchString(key) {
+		return iccerror.NewMessageError(iccerror.ErrInvalid, "The key has to match %s.", validKey)
+	}
+	return nil
+}
+
+// ChannelAlive keeps the keys of a channel.
+//
+// It implements the notify.ChannelKeeper interface.
+func (s *State) ChannelAlive(channelID string) error {
+	if err := s.backend.StateTouch(channelID, time.Now().Add(ownerTimeout).UnixMilli()); err != nil {
+		return fmt.Errorf("touching channel in backend: %w", err)
+	}
+	return nil
+}
+
+// ChannelClosed removes the keys of a channel.
+//
+// It implements the notify.ChannelKeeper interface.
+func (s *State) ChannelClosed(channelID string) error {
+	if err := s.backend.StateRelease(channelID); err != nil {
+		return fmt.Errorf("releasing channel in backend: %w", err)
+	}
+	return nil
+}
+
+// snapshot returns all keys of a meeting.
+func (s *State) snapshot(meetingID int) (MSG, error) {
+	version, encoded, err := s.backend.StateSnapshot(meetingID)
+	if err != nil {
+		return MSG{}, fmt.Errorf("fetching snapshot from backend: %w", err)
+	}
+
+	entries := make(map[string]*Entry, len(encoded))
+	for key, value := range encoded {
+		var entry Entry
+		if err := json.Unmarshal(value, &entry); err != nil {
+			return MSG{}, fmt.Errorf("decoding entry %s: %w", key, err)
+		}
+		entries[key] = &entry
+	}
+
+	return MSG{Snapshot: true, Version: version, Entries: entries}, nil
+}
+
+// Receive returns the state of a meeting.
+//
+// The first call with tid 0 returns a snapshot of the meeting. The next calls
+// block until there are changes with a higher version than `version`.
+func (s *State) Receive(ctx context.Context, tid uint64, version int64, meetingID int) (newTID uint64, msg MSG, err error) {
+	if tid == 0 {
+		// The topic id has to be read before the snapshot. Changes, that are
+		// part of the snapshot and the topic, are skipped by their version.
+		tid = s.topic.LastID()
+
+		msg, err := s.snapshot(meetingID)
+		if err != nil {
+			return 0, MSG{}, fmt.Errorf("getting snapshot: %w", err)
+		}
+
+		return tid, msg, nil
+	}
+
+	for {
+		var changes []Change
+		tid, changes, err = s.topic.Receive(ctx, tid, meetingID)
+		if err != nil {
+			var errUnknownID topic.UnknownIDError
+			if !errors.As(err, &errUnknownID) {
+				return 0, MSG{}, err
+			}
+
+			// Some changes were pruned. The client needs a new snapshot.
+			return s.Receive(ctx, 0, 0, meetingID)
+		}
+
+		msg := MSG{Version: version}
+		for _, change := range changes {
+			if change.Version <= msg.Version {
+				continue
+			}
+
+			if msg.Entries == nil {
+				msg.Entries = make(map[string]*Entry)
+			}
+			msg.Entries[change.Key] = change.Entry
+			msg.Version = change.Version
+		}
+
+		if msg.Entries != nil {
+			return tid, msg, nil
+		}
+	}
+}
+
+// listen waits for changes in the backend and saves them for the clients to
+// fetch.
+func (s *State) listen(ctx context.Context, errHandler func(error)) {
+	if errHandler == nil {
+		errHandler = func(error) {}
+	}
+
+	for {
+		change, err := s.backend.StateChanged(ctx)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+
+			errHandler(fmt.Errorf("receiving state changes from backend: %w", err))
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		var decoded Change
+		if err := json.Unmarshal(change, &decoded); err != nil {
+			errHandler(fmt.Errorf("decoding state change: %w", err))
+			continue
+		}
+
+		if err := s.topic.Publish(map[int]Change{decoded.MeetingID: decoded}); err != nil {
+			errHandler(err)
+		}
+	}
+}
+
+// expire removes the keys of channels, that did not send a sign of life.
+func (s *State) expire(ctx context.Context, errHandler func(error)) {
+	if errHandler == nil {
+		errHandler = func(error) {}
+	}
+
+	tick := time.NewTicker(expireInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			if err := s.backend.StateExpire(time.Now().UnixMilli()); err != nil {
+				errHandler(fmt.Errorf("expire state: %w", err))
+			}
+		}
+	}
+}
+
+// pruneOldData removes old messages from the topic.
+func (s *State) pruneOldData(ctx context.Context) {
+	tick := time.NewTicker(time.Minute)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			s.topic.Prune(time.Now().Add(-pruneTime))
+		}
+	}
+}
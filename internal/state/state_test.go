@@ -0,0 +1,200 @@
+package state_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/OpenSlides/openslides-go/datastore/dsmock"
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+	"github.com/OpenSlides/openslides-icc-service/internal/state"
+)
+
+func TestPermissions(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		permissions string
+		userID      int
+		call        func(ctx context.Context, s *state.State, userID int) error
+		allowed     bool
+	}{
+		{
+			"set anonymous",
+			"meeting.can_see_frontpage",
+			0,
+			func(ctx context.Context, s *state.State, userID int) error {
+				_, err := s.Set(ctx, 1, userID, "server:0:1", "key", []byte(`"value"`), state.AnyVersion)
+				return err
+			},
+			false,
+		},
+		{
+			"set",
+			"meeting.can_see_frontpage",
+			5,
+			func(ctx context.Context, s *state.State, userID int) error {
+				_, err := s.Set(ctx, 1, userID, "server:5:1", "key", []byte(`"value"`), state.AnyVersion)
+				return err
+			},
+			true,
+		},
+		{
+			"set without permission",
+			"",
+			5,
+			func(ctx context.Context, s *state.State, userID int) error {
+				_, err := s.Set(ctx, 1, userID, "server:5:1", "key", []byte(`"value"`), state.AnyVersion)
+				return err
+			},
+			false,
+		},
+		{
+			"set channel of other user",
+			"meeting.can_see_frontpage",
+			5,
+			func(ctx context.Context, s *state.State, userID int) error {
+				_, err := s.Set(ctx, 1, userID, "server:6:1", "key", []byte(`"value"`), state.AnyVersion)
+				return err
+			},
+			false,
+		},
+		{
+			"delete",
+			"meeting.can_see_frontpage",
+			5,
+			func(ctx context.Context, s *state.State, userID int) error {
+				return s.Delete(ctx, 1, userID, "key", state.AnyVersion)
+			},
+			true,
+		},
+		{
+			"delete without permission",
+			"",
+			5,
+			func(ctx context.Context, s *state.State, userID int) error {
+				return s.Delete(ctx, 1, userID, "key", state.AnyVersion)
+			},
+			false,
+		},
+		{
+			"receive anonymous",
+			"meeting.can_see_frontpage",
+			0,
+			func(ctx context.Context, s *state.State, userID int) error {
+				return s.CanReceive(ctx, 1, userID)
+			},
+			false,
+		},
+		{
+			"receive",
+			"meeting.can_see_frontpage",
+			5,
+			func(ctx context.Context, s *state.State, userID int) error {
+				return s.CanReceive(ctx, 1, userID)
+			},
+			true,
+		},
+		{
+			"receive without permission",
+			"",
+			5,
+			func(ctx context.Context, s *state.State, userID int) error {
+				return s.CanReceive(ctx, 1, userID)
+			},
+			false,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := dsmock.Stub(dsmock.YAMLData(fmt.Sprintf(`---
+			user/5/meeting_user_ids: [50]
+			meeting_user/50:
+				meeting_id: 1
+				user_id: 5
+				group_ids: [13]
+			group/13/permissions: [%s]
+			meeting/1/admin_group_id: 1
+			`, tt.permissions)))
+			s, _ := state.New(newBackendStub(), ds)
+
+			err := tt.call(t.Context(), s, tt.userID)
+
+			if tt.allowed {
+				if err != nil {
+					t.Errorf("got error `%v`, expected no error", err)
+				}
+				return
+			}
+
+			if !errors.Is(err, iccerror.ErrNotAllowed) {
+				t.Errorf("got error `%v`, expected `%v`", err, iccerror.ErrNotAllowed)
+			}
+		})
+	}
+}
+
+func TestReceive(t *testing.T) {
+	backend := newBackendStub()
+	s, bg := state.New(backend, nil)
+	go bg(t.Context(), nil)
+
+	if _, _, err := backend.StateSet(1, "presenter", []byte(`{"slide":3}`), "server:1:1", state.AnyVersion, 0); err != nil {
+		t.Fatalf("StateSet: %v", err)
+	}
+
+	tid, msg, err := s.Receive(t.Context(), 0, 0, 1)
+	if err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+
+	if !msg.Snapshot || msg.Version != 1 || len(msg.Entries) != 1 || string(msg.Entries["presenter"].Value) != `{"slide":3}` {
+		t.Errorf("first message is %+v, expected a snapshot with the key presenter in version 1", msg)
+	}
+
+	// A change in another meeting does not create a message.
+	if _, _, err := backend.StateSet(2, "other", []byte(`1`), "server:1:1", state.AnyVersion, 0); err != nil {
+		t.Fatalf("StateSet: %v", err)
+	}
+
+	if _, err := backend.StateDelete(1, "presenter", 1); err != nil {
+		t.Fatalf("StateDelete: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), time.Second)
+	defer cancel()
+
+	_, msg, err = s.Receive(ctx, tid, msg.Version, 1)
+	if err != nil {
+		t.Fatalf("Receive after change: %v", err)
+	}
+
+	if msg.Snapshot || msg.Version != 2 || len(msg.Entries) != 1 {
+		t.Fatalf("message after change is %+v, expected the changed key in version 2", msg)
+	}
+
+	if entry, ok := msg.Entries["presenter"]; !ok || entry != nil {
+		t.Errorf("removed key is %v, expected nil", entry)
+	}
+}
+
+func TestChannelKeeper(t *testing.T) {
+	backend := newBackendStub()
+	s, _ := state.New(backend, nil)
+
+	if err := s.ChannelAlive("server:1:1"); err != nil {
+		t.Fatalf("ChannelAlive: %v", err)
+	}
+
+	if backend.touched["server:1:1"] <= time.Now().UnixMilli() {
+		t.Errorf("channel is kept until %d, expected a time in the future", backend.touched["server:1:1"])
+	}
+
+	if err := s.ChannelClosed("server:1:1"); err != nil {
+		t.Fatalf("ChannelClosed: %v", err)
+	}
+
+	if len(backend.released) != 1 || backend.released[0] != "server:1:1" {
+		t.Errorf("released channels are %v, expected [server:1:1]", backend.released)
+	}
+}
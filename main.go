@@ -7,15 +7,27 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/OpenSlides/openslides-go/auth"
+	"github.com/OpenSlides/openslides-go/datastore/flow"
 	"github.com/OpenSlides/openslides-go/environment"
 	"github.com/OpenSlides/openslides-go/oslog"
 	messageBusRedis "github.com/OpenSlides/openslides-go/redis"
 	"github.com/OpenSlides/openslides-icc-service/internal/applause"
+	"github.com/OpenSlides/openslides-icc-service/internal/dschange"
+	"github.com/OpenSlides/openslides-icc-service/internal/hand"
 	"github.com/OpenSlides/openslides-icc-service/internal/icchttp"
+	"github.com/OpenSlides/openslides-icc-service/internal/metric"
 	"github.com/OpenSlides/openslides-icc-service/internal/notify"
+	"github.com/OpenSlides/openslides-icc-service/internal/poll"
+	"github.com/OpenSlides/openslides-icc-service/internal/ratelimit"
+	"github.com/OpenSlides/openslides-icc-service/internal/reaction"
 	"github.com/OpenSlides/openslides-icc-service/internal/redis"
+	"github.com/OpenSlides/openslides-icc-service/internal/session"
+	"github.com/OpenSlides/openslides-icc-service/internal/state"
 	"github.com/alecthomas/kong"
 )
 
@@ -23,8 +35,34 @@ import (
 
 var (
 	envICCServicePort = environment.NewVariable("ICC_PORT", "9007", "Port on which the service listen on.")
+	envICCMetricsPort = environment.NewVariable("ICC_METRICS_PORT", "", "Port on which the prometheus metrics are served under /metrics. The port should not be public. Metrics are disabled, if empty.")
 	envICCRedisHost   = environment.NewVariable("CACHE_HOST", "localhost", "The host of the redis instance to save icc messages.")
 	envICCRedisPort   = environment.NewVariable("CACHE_PORT", "6379", "The port of the redis instance to save icc messages.")
+
+	envHeartbeatInterval = environment.NewVariable("ICC_HEARTBEAT_INTERVAL", "30s", "Default interval in which heartbeats are written on idle notify and applause streams. Clients can request another interval. 0 disables heartbeats.")
+
+	envNotifySchemaDir     = environment.NewVariable("ICC_NOTIFY_SCHEMA_DIR", "", "Directory with json schema files to validate notify messages. The filename is used as message name. Validation is disabled, if empty.")
+	envNotifyRejectUnknown = environment.NewVariable("ICC_NOTIFY_REJECT_UNKNOWN", "false", "Reject notify messages with a name that has no json schema. Needs ICC_NOTIFY_SCHEMA_DIR.")
+	envNotifyMaxSize       = environment.NewVariable("ICC_NOTIFY_MAX_SIZE", "1048576", "Maximum size of a notify message in bytes. 0 means no limit.")
+	envNotifyDedupWindow   = environment.NewVariable("ICC_NOTIFY_DEDUP_WINDOW", "5m", "Duration in which notify messages with the same message_id from the same user are only published once.")
+	envNotifySenderDetails = environment.NewVariable("ICC_NOTIFY_SENDER_DETAILS", "false", "Add the meeting user id and the name of the sender to each notify message.")
+
+	envSlowConsumerPolicy = environment.NewVariable("ICC_SLOW_CONSUMER_POLICY", "disconnect", "What happens with a notify receiver that falls too far behind. `disconnect` closes the connection with a final error, `drop` skips the missed messages.")
+	envSlowConsumerMaxLag = environment.NewVariable("ICC_SLOW_CONSUMER_MAX_LAG", "1000", "Number of notify messages a receiver can fall behind before it is handled as too slow. 0 means no limit.")
+
+	envApplauseInterval    = environment.NewVariable("ICC_APPLAUSE_INTERVAL", "1s", "Time between two calculations of the applause levels.")
+	envApplauseWindow      = environment.NewVariable("ICC_APPLAUSE_WINDOW", "5s", "Time an applause is counted in meetings without an applause timeout.")
+	envApplauseMaxWindow   = environment.NewVariable("ICC_APPLAUSE_MAX_WINDOW", "1m", "Maximum time an applause is counted, even if the applause timeout of the meeting is longer.")
+	envApplausePruneTime   = environment.NewVariable("ICC_APPLAUSE_PRUNE_TIME", "10m", "Time after that old applause messages are removed from memory.")
+	envApplauseHoldTimeout = environment.NewVariable("ICC_APPLAUSE_HOLD_TIMEOUT", "10s", "Time a held applause is counted, if the client does not start it again.")
+	envApplauseParticles   = environment.NewVariable("ICC_APPLAUSE_PARTICLES", "clap,heart,star,confetti", "Comma separated list of particle ids, that can be sent in meetings with the particle applause type.")
+
+	envReactionTypes = environment.NewVariable("ICC_REACTION_TYPES", "applause,laugh,heart,question", "Comma separated list of reaction types for meetings without own reaction types.")
+
+	envRateLimitUser            = environment.NewVariable("ICC_RATE_LIMIT_USER", "100/10s", "Rate limit per user for notify messages, applause, reactions, raised hands, votes and state changes in the form `<requests>/<duration>`. 0 disables the limit.")
+	envRateLimitChannel         = environment.NewVariable("ICC_RATE_LIMIT_CHANNEL", "50/10s", "Rate limit per channel for notify messages in the form `<requests>/<duration>`. 0 disables the limit.")
+	envRateLimitMeeting         = environment.NewVariable("ICC_RATE_LIMIT_MEETING", "1000/10s", "Rate limit per meeting for notify messages, reactions, raised hands, votes and state changes in the form `<requests>/<duration>`. 0 disables the limit.")
+	envRateLimitApplauseMeeting = environment.NewVariable("ICC_RATE_LIMIT_APPLAUSE_MEETING", "0", "Rate limit per meeting for applause in the form `<requests>/<duration>`. Applause is sent by many users of a meeting at the same time, so the limit is disabled with 0 by default. The limit per user is still used.")
 )
 
 var cli struct {
@@ -109,23 +147,91 @@ func initService(lookup environment.Environmenter) (func(context.Context) error,
 		return nil, fmt.Errorf("init database: %w", err)
 	}
 
+	// Changes of the datastore.
+	changes, changesBackground := dschange.New()
+	backgroundTasks = append(backgroundTasks, changesBackground)
+
+	// Sessions get the logout events first to close the requests of the
+	// session with a message. The requests of deactivated or deleted users are
+	// closed as well.
+	sessions, sessionBackground := session.New(messageBus, session.WithChanges(changes, database))
+	backgroundTasks = append(backgroundTasks, sessionBackground)
+
 	// Auth Service.
-	authService, authBackground, err := auth.New(lookup, messageBus)
+	authService, authBackground, err := auth.New(lookup, sessions)
 	if err != nil {
 		return nil, fmt.Errorf("init auth system: %w", err)
 	}
 	backgroundTasks = append(backgroundTasks, authBackground)
 
+	if metricsPort := envICCMetricsPort.Value(lookup); metricsPort != "" {
+		backgroundTasks = append(backgroundTasks, func(ctx context.Context, errHandler func(error)) {
+			if err := metric.Serve(ctx, ":"+metricsPort); err != nil {
+				errHandler(fmt.Errorf("serving metrics: %w", err))
+			}
+		})
+	}
+
 	backend := redis.New(envICCRedisHost.Value(lookup) + ":" + envICCRedisPort.Value(lookup))
 
-	notifyService, notifyBackground := notify.New(backend)
+	heartbeat, err := time.ParseDuration(envHeartbeatInterval.Value(lookup))
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for `ICC_HEARTBEAT_INTERVAL`: %w", err)
+	}
+
+	limiter, err := initRateLimit(lookup, backend)
+	if err != nil {
+		return nil, fmt.Errorf("init rate limit: %w", err)
+	}
+
+	// The shared state has to be created before notify, since it keeps the
+	// keys of the notify channels.
+	stateService, stateBackground := state.New(backend, database, state.WithRateLimit(limiter))
+	backgroundTasks = append(backgroundTasks, stateBackground)
+
+	notifyOptions, err := initNotifyOptions(lookup, limiter, database)
+	if err != nil {
+		return nil, fmt.Errorf("init notify options: %w", err)
+	}
+	notifyOptions = append(notifyOptions, notify.WithChannelKeeper(stateService))
+
+	notifyService, notifyBackground := notify.New(backend, notifyOptions...)
 	backgroundTasks = append(backgroundTasks, notifyBackground)
 
-	applauseService, applauseBackground := applause.New(backend, database)
+	applauseOptions, err := initApplauseOptions(lookup, limiter, changes, notifyService)
+	if err != nil {
+		return nil, fmt.Errorf("init applause options: %w", err)
+	}
+
+	applauseService, applauseBackground := applause.New(backend, database, applauseOptions...)
 	backgroundTasks = append(backgroundTasks, applauseBackground)
 
+	reactionTypes := strings.Split(envReactionTypes.Value(lookup), ",")
+	if err := reaction.ValidateTypes(reactionTypes); err != nil {
+		return nil, fmt.Errorf("invalid value for `ICC_REACTION_TYPES`: %w", err)
+	}
+
+	reactionService, reactionBackground := reaction.New(
+		backend,
+		database,
+		reaction.WithRateLimit(limiter),
+		reaction.WithDefaultTypes(reactionTypes),
+	)
+	backgroundTasks = append(backgroundTasks, reactionBackground)
+
+	handService, handBackground := hand.New(backend, database, hand.WithRateLimit(limiter))
+	backgroundTasks = append(backgroundTasks, handBackground)
+
+	pollService, pollBackground := poll.New(
+		backend,
+		database,
+		poll.WithRateLimit(limiter),
+		poll.WithEventPublisher(notifyService),
+	)
+	backgroundTasks = append(backgroundTasks, pollBackground)
+
 	service := func(ctx context.Context) error {
-		go database.Update(ctx, nil)
+		go database.Update(ctx, changes.Update)
 
 		for _, bg := range backgroundTasks {
 			go bg(ctx, handleError)
@@ -133,20 +239,183 @@ func initService(lookup environment.Environmenter) (func(context.Context) error,
 
 		// Start http server.
 		fmt.Printf("Listen on %s\n", listenAddr)
-		return Run(ctx, listenAddr, notifyService, applauseService, authService)
+		services := Services{
+			Notify:   notifyService,
+			Applause: applauseService,
+			Reaction: reactionService,
+			Hand:     handService,
+			Poll:     pollService,
+			State:    stateService,
+		}
+		return Run(ctx, listenAddr, services, sessions.Wrap(authService), heartbeat)
 	}
 
 	return service, nil
 }
 
+// initNotifyOptions returns the options for the notify service from the
+// environment.
+func initNotifyOptions(lookup environment.Environmenter, limiter *ratelimit.Limiter, database flow.Getter) ([]notify.Option, error) {
+	maxSize, err := strconv.ParseInt(envNotifyMaxSize.Value(lookup), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for `ICC_NOTIFY_MAX_SIZE`: %w", err)
+	}
+
+	dedupWindow, err := time.ParseDuration(envNotifyDedupWindow.Value(lookup))
+	if err != nil || dedupWindow <= 0 {
+		return nil, fmt.Errorf("invalid value for `ICC_NOTIFY_DEDUP_WINDOW`: %s", envNotifyDedupWindow.Value(lookup))
+	}
+
+	options := []notify.Option{
+		notify.WithMaxMessageSize(maxSize),
+		notify.WithRateLimit(limiter),
+		notify.WithDeduplicationWindow(dedupWindow),
+	}
+
+	senderDetails, err := strconv.ParseBool(envNotifySenderDetails.Value(lookup))
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for `ICC_NOTIFY_SENDER_DETAILS`: %w", err)
+	}
+
+	if senderDetails {
+		options = append(options, notify.WithSenderDetails(database))
+	}
+
+	slowPolicy, err := notify.ParseSlowConsumerPolicy(envSlowConsumerPolicy.Value(lookup))
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for `ICC_SLOW_CONSUMER_POLICY`: %w", err)
+	}
+
+	maxLag, err := strconv.ParseUint(envSlowConsumerMaxLag.Value(lookup), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for `ICC_SLOW_CONSUMER_MAX_LAG`: %w", err)
+	}
+	options = append(options, notify.WithSlowConsumerPolicy(slowPolicy, maxLag))
+
+	schemaDir := envNotifySchemaDir.Value(lookup)
+	rejectUnknown, err := strconv.ParseBool(envNotifyRejectUnknown.Value(lookup))
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for `ICC_NOTIFY_REJECT_UNKNOWN`: %w", err)
+	}
+
+	if rejectUnknown && schemaDir == "" {
+		return nil, errors.New("`ICC_NOTIFY_REJECT_UNKNOWN` needs `ICC_NOTIFY_SCHEMA_DIR`")
+	}
+
+	if schemaDir != "" {
+		schemas, err := notify.LoadSchemas(schemaDir, rejectUnknown)
+		if err != nil {
+			return nil, fmt.Errorf("loading notify schemas: %w", err)
+		}
+		oslog.Info("Loaded %d notify schemas from %s", schemas.Len(), schemaDir)
+		options = append(options, notify.WithSchemas(schemas))
+	}
+
+	return options, nil
+}
+
+// initApplauseOptions returns the options for the applause service from the
+// environment.
+func initApplauseOptions(lookup environment.Environmenter, limiter *ratelimit.Limiter, changes *dschange.Watcher, events applause.EventPublisher) ([]applause.Option, error) {
+	interval, err := time.ParseDuration(envApplauseInterval.Value(lookup))
+	if err != nil || interval <= 0 {
+		return nil, fmt.Errorf("invalid value for `ICC_APPLAUSE_INTERVAL`: %s", envApplauseInterval.Value(lookup))
+	}
+
+	window, err := time.ParseDuration(envApplauseWindow.Value(lookup))
+	if err != nil || window <= 0 {
+		return nil, fmt.Errorf("invalid value for `ICC_APPLAUSE_WINDOW`: %s", envApplauseWindow.Value(lookup))
+	}
+
+	maxWindow, err := time.ParseDuration(envApplauseMaxWindow.Value(lookup))
+	if err != nil || maxWindow <= 0 {
+		return nil, fmt.Errorf("invalid value for `ICC_APPLAUSE_MAX_WINDOW`: %s", envApplauseMaxWindow.Value(lookup))
+	}
+
+	pruneTime, err := time.ParseDuration(envApplausePruneTime.Value(lookup))
+	if err != nil || pruneTime <= 0 {
+		return nil, fmt.Errorf("invalid value for `ICC_APPLAUSE_PRUNE_TIME`: %s", envApplausePruneTime.Value(lookup))
+	}
+
+	holdTimeout, err := time.ParseDuration(envApplauseHoldTimeout.Value(lookup))
+	if err != nil || holdTimeout <= 0 {
+		return nil, fmt.Errorf("invalid value for `ICC_APPLAUSE_HOLD_TIMEOUT`: %s", envApplauseHoldTimeout.Value(lookup))
+	}
+
+	meetingLimit, err := ratelimit.ParseLimit(envRateLimitApplauseMeeting.Value(lookup))
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for `ICC_RATE_LIMIT_APPLAUSE_MEETING`: %w", err)
+	}
+
+	particles := strings.Split(envApplauseParticles.Value(lookup), ",")
+	if err := applause.ValidateParticles(particles); err != nil {
+		return nil, fmt.Errorf("invalid value for `ICC_APPLAUSE_PARTICLES`: %w", err)
+	}
+
+	return []applause.Option{
+		applause.WithRateLimit(limiter.WithMeetingLimit(meetingLimit)),
+		applause.WithChanges(changes),
+		applause.WithInterval(interval),
+		applause.WithWindow(window, maxWindow),
+		applause.WithPruneTime(pruneTime),
+		applause.WithHoldTimeout(holdTimeout),
+		applause.WithParticles(particles),
+		applause.WithEventPublisher(events),
+	}, nil
+}
+
+// initRateLimit creates the rate limiter from the environment.
+func initRateLimit(lookup environment.Environmenter, backend ratelimit.Backend) (*ratelimit.Limiter, error) {
+	user, err := ratelimit.ParseLimit(envRateLimitUser.Value(lookup))
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for `ICC_RATE_LIMIT_USER`: %w", err)
+	}
+
+	channel, err := ratelimit.ParseLimit(envRateLimitChannel.Value(lookup))
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for `ICC_RATE_LIMIT_CHANNEL`: %w", err)
+	}
+
+	meeting, err := ratelimit.ParseLimit(envRateLimitMeeting.Value(lookup))
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for `ICC_RATE_LIMIT_MEETING`: %w", err)
+	}
+
+	return ratelimit.New(backend, user, channel, meeting), nil
+}
+
+// Services are the services, that are served by Run.
+type Services struct {
+	Notify   *notify.Notify
+	Applause *applause.Applause
+	Reaction *reaction.Reaction
+	Hand     *hand.Hand
+	Poll     *poll.Poll
+	State    *state.State
+}
+
 // Run starts a webserver
-func Run(ctx context.Context, addr string, notifyService *notify.Notify, applauseService *applause.Applause, auth icchttp.Authenticater) error {
+func Run(ctx context.Context, addr string, services Services, auth icchttp.Authenticater, heartbeat time.Duration) error {
 	mux := http.NewServeMux()
 	icchttp.HandleHealth(mux)
-	notify.HandleReceive(mux, notifyService, auth)
-	notify.HandlePublish(mux, notifyService, auth)
-	applause.HandleReceive(mux, applauseService, auth)
-	applause.HandleSend(mux, applauseService, auth)
+	icchttp.HandleTime(mux)
+	notify.HandleReceive(mux, services.Notify, auth, heartbeat)
+	notify.HandlePublish(mux, services.Notify, auth)
+	applause.HandleReceive(mux, services.Applause, auth, heartbeat)
+	applause.HandleSend(mux, services.Applause, auth)
+	applause.HandleHold(mux, services.Applause, auth)
+	applause.HandleHistory(mux, services.Applause, auth)
+	applause.HandleThresholds(mux, services.Applause, auth)
+	reaction.HandleReceive(mux, services.Reaction, auth, heartbeat)
+	reaction.HandleSend(mux, services.Reaction, auth)
+	reaction.HandleSetTypes(mux, services.Reaction, auth)
+	hand.HandleReceive(mux, services.Hand, auth, heartbeat)
+	hand.HandleChange(mux, services.Hand, auth)
+	poll.HandleReceive(mux, services.Poll, auth, heartbeat)
+	poll.HandleOpen(mux, services.Poll, auth)
+	poll.HandleVote(mux, services.Poll, auth)
+	state.HandleReceive(mux, services.State, auth, heartbeat)
+	state.HandleChange(mux, services.State, auth)
 
 	srv := &http.Server{
 		Addr:        addr,